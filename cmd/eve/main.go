@@ -32,7 +32,9 @@ import (
 	"github.com/BasedDevelopment/eve/internal/config"
 	"github.com/BasedDevelopment/eve/internal/controllers"
 	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/metrics"
 	"github.com/BasedDevelopment/eve/internal/server"
+	"github.com/BasedDevelopment/eve/internal/tasks"
 	"github.com/BasedDevelopment/eve/pkg/fwdlog"
 
 	"github.com/rs/zerolog/log"
@@ -70,15 +72,46 @@ func main() {
 	db.Init(config.Config.Database.URL)
 	auto.Init()
 
+	// Start the workers for long-running operations
+	tasks.Init(config.Config.Tasks.Workers)
+
 	// Creating the Cloud
 	cloud := controllers.InitCloud()
 
-	// Get HVs
+	// Start monitoring HVs, which connects to them and keeps them up to date
 	log.Info().Msg("Connecting to hypervisors")
 
 	for i := range cloud.HVs {
-		hv := cloud.HVs[i]
-		go connHV(hv)
+		cloud.HVs[i].StartMonitor()
+	}
+
+	// Run the backup policies when they are due
+	backupCtx, stopBackups := context.WithCancel(context.Background())
+	controllers.StartBackupScheduler(backupCtx)
+
+	// Metrics have their own listener, so they can stay off the public API
+	var metricsSrv *http.Server
+	if config.Config.Metrics.Enabled {
+		metrics.Registry.MustRegister(controllers.NewCollector(cloud))
+
+		metricsSrv = &http.Server{
+			Addr:     config.Config.Metrics.Host + ":" + strconv.Itoa(config.Config.Metrics.Port),
+			Handler:  server.Metrics(),
+			ErrorLog: fwdlog.Logger(),
+		}
+
+		log.Info().
+			Str("host", config.Config.Metrics.Host).
+			Int("port", config.Config.Metrics.Port).
+			Msg("Metrics server listening")
+
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal().
+					Err(err).
+					Msg("Failed to start metrics listener")
+			}
+		}()
 	}
 
 	// This logs before the HTTP server actually starts; Not ideal, we should find something better
@@ -125,6 +158,29 @@ func main() {
 			log.Info().Msg("Webserver shutdown success")
 		}
 
+		// Metrics
+		if metricsSrv != nil {
+			if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+				log.Warn().
+					Err(err).
+					Msg("Failed to shutdown metrics listener")
+			} else {
+				log.Info().Msg("Metrics server shutdown success")
+			}
+		}
+
+		// Backup scheduler, before the workers it queues tasks for
+		stopBackups()
+
+		// Task workers
+		if err := tasks.Stop(shutdownCtx); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Failed to wait for running tasks")
+		} else {
+			log.Info().Msg("Task workers shutdown success")
+		}
+
 		// Database pool
 		db.Pool.Close()
 		log.Info().Msg("Database pool shutdown success")
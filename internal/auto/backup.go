@@ -0,0 +1,87 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package auto
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Backup of a domain's disks, as returned by auto. Size is in bytes, the
+// checksum is the sha256 of the backup archive.
+type Backup struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+func (a *Auto) backupUrl(vmid string, name string) string {
+	return a.Url + "/libvirt/domains/" + vmid + "/backups/" + name
+}
+
+// Back the domain up as name into the directory at path
+func (a *Auto) CreateBackup(vmid string, name string, path string) (backup Backup, err error) {
+	respBytes, status, err := a.httpReq("PUT", a.backupUrl(vmid, name), Backup{Path: path})
+
+	if err != nil {
+		return
+	}
+
+	if status != http.StatusCreated {
+		respBody := string(respBytes)
+		return backup, fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	err = json.Unmarshal(respBytes, &backup)
+
+	return
+}
+
+// Restore the disks of the domain from a backup, the domain has to be shut off
+func (a *Auto) RestoreBackup(vmid string, name string, path string) error {
+	respBytes, status, err := a.httpReq("POST", a.backupUrl(vmid, name)+"/restore", Backup{Path: path})
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
+
+func (a *Auto) DeleteBackup(vmid string, name string, path string) error {
+	respBytes, status, err := a.httpReq("DELETE", a.backupUrl(vmid, name)+"?path="+url.QueryEscape(path), nil)
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package auto
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Disk of a domain, as sent to auto. Sizes are in bytes.
+type Disk struct {
+	Path string `json:"path,omitempty"`
+	Size int64  `json:"size"`
+}
+
+func (a *Auto) diskUrl(vmid string, name string) string {
+	return a.Url + "/libvirt/domains/" + vmid + "/disks/" + name
+}
+
+// Create a volume in the pool at path and attach it to the domain as name
+func (a *Auto) AttachDisk(vmid string, name string, path string, size int64) error {
+	respBytes, status, err := a.httpReq("PUT", a.diskUrl(vmid, name), Disk{Path: path, Size: size})
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusCreated {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
+
+// Grow the volume behind a disk of the domain
+func (a *Auto) ResizeDisk(vmid string, name string, size int64) error {
+	respBytes, status, err := a.httpReq("PATCH", a.diskUrl(vmid, name), Disk{Size: size})
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
+
+// Detach a disk from the domain and delete its volume
+func (a *Auto) DetachDisk(vmid string, name string) error {
+	respBytes, status, err := a.httpReq("DELETE", a.diskUrl(vmid, name), nil)
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
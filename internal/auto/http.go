@@ -7,9 +7,31 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/metrics"
 )
 
+// observe records the latency and result of a request to auto
+func (a *Auto) observe(method string, start time.Time, status int, err error) {
+	hv := strings.TrimPrefix(a.Url, "https://")
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	} else if status >= 400 {
+		result = "http_error"
+	}
+
+	metrics.AutoRequests.WithLabelValues(hv, method, result).Inc()
+	metrics.AutoDuration.WithLabelValues(hv, method).Observe(time.Since(start).Seconds())
+}
+
 func (a *Auto) httpReq(method string, urlStr string, data any) (respBodyBytes []byte, status int, err error) {
+	start := time.Now()
+	defer func() { a.observe(method, start, status, err) }()
+
 	c := a.getHttpsClient()
 
 	switch method {
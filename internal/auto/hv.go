@@ -1,11 +1,34 @@
 package auto
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"net"
+	"net/url"
+	"time"
 
 	"github.com/BasedDevelopment/auto/pkg/models"
 )
 
+const handshakeTimeout = 5 * time.Second
+
+// Handshake dials auto and completes a TLS handshake, which verifies both the
+// certificate chain and that the serial matches the one we have on record
+func (a *Auto) Handshake() error {
+	u, err := url.Parse(a.Url)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{Timeout: handshakeTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", u.Host, a.getTLSConfig())
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
 func (a *Auto) GetHVSpecs() (hv models.HV, err error) {
 	url := a.Url + "/libvirt"
 
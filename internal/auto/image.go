@@ -0,0 +1,49 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package auto
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Image file to have in a storage pool, as sent to auto. Auto downloads it
+// from the source unless the file at path already has the checksum, without
+// a source it only checks the file.
+type Image struct {
+	Path     string `json:"path"`
+	Source   string `json:"source,omitempty"`
+	Checksum string `json:"checksum"` // sha256
+}
+
+// Make sure the image is at its path with its checksum
+func (a *Auto) SyncImage(img Image) error {
+	respBytes, status, err := a.httpReq("PUT", a.Url+"/libvirt/images", img)
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK && status != http.StatusCreated {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 
 	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/BasedDevelopment/eve/internal/metrics"
 	"github.com/BasedDevelopment/eve/pkg/pki"
 	"github.com/BasedDevelopment/eve/pkg/util"
 	"github.com/rs/zerolog/log"
@@ -113,6 +114,9 @@ func (a *Auto) WSProxy(wsUrl *url.URL, w http.ResponseWriter, r *http.Request) {
 		TLSClientConfig: tlsConfig,
 	}
 
+	metrics.ConsoleProxies.Inc()
+	defer metrics.ConsoleProxies.Dec()
+
 	rr := r.Clone(r.Context())
 	rr.URL.Path = ""
 	rr.URL.RawQuery = ""
@@ -0,0 +1,74 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package auto
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Where a disk of a migrating domain goes on the target hypervisor
+type MigrationDisk struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type Migration struct {
+	Target string          `json:"target"` // hostname of the target hypervisor
+	Live   bool            `json:"live"`
+	Disks  []MigrationDisk `json:"disks"`
+}
+
+// Move the domain to another hypervisor, returning once it is done
+func (a *Auto) MigrateVM(vmid string, m Migration) error {
+	respBytes, status, err := a.httpReq("POST", a.Url+"/libvirt/domains/"+vmid+"/migrate", m)
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
+
+// How far along the running migration of the domain is, in percent
+func (a *Auto) MigrationProgress(vmid string) (int, error) {
+	respBytes, status, err := a.httpReq("GET", a.Url+"/libvirt/domains/"+vmid+"/migrate", nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return 0, fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	var progress struct {
+		Progress int `json:"progress"`
+	}
+	err = json.Unmarshal(respBytes, &progress)
+
+	return progress.Progress, err
+}
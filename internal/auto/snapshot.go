@@ -0,0 +1,83 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package auto
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Snapshot of a domain, as returned by auto. Size is in bytes.
+type Snapshot struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func (a *Auto) snapshotUrl(vmid string, name string) string {
+	return a.Url + "/libvirt/domains/" + vmid + "/snapshots/" + name
+}
+
+func (a *Auto) CreateSnapshot(vmid string, name string) (snapshot Snapshot, err error) {
+	respBytes, status, err := a.httpReq("PUT", a.snapshotUrl(vmid, name), Snapshot{Name: name})
+
+	if err != nil {
+		return
+	}
+
+	if status != http.StatusCreated {
+		respBody := string(respBytes)
+		return snapshot, fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	err = json.Unmarshal(respBytes, &snapshot)
+
+	return
+}
+
+// Bring the domain back to the snapshot, the domain has to be shut off
+func (a *Auto) RevertSnapshot(vmid string, name string) error {
+	respBytes, status, err := a.httpReq("POST", a.snapshotUrl(vmid, name)+"/revert", Snapshot{Name: name})
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
+
+func (a *Auto) DeleteSnapshot(vmid string, name string) error {
+	respBytes, status, err := a.httpReq("DELETE", a.snapshotUrl(vmid, name), nil)
+
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	return nil
+}
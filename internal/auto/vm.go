@@ -146,3 +146,31 @@ func (a *Auto) DeleteVM(vmid string) error {
 
 	return nil
 }
+
+// Hardware changes to a domain, memory is in MB like on creation
+type VMUpdate struct {
+	CPU    int `json:"cpu"`
+	Memory int `json:"memory"`
+}
+
+// Whether auto was able to apply the changes to the running domain as well
+type VMUpdateResult struct {
+	Live bool `json:"live"`
+}
+
+func (a *Auto) UpdateVM(vmid string, update VMUpdate) (res VMUpdateResult, err error) {
+	reqUrl := a.Url + "/libvirt/domains/" + vmid
+	respBytes, status, err := a.httpReq("PATCH", reqUrl, update)
+
+	if err != nil {
+		return
+	}
+
+	if status != http.StatusOK {
+		respBody := string(respBytes)
+		return res, fmt.Errorf("status code %d: %s", status, respBody)
+	}
+
+	err = json.Unmarshal(respBytes, &res)
+	return
+}
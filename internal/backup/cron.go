@@ -0,0 +1,182 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Schedule is a parsed cron expression: minute, hour, day of month, month
+// and day of week. Each field is a bitset of the values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Set when the day fields are restricted; as in cron, a day matches when
+	// either of them does if both are
+	domSet, dowSet bool
+}
+
+var macros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fields = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 7 is sunday too
+}
+
+// Parse a five field cron expression, or one of @hourly, @daily, @weekly and
+// @monthly. Fields take *, numbers, ranges (a-b), lists (a,b) and steps (*/n,
+// a-b/n).
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := macros[spec]; ok {
+		spec = expanded
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("%w: expected %d fields, got %d", ErrInvalidSchedule, len(fields), len(parts))
+	}
+
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return Schedule{}, err
+		}
+		sets[i] = set
+	}
+
+	// Fold sunday as 7 onto 0
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	return Schedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domSet: parts[2] != "*",
+		dowSet: parts[4] != "*",
+	}, nil
+}
+
+func parseField(field string, b bounds) (set uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		first, last, step := b.min, b.max, 1
+
+		rng := item
+		if i := strings.IndexByte(item, '/'); i != -1 {
+			rng = item[:i]
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("%w: bad step in %s %q", ErrInvalidSchedule, b.name, item)
+			}
+		}
+
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			lo, hi, _ := strings.Cut(rng, "-")
+			if first, err = strconv.Atoi(lo); err != nil {
+				return 0, fmt.Errorf("%w: bad range in %s %q", ErrInvalidSchedule, b.name, item)
+			}
+			if last, err = strconv.Atoi(hi); err != nil {
+				return 0, fmt.Errorf("%w: bad range in %s %q", ErrInvalidSchedule, b.name, item)
+			}
+		default:
+			if first, err = strconv.Atoi(rng); err != nil {
+				return 0, fmt.Errorf("%w: bad value in %s %q", ErrInvalidSchedule, b.name, item)
+			}
+			// A single value with a step runs to the end of the range
+			if step == 1 {
+				last = first
+			}
+		}
+
+		if first < b.min || last > b.max || first > last {
+			return 0, fmt.Errorf("%w: %s %q is out of range %d-%d", ErrInvalidSchedule, b.name, item, b.min, b.max)
+		}
+
+		for v := first; v <= last; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+
+	if s.domSet && s.dowSet {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Next returns the first time after t that the schedule matches, in t's
+// location. It returns the zero time if nothing matches within five years,
+// as with 30 2 31 2 *.
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
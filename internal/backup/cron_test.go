@@ -0,0 +1,69 @@
+//go:build !integration
+// +build !integration
+
+package backup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"-1 * * * *",
+		"@yearly",
+	} {
+		_, err := Parse(spec)
+		assert.True(t, errors.Is(err, ErrInvalidSchedule), spec)
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Thursday
+	from := time.Date(2026, 10, 15, 10, 17, 30, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 15, 10, 18, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 15, 10, 30, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2026, 10, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2026, 10, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1,3", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields set: either one matches
+		{"0 0 20 * 6", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		s, err := Parse(c.spec)
+		assert.NoError(t, err, c.spec)
+		assert.Equal(t, c.next, s.Next(from), c.spec)
+	}
+
+	// Never matches
+	s, err := Parse("0 0 31 2 *")
+	assert.NoError(t, err)
+	assert.True(t, s.Next(from).IsZero())
+}
@@ -0,0 +1,99 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Retention says which backups of a VM to keep. A backup is kept when any
+// rule keeps it. With every rule at zero all backups are kept.
+type Retention struct {
+	KeepLast    int `json:"keep_last"`    // the newest n
+	KeepDaily   int `json:"keep_daily"`   // the newest of each of the last n days
+	KeepWeekly  int `json:"keep_weekly"`  // the newest of each of the last n ISO weeks
+	KeepMonthly int `json:"keep_monthly"` // the newest of each of the last n months
+}
+
+// A backup as seen by retention
+type Point struct {
+	ID      uuid.UUID
+	Created time.Time
+}
+
+func (r Retention) keepsAll() bool {
+	return r.KeepLast == 0 && r.KeepDaily == 0 && r.KeepWeekly == 0 && r.KeepMonthly == 0
+}
+
+// Expired returns the backups that no rule keeps, oldest first. Days, weeks
+// and months are those of the backups' creation times in loc.
+func (r Retention) Expired(points []Point, loc *time.Location) []uuid.UUID {
+	if r.keepsAll() {
+		return nil
+	}
+
+	sorted := make([]Point, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Created.After(sorted[j].Created)
+	})
+
+	keep := make(map[uuid.UUID]bool)
+	for i := 0; i < r.KeepLast && i < len(sorted); i++ {
+		keep[sorted[i].ID] = true
+	}
+
+	// Newest first, so the first backup of each period is the one kept
+	bucket := func(n int, period func(time.Time) [2]int) {
+		seen := make(map[[2]int]bool)
+		for _, p := range sorted {
+			if len(seen) >= n {
+				return
+			}
+			key := period(p.Created.In(loc))
+			if !seen[key] {
+				seen[key] = true
+				keep[p.ID] = true
+			}
+		}
+	}
+
+	bucket(r.KeepDaily, func(t time.Time) [2]int {
+		return [2]int{t.Year(), t.YearDay()}
+	})
+	bucket(r.KeepWeekly, func(t time.Time) [2]int {
+		year, week := t.ISOWeek()
+		return [2]int{year, week}
+	})
+	bucket(r.KeepMonthly, func(t time.Time) [2]int {
+		return [2]int{t.Year(), int(t.Month())}
+	})
+
+	var expired []uuid.UUID
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if !keep[sorted[i].ID] {
+			expired = append(expired, sorted[i].ID)
+		}
+	}
+
+	return expired
+}
@@ -0,0 +1,85 @@
+//go:build !integration
+// +build !integration
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// One backup at 01:00 and one at 13:00 for each of the 60 days up to `last`
+func twiceDaily(last time.Time) []Point {
+	var points []Point
+	for d := 0; d < 60; d++ {
+		day := last.AddDate(0, 0, -d)
+		for _, h := range []int{1, 13} {
+			points = append(points, Point{
+				ID:      uuid.New(),
+				Created: time.Date(day.Year(), day.Month(), day.Day(), h, 0, 0, 0, time.UTC),
+			})
+		}
+	}
+	return points
+}
+
+func kept(points []Point, expired []uuid.UUID) []time.Time {
+	gone := make(map[uuid.UUID]bool)
+	for _, id := range expired {
+		gone[id] = true
+	}
+
+	var times []time.Time
+	for _, p := range points {
+		if !gone[p.ID] {
+			times = append(times, p.Created)
+		}
+	}
+	return times
+}
+
+func TestExpiredKeepsAll(t *testing.T) {
+	points := twiceDaily(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))
+	assert.Empty(t, Retention{}.Expired(points, time.UTC))
+}
+
+func TestExpired(t *testing.T) {
+	// Sunday
+	points := twiceDaily(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))
+
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	expired := Retention{KeepLast: 3}.Expired(points, time.UTC)
+	assert.Len(t, expired, len(points)-3)
+	assert.ElementsMatch(t, []time.Time{
+		at(10, 18, 13), at(10, 18, 1), at(10, 17, 13),
+	}, kept(points, expired))
+
+	expired = Retention{KeepDaily: 2, KeepWeekly: 2, KeepMonthly: 3}.Expired(points, time.UTC)
+	assert.ElementsMatch(t, []time.Time{
+		at(10, 18, 13), // today, this week and this month
+		at(10, 17, 13), // yesterday
+		at(10, 11, 13), // last week, ending on sunday
+		at(9, 30, 13),  // last month
+		at(8, 31, 13),  // the month before
+	}, kept(points, expired))
+
+	// Oldest first
+	for i := 1; i < len(expired); i++ {
+		assert.True(t, find(points, expired[i-1]).Before(find(points, expired[i])))
+	}
+}
+
+func find(points []Point, id uuid.UUID) time.Time {
+	for _, p := range points {
+		if p.ID == id {
+			return p.Created
+		}
+	}
+	return time.Time{}
+}
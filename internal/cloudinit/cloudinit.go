@@ -0,0 +1,194 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Largest user-data handed to auto, snippets included
+const MaxUserData = 64 * 1024
+
+var ErrPasswordNoUser = errors.New("a password needs a user to set it for")
+
+// What the guest is set up with on first boot
+type Config struct {
+	User         string   // created with sudo, the keys and the password
+	SSHKeys      []string // authorized_keys lines
+	PasswordHash string   // crypt(3), the password itself never gets here
+	Packages     []string // installed after a package index update
+	Snippets     []string // user-data of the user, merged in after ours
+}
+
+// A NIC of the guest and what IPAM gave it
+type NIC struct {
+	MAC       string
+	Addresses []netip.Prefix // the address with the prefix length of its pool
+	Gateways  []netip.Addr   // at most one per address family
+	DNS       []netip.Addr
+}
+
+type user struct {
+	Name              string   `yaml:"name"`
+	Sudo              string   `yaml:"sudo"`
+	Shell             string   `yaml:"shell"`
+	LockPasswd        bool     `yaml:"lock_passwd"`
+	Passwd            string   `yaml:"passwd,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type cloudConfig struct {
+	Users             []user   `yaml:"users,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"` // of the image's default user
+	PackageUpdate     bool     `yaml:"package_update,omitempty"`
+	Packages          []string `yaml:"packages,omitempty"`
+}
+
+// MetaData is the NoCloud meta-data of a VM
+func MetaData(id uuid.UUID, hostname string) (string, error) {
+	out, err := yaml.Marshal(struct {
+		InstanceID    string `yaml:"instance-id"`
+		LocalHostname string `yaml:"local-hostname"`
+	}{id.String(), hostname})
+
+	return string(out), err
+}
+
+// cloudConfig is the #cloud-config part of the user-data
+func (c Config) cloudConfig() (string, error) {
+	var cc cloudConfig
+
+	if c.User != "" {
+		cc.Users = []user{{
+			Name:              c.User,
+			Sudo:              "ALL=(ALL) NOPASSWD:ALL",
+			Shell:             "/bin/bash",
+			LockPasswd:        c.PasswordHash == "",
+			Passwd:            c.PasswordHash,
+			SSHAuthorizedKeys: c.SSHKeys,
+		}}
+	} else if c.PasswordHash != "" {
+		return "", ErrPasswordNoUser
+	} else {
+		cc.SSHAuthorizedKeys = c.SSHKeys
+	}
+
+	if len(c.Packages) > 0 {
+		cc.PackageUpdate = true
+		cc.Packages = c.Packages
+	}
+
+	out, err := yaml.Marshal(cc)
+	if err != nil {
+		return "", err
+	}
+
+	return "#cloud-config\n" + string(out), nil
+}
+
+// UserData is the user-data of a VM, a multipart archive when the config has
+// snippets of the user to merge in. The result is validated.
+func UserData(c Config) (string, error) {
+	cc, err := c.cloudConfig()
+	if err != nil {
+		return "", err
+	}
+
+	data := cc
+	if len(c.Snippets) > 0 {
+		if data, err = multipartUserData(append([]string{cc}, c.Snippets...)); err != nil {
+			return "", err
+		}
+	}
+
+	if err := Validate(data); err != nil {
+		return "", fmt.Errorf("generated user-data: %w", err)
+	}
+	return data, nil
+}
+
+type nameservers struct {
+	Addresses []string `yaml:"addresses"`
+}
+
+type route struct {
+	To  string `yaml:"to"`
+	Via string `yaml:"via"`
+}
+
+type ethernet struct {
+	Match struct {
+		MACAddress string `yaml:"macaddress"`
+	} `yaml:"match"`
+	DHCP4       bool         `yaml:"dhcp4,omitempty"`
+	DHCP6       bool         `yaml:"dhcp6,omitempty"`
+	Addresses   []string     `yaml:"addresses,omitempty"`
+	Routes      []route      `yaml:"routes,omitempty"`
+	Nameservers *nameservers `yaml:"nameservers,omitempty"`
+}
+
+// NetworkConfig is the network config version 2 of a VM. NICs IPAM gave
+// nothing fall back to DHCP.
+func NetworkConfig(nics []NIC) (string, error) {
+	ethernets := make(map[string]ethernet, len(nics))
+	for i, nic := range nics {
+		var eth ethernet
+		eth.Match.MACAddress = strings.ToLower(nic.MAC)
+
+		if len(nic.Addresses) == 0 {
+			eth.DHCP4, eth.DHCP6 = true, true
+		}
+		for _, addr := range nic.Addresses {
+			eth.Addresses = append(eth.Addresses, addr.String())
+		}
+		for _, gw := range nic.Gateways {
+			to := "0.0.0.0/0"
+			if gw.Is6() {
+				to = "::/0"
+			}
+			eth.Routes = append(eth.Routes, route{To: to, Via: gw.String()})
+		}
+		if len(nic.DNS) > 0 {
+			eth.Nameservers = &nameservers{}
+			for _, dns := range nic.DNS {
+				eth.Nameservers.Addresses = append(eth.Nameservers.Addresses, dns.String())
+			}
+		}
+
+		ethernets[fmt.Sprintf("eth%d", i)] = eth
+	}
+
+	var nc struct {
+		Network struct {
+			Version   int                 `yaml:"version"`
+			Ethernets map[string]ethernet `yaml:"ethernets"`
+		} `yaml:"network"`
+	}
+	nc.Network.Version = 2
+	nc.Network.Ethernets = ethernets
+
+	out, err := yaml.Marshal(nc)
+	return string(out), err
+}
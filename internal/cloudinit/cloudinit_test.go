@@ -0,0 +1,127 @@
+//go:build !integration
+// +build !integration
+
+package cloudinit
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGtQUPGdqwJWkH4UGB0vsjyqmqyC2ZexDJnmRL3gKXQA user@example.com"
+
+func TestMetaData(t *testing.T) {
+	id := uuid.MustParse("8d0b2a51-4a5f-4a0e-9d86-2d7e1c1f0e0b")
+
+	md, err := MetaData(id, "vm.example.com")
+	assert.NoError(t, err)
+
+	var doc map[string]string
+	assert.NoError(t, yaml.Unmarshal([]byte(md), &doc))
+	assert.Equal(t, id.String(), doc["instance-id"])
+	assert.Equal(t, "vm.example.com", doc["local-hostname"])
+}
+
+func TestUserData(t *testing.T) {
+	ud, err := UserData(Config{
+		User:         "debian",
+		SSHKeys:      []string{key},
+		PasswordHash: "$6$salt$hash",
+		Packages:     []string{"htop"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ud, "#cloud-config\n"))
+
+	var doc cloudConfig
+	assert.NoError(t, yaml.Unmarshal([]byte(ud), &doc))
+	assert.Len(t, doc.Users, 1)
+	assert.Equal(t, "debian", doc.Users[0].Name)
+	assert.Equal(t, "$6$salt$hash", doc.Users[0].Passwd)
+	assert.False(t, doc.Users[0].LockPasswd)
+	assert.Equal(t, []string{key}, doc.Users[0].SSHAuthorizedKeys)
+	assert.True(t, doc.PackageUpdate)
+	assert.Equal(t, []string{"htop"}, doc.Packages)
+}
+
+func TestUserDataNoUser(t *testing.T) {
+	ud, err := UserData(Config{SSHKeys: []string{key}})
+	assert.NoError(t, err)
+
+	var doc cloudConfig
+	assert.NoError(t, yaml.Unmarshal([]byte(ud), &doc))
+	assert.Empty(t, doc.Users)
+	assert.Equal(t, []string{key}, doc.SSHAuthorizedKeys)
+
+	_, err = UserData(Config{PasswordHash: "$6$salt$hash"})
+	assert.True(t, errors.Is(err, ErrPasswordNoUser))
+}
+
+func TestUserDataSnippets(t *testing.T) {
+	script := "#!/bin/sh\necho hello\n"
+	ud, err := UserData(Config{
+		User:     "debian",
+		Snippets: []string{"#cloud-config\nruncmd:\n  - [touch, /done]\n", script},
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ud, "Content-Type: multipart/mixed"))
+	assert.NoError(t, Validate(ud))
+
+	_, err = UserData(Config{Snippets: []string{"#cloud-config\n: [\n"}})
+	assert.Error(t, err, "broken cloud-config snippet")
+
+	_, err = UserData(Config{Snippets: []string{"hello"}})
+	assert.True(t, errors.Is(err, ErrUnknownFormat))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("#cloud-config\npackages: [htop]\n"))
+	assert.NoError(t, Validate("#cloud-config\n"))
+	assert.NoError(t, Validate("#!/bin/bash\ntrue\n"))
+
+	assert.Error(t, Validate("#cloud-config\njust a string\n"))
+	assert.True(t, errors.Is(Validate("packages: [htop]\n"), ErrUnknownFormat))
+	assert.True(t, errors.Is(Validate("#!"+strings.Repeat("x", MaxUserData)), ErrUserDataTooLarge))
+}
+
+func TestNetworkConfig(t *testing.T) {
+	nc, err := NetworkConfig([]NIC{
+		{
+			MAC: "02:E5:E0:00:00:01",
+			Addresses: []netip.Prefix{
+				netip.MustParsePrefix("192.0.2.10/24"),
+				netip.MustParsePrefix("2001:db8::10/64"),
+			},
+			Gateways: []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+			DNS:      []netip.Addr{netip.MustParseAddr("192.0.2.53")},
+		},
+		{MAC: "02:e5:e0:00:00:02"},
+	})
+	assert.NoError(t, err)
+
+	var doc struct {
+		Network struct {
+			Version   int                 `yaml:"version"`
+			Ethernets map[string]ethernet `yaml:"ethernets"`
+		} `yaml:"network"`
+	}
+	assert.NoError(t, yaml.Unmarshal([]byte(nc), &doc))
+	assert.Equal(t, 2, doc.Network.Version)
+
+	eth0 := doc.Network.Ethernets["eth0"]
+	assert.Equal(t, "02:e5:e0:00:00:01", eth0.Match.MACAddress)
+	assert.Equal(t, []string{"192.0.2.10/24", "2001:db8::10/64"}, eth0.Addresses)
+	assert.Equal(t, []route{{To: "0.0.0.0/0", Via: "192.0.2.1"}, {To: "::/0", Via: "2001:db8::1"}}, eth0.Routes)
+	assert.Equal(t, []string{"192.0.2.53"}, eth0.Nameservers.Addresses)
+	assert.False(t, eth0.DHCP4)
+
+	eth1 := doc.Network.Ethernets["eth1"]
+	assert.True(t, eth1.DHCP4)
+	assert.True(t, eth1.DHCP6)
+	assert.Empty(t, eth1.Addresses)
+}
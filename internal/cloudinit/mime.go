@@ -0,0 +1,185 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUserDataTooLarge = fmt.Errorf("user-data is larger than %d bytes", MaxUserData)
+	ErrUnknownFormat    = errors.New("unknown user-data format")
+)
+
+// Content types cloud-init knows user-data by, and the first line it
+// recognises each by outside of a multipart archive
+var contentTypes = []struct {
+	prefix      string
+	contentType string
+}{
+	{"#cloud-config", "text/cloud-config"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#include", "text/x-include-url"},
+	{"## template: jinja", "text/jinja2"},
+	{"#!", "text/x-shellscript"},
+}
+
+// Tells cloud-init to add the lists and keys of a cloud-config part to the
+// ones before instead of replacing them
+const mergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+
+// The content type of a piece of user-data
+func contentType(data string) (string, error) {
+	for _, t := range contentTypes {
+		if strings.HasPrefix(data, t.prefix) {
+			return t.contentType, nil
+		}
+	}
+	return "", ErrUnknownFormat
+}
+
+// ValidateSnippet checks user-data of the user that is merged into ours
+func ValidateSnippet(data string) error {
+	t, err := contentType(data)
+	if err != nil {
+		return err
+	}
+	return validatePart(t, data)
+}
+
+func validatePart(contentType string, data string) error {
+	switch contentType {
+	case "text/cloud-config":
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+			return fmt.Errorf("cloud-config: %w", err)
+		}
+	case "text/cloud-boothook", "text/x-include-url", "text/jinja2", "text/x-shellscript":
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, contentType)
+	}
+	return nil
+}
+
+// multipartUserData puts the parts in a MIME multipart archive, in order
+func multipartUserData(parts []string) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	for i, part := range parts {
+		t, err := contentType(part)
+		if err != nil {
+			return "", fmt.Errorf("part %d: %w", i, err)
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", t+`; charset="utf-8"`)
+		header.Set("Content-Transfer-Encoding", "base64")
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="part-%03d"`, i))
+		if t == "text/cloud-config" {
+			header.Set("Merge-Type", mergeType)
+		}
+
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(pw, wrap(base64.StdEncoding.EncodeToString([]byte(part)))); err != nil {
+			return "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\r\nMIME-Version: 1.0\r\n\r\n%s", w.Boundary(), body.String()), nil
+}
+
+// Base64 in lines of 76 characters, as MIME wants it
+func wrap(s string) string {
+	var b strings.Builder
+	for len(s) > 76 {
+		b.WriteString(s[:76] + "\r\n")
+		s = s[76:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// Validate checks user-data the way cloud-init reads it: a single piece it
+// knows the format of, or a multipart archive of those
+func Validate(data string) error {
+	if len(data) > MaxUserData {
+		return ErrUserDataTooLarge
+	}
+
+	if !strings.HasPrefix(data, "Content-Type: multipart/") {
+		return ValidateSnippet(data)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("multipart user-data: %w", err)
+	}
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("multipart user-data: %w", err)
+	}
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for i := 0; ; i++ {
+		p, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("multipart user-data: %w", err)
+		}
+
+		t, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i, err)
+		}
+
+		var body io.Reader = p
+		if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i, err)
+		}
+
+		if err := validatePart(t, string(content)); err != nil {
+			return fmt.Errorf("part %d: %w", i, err)
+		}
+	}
+}
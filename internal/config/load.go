@@ -21,6 +21,7 @@ package config
 import (
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/file"
 )
 
@@ -41,10 +42,51 @@ var (
 		Database struct {
 			URL string `koanf:"url"`
 		} `koanf:"database"`
+
+		VM struct {
+			UserEditable []string `koanf:"user_editable"`
+			MACPrefix    string   `koanf:"mac_prefix"`
+			MaxSnapshots int      `koanf:"max_snapshots"`
+		} `koanf:"vm"`
+
+		Placement struct {
+			Strategy         string  `koanf:"strategy"`
+			CPUOvercommit    float64 `koanf:"cpu_overcommit"`
+			MemoryOvercommit float64 `koanf:"memory_overcommit"`
+		} `koanf:"placement"`
+
+		Tasks struct {
+			Workers int `koanf:"workers"`
+		} `koanf:"tasks"`
+
+		Metrics struct {
+			Enabled bool   `koanf:"enabled"`
+			Host    string `koanf:"host"`
+			Port    int    `koanf:"port"`
+		} `koanf:"metrics"`
+	}
+
+	// Values used when they are not set in the configuration file
+	defaults = map[string]interface{}{
+		"vm.user_editable":            []string{"hostname", "remarks"},
+		"vm.mac_prefix":               "02:e5:e0",
+		"vm.max_snapshots":            3,
+		"placement.strategy":          "spread",
+		"placement.cpu_overcommit":    4.0,
+		"placement.memory_overcommit": 1.0,
+		"tasks.workers":               4,
+		"metrics.enabled":             false,
+		"metrics.host":                "127.0.0.1",
+		"metrics.port":                9300,
 	}
 )
 
 func Load(configPath string) (err error) {
+	// Load defaults
+	if err := k.Load(confmap.Provider(defaults, "."), nil); err != nil {
+		return err
+	}
+
 	// Load from toml
 	if err := k.Load(file.Provider(configPath), toml.Parser()); err != nil {
 		return err
@@ -19,7 +19,10 @@
 package config
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
@@ -47,5 +50,69 @@ func validate() error {
 		return fmt.Errorf("Configuration(database.url): %w", err)
 	}
 
+	if err := validation.Validate(Config.VM.UserEditable, validation.Each(validation.In("hostname", "flavor", "cpu", "memory", "remarks"))); err != nil {
+		return fmt.Errorf("Configuration(vm.user_editable): %w", err)
+	}
+
+	if err := validateMACPrefix(Config.VM.MACPrefix); err != nil {
+		return fmt.Errorf("Configuration(vm.mac_prefix): %w", err)
+	}
+
+	if err := validation.Validate(Config.VM.MaxSnapshots, validation.Min(0)); err != nil {
+		return fmt.Errorf("Configuration(vm.max_snapshots): %w", err)
+	}
+
+	if err := validation.Validate(Config.Placement.Strategy, validation.Required, validation.In("spread", "pack")); err != nil {
+		return fmt.Errorf("Configuration(placement.strategy): %w", err)
+	}
+
+	if err := validation.Validate(Config.Placement.CPUOvercommit, validation.Required, validation.Min(0.1)); err != nil {
+		return fmt.Errorf("Configuration(placement.cpu_overcommit): %w", err)
+	}
+
+	if err := validation.Validate(Config.Placement.MemoryOvercommit, validation.Required, validation.Min(0.1)); err != nil {
+		return fmt.Errorf("Configuration(placement.memory_overcommit): %w", err)
+	}
+
+	if err := validation.Validate(Config.Tasks.Workers, validation.Required, validation.Min(1)); err != nil {
+		return fmt.Errorf("Configuration(tasks.workers): %w", err)
+	}
+
+	if Config.Metrics.Enabled {
+		if err := validation.Validate(Config.Metrics.Host, validation.Required, is.Host); err != nil {
+			return fmt.Errorf("Configuration(metrics.host): %w", err)
+		}
+
+		if err := validation.Validate(Config.Metrics.Port, validation.Required, validation.Min(1), validation.Max(65535)); err != nil {
+			return fmt.Errorf("Configuration(metrics.port): %w", err)
+		}
+
+		if Config.Metrics.Host == Config.API.Host && Config.Metrics.Port == Config.API.Port {
+			return fmt.Errorf("Configuration(metrics.port): must differ from api.port")
+		}
+	}
+
+	return nil
+}
+
+// The prefix generated MAC addresses start with, it has to be locally
+// administered and unicast so we never clash with real hardware
+func validateMACPrefix(prefix string) error {
+	octets := strings.Split(prefix, ":")
+	if len(octets) < 1 || len(octets) > 5 {
+		return errors.New("must be 1 to 5 octets separated by colons")
+	}
+
+	for _, octet := range octets {
+		if b, err := hex.DecodeString(octet); err != nil || len(b) != 1 {
+			return errors.New("must be hex octets separated by colons")
+		}
+	}
+
+	first, _ := hex.DecodeString(octets[0])
+	if first[0]&0x02 == 0 || first[0]&0x01 != 0 {
+		return errors.New("must be a locally administered unicast prefix")
+	}
+
 	return nil
 }
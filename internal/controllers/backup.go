@@ -0,0 +1,378 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/backup"
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/BasedDevelopment/eve/pkg/status"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrBackupNotFound  = errors.New("backup not found")
+	ErrBackupBusy      = errors.New("backup is not ready")
+	ErrPolicyNotFound  = errors.New("backup policy not found")
+	ErrNoBackupStorage = errors.New("storage pool is not an enabled backup pool of the hypervisor")
+)
+
+// Backup states
+const (
+	BackupCreating  = "creating"
+	BackupReady     = "ready"
+	BackupRestoring = "restoring"
+	BackupDeleting  = "deleting"
+)
+
+type BackupPolicy struct {
+	ID          uuid.UUID  `json:"id"`
+	VM          uuid.UUID  `json:"vm" db:"vm_id"`
+	Storage     uuid.UUID  `json:"storage" db:"storage_id"`
+	Schedule    string     `json:"schedule"`
+	KeepLast    int        `json:"keep_last" db:"keep_last"`
+	KeepDaily   int        `json:"keep_daily" db:"keep_daily"`
+	KeepWeekly  int        `json:"keep_weekly" db:"keep_weekly"`
+	KeepMonthly int        `json:"keep_monthly" db:"keep_monthly"`
+	Enabled     bool       `json:"enabled"`
+	NextRun     *time.Time `json:"next_run" db:"next_run"`
+	LastRun     *time.Time `json:"last_run" db:"last_run"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+}
+
+func (p BackupPolicy) Retention() backup.Retention {
+	return backup.Retention{
+		KeepLast:    p.KeepLast,
+		KeepDaily:   p.KeepDaily,
+		KeepWeekly:  p.KeepWeekly,
+		KeepMonthly: p.KeepMonthly,
+	}
+}
+
+type Backup struct {
+	ID       uuid.UUID `json:"id"`
+	VM       uuid.UUID `json:"vm" db:"vm_id"`
+	Storage  uuid.UUID `json:"storage" db:"storage_id"`
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum"`
+	State    string    `json:"state"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Remarks  string    `json:"remarks"`
+}
+
+func GetBackupPolicy(ctx context.Context, vmid uuid.UUID) (BackupPolicy, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM backup_policy WHERE vm_id = $1", vmid)
+	if err != nil {
+		return BackupPolicy{}, fmt.Errorf("Error reading backup_policy: %w", err)
+	}
+
+	p, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[BackupPolicy])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, ErrPolicyNotFound
+	}
+	return p, err
+}
+
+// Path of a storage pool of the hypervisor. Disabled pools still hold the
+// backups made before, so they are not left out here.
+func (hv *HV) storagePath(id uuid.UUID) (string, error) {
+	s, ok := hv.GetStorage(id)
+	if !ok {
+		return "", ErrStorageNotFound
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	return s.Path, nil
+}
+
+// Path of a backup pool of the hypervisor that takes new backups
+func (hv *HV) backupPath(id uuid.UUID) (string, error) {
+	s, ok := hv.GetStorage(id)
+	if !ok {
+		return "", ErrNoBackupStorage
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if !s.Enabled || !s.Backup {
+		return "", ErrNoBackupStorage
+	}
+
+	return s.Path, nil
+}
+
+// Create or replace the backup policy of a VM
+func (hv *HV) SetBackupPolicy(ctx context.Context, vm *VM, req *util.BackupPolicyRequest) (BackupPolicy, error) {
+	if _, err := hv.backupPath(req.Storage); err != nil {
+		return BackupPolicy{}, err
+	}
+
+	schedule, err := backup.Parse(req.Schedule)
+	if err != nil {
+		return BackupPolicy{}, err
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	var nextRun *time.Time
+	if next := schedule.Next(time.Now().UTC()); enabled && !next.IsZero() {
+		nextRun = &next
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		`INSERT INTO backup_policy (id, vm_id, storage_id, schedule, keep_last, keep_daily, keep_weekly, keep_monthly, enabled, next_run)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (vm_id) DO UPDATE SET storage_id = $3, schedule = $4, keep_last = $5, keep_daily = $6, keep_weekly = $7, keep_monthly = $8, enabled = $9, next_run = $10, updated = now()
+		RETURNING *`,
+		uuid.New(),
+		vm.ID,
+		req.Storage,
+		req.Schedule,
+		req.KeepLast,
+		req.KeepDaily,
+		req.KeepWeekly,
+		req.KeepMonthly,
+		enabled,
+		nextRun,
+	)
+	if err != nil {
+		return BackupPolicy{}, fmt.Errorf("Error upserting backup_policy: %w", err)
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[BackupPolicy])
+}
+
+// Remove the backup policy of a VM, its backups stay
+func DeleteBackupPolicy(ctx context.Context, vmid uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, "DELETE FROM backup_policy WHERE vm_id = $1", vmid)
+	if err != nil {
+		return fmt.Errorf("Error deleting backup_policy: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrPolicyNotFound
+	}
+
+	return nil
+}
+
+// Backups of a VM, newest first
+func ListBackups(ctx context.Context, vmid uuid.UUID) ([]Backup, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM vm_backup WHERE vm_id = $1 ORDER BY created DESC", vmid)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading vm_backup: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Backup])
+}
+
+func GetBackup(ctx context.Context, vmid uuid.UUID, id uuid.UUID) (Backup, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM vm_backup WHERE vm_id = $1 AND id = $2", vmid, id)
+	if err != nil {
+		return Backup{}, fmt.Errorf("Error reading vm_backup: %w", err)
+	}
+
+	b, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Backup])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return b, ErrBackupNotFound
+	}
+	return b, err
+}
+
+// Move a backup from one state to another, failing if something else moved
+// it first
+func setBackupState(ctx context.Context, id uuid.UUID, from string, to string) error {
+	tag, err := db.Pool.Exec(ctx,
+		"UPDATE vm_backup SET state = $1, updated = now() WHERE id = $2 AND state = $3", to, id, from)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrBackupBusy
+	}
+
+	return nil
+}
+
+// Back a VM up to the pool of its policy, then drop the backups the policy
+// no longer keeps
+func (hv *HV) RunBackup(ctx context.Context, vm *VM, policy BackupPolicy) error {
+	path, err := hv.backupPath(policy.Storage)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.New()
+	if _, err := db.Pool.Exec(ctx,
+		"INSERT INTO vm_backup (id, vm_id, storage_id, state) VALUES ($1, $2, $3, $4)",
+		id, vm.ID, policy.Storage, BackupCreating,
+	); err != nil {
+		return fmt.Errorf("Error inserting vm_backup: %w", err)
+	}
+
+	res, err := hv.Auto.CreateBackup(vm.ID.String(), id.String(), path)
+	if err != nil {
+		db.Pool.Exec(ctx, "DELETE FROM vm_backup WHERE id = $1", id)
+		return err
+	}
+
+	if _, err := db.Pool.Exec(ctx,
+		"UPDATE vm_backup SET state = $1, size = $2, checksum = $3, updated = now() WHERE id = $4",
+		BackupReady, res.Size, res.Checksum, id,
+	); err != nil {
+		return fmt.Errorf("Error updating vm_backup: %w", err)
+	}
+
+	return hv.pruneBackups(ctx, vm, policy.Retention())
+}
+
+func (hv *HV) pruneBackups(ctx context.Context, vm *VM, retention backup.Retention) error {
+	backups, err := ListBackups(ctx, vm.ID)
+	if err != nil {
+		return err
+	}
+
+	points := make([]backup.Point, 0, len(backups))
+	for _, b := range backups {
+		if b.State == BackupReady {
+			points = append(points, backup.Point{ID: b.ID, Created: b.Created})
+		}
+	}
+
+	for _, id := range retention.Expired(points, time.UTC) {
+		if err := hv.DeleteBackup(ctx, vm, id); err != nil {
+			log.Warn().
+				Err(err).
+				Str("vm", vm.ID.String()).
+				Str("backup", id.String()).
+				Msg("Failed to prune backup")
+		}
+	}
+
+	return nil
+}
+
+// CheckBackupRestore makes sure the backup is ready and the VM is shut off
+func (hv *HV) CheckBackupRestore(ctx context.Context, vm *VM, id uuid.UUID) (Backup, error) {
+	b, err := GetBackup(ctx, vm.ID, id)
+	if err != nil {
+		return b, err
+	}
+
+	if b.State != BackupReady {
+		return b, ErrBackupBusy
+	}
+
+	state, err := hv.GetVMState(vm)
+	if err != nil {
+		return b, err
+	}
+
+	if state.State != status.StatusShutoff {
+		return b, fmt.Errorf("%w, it is %s", ErrVMNotShutoff, state.State)
+	}
+
+	return b, nil
+}
+
+// Bring the disks of a VM back to a backup
+func (hv *HV) RestoreBackup(ctx context.Context, vm *VM, id uuid.UUID) error {
+	b, err := hv.CheckBackupRestore(ctx, vm, id)
+	if err != nil {
+		return err
+	}
+
+	path, err := hv.storagePath(b.Storage)
+	if err != nil {
+		return err
+	}
+
+	if err := setBackupState(ctx, b.ID, BackupReady, BackupRestoring); err != nil {
+		return err
+	}
+
+	restoreErr := hv.Auto.RestoreBackup(vm.ID.String(), b.ID.String(), path)
+
+	// The backup is still there whether the restore worked or not
+	if err := setBackupState(ctx, b.ID, BackupRestoring, BackupReady); err != nil {
+		return err
+	}
+
+	return restoreErr
+}
+
+// CheckBackupDelete makes sure the backup exists and nothing else is being
+// done with it
+func CheckBackupDelete(ctx context.Context, vm *VM, id uuid.UUID) (Backup, error) {
+	b, err := GetBackup(ctx, vm.ID, id)
+	if err != nil {
+		return b, err
+	}
+
+	if b.State != BackupReady {
+		return b, ErrBackupBusy
+	}
+
+	return b, nil
+}
+
+func (hv *HV) DeleteBackup(ctx context.Context, vm *VM, id uuid.UUID) error {
+	b, err := CheckBackupDelete(ctx, vm, id)
+	if err != nil {
+		return err
+	}
+
+	path, err := hv.storagePath(b.Storage)
+	if err != nil {
+		return err
+	}
+
+	if err := setBackupState(ctx, b.ID, BackupReady, BackupDeleting); err != nil {
+		return err
+	}
+
+	if err := hv.Auto.DeleteBackup(vm.ID.String(), b.ID.String(), path); err != nil {
+		setBackupState(ctx, b.ID, BackupDeleting, BackupReady)
+		return err
+	}
+
+	if _, err := db.Pool.Exec(ctx, "DELETE FROM vm_backup WHERE id = $1", b.ID); err != nil {
+		return fmt.Errorf("Error deleting vm_backup: %w", err)
+	}
+
+	return nil
+}
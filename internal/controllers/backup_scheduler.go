@@ -0,0 +1,122 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/backup"
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Schedules have a resolution of a minute
+const backupTick = time.Minute
+
+// Start the goroutine that runs the backup policies when they are due, until
+// ctx is done
+func StartBackupScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(backupTick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runDueBackups(ctx)
+			}
+		}
+	}()
+}
+
+// Queue a backup task for every enabled policy whose next run has passed
+func runDueBackups(ctx context.Context) {
+	now := time.Now().UTC()
+
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM backup_policy WHERE enabled AND next_run <= $1", now)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read due backup policies")
+		return
+	}
+
+	policies, err := pgx.CollectRows(rows, pgx.RowToStructByName[BackupPolicy])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to collect due backup policies")
+		return
+	}
+
+	for _, p := range policies {
+		logger := log.With().
+			Str("policy", p.ID.String()).
+			Str("vm", p.VM.String()).
+			Logger()
+
+		schedule, err := backup.Parse(p.Schedule)
+		if err != nil {
+			logger.Error().Err(err).Msg("Invalid backup schedule")
+			continue
+		}
+
+		var nextRun *time.Time
+		if next := schedule.Next(now); !next.IsZero() {
+			nextRun = &next
+		}
+
+		// Move the policy on first, so a run is never queued twice
+		tag, err := db.Pool.Exec(ctx,
+			"UPDATE backup_policy SET next_run = $1, last_run = $2 WHERE id = $3 AND next_run = $4",
+			nextRun, now, p.ID, p.NextRun)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to update backup policy")
+			continue
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		_, vm, ok := Cloud.FindVM(p.VM)
+		if !ok {
+			logger.Warn().Msg("VM of backup policy not found")
+			continue
+		}
+
+		vm.Mutex.Lock()
+		owner := vm.UserID
+		vm.Mutex.Unlock()
+
+		// The VM may be elsewhere by the time the task runs
+		policy := p
+		if _, err := tasks.Submit(ctx, owner, "vm.backup", p.VM, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+			hv, vm, err := Cloud.TaskVM(policy.VM)
+			if err != nil {
+				return policy.VM, err
+			}
+			return policy.VM, hv.RunBackup(ctx, vm, policy)
+		}); err != nil {
+			logger.Error().Err(err).Msg("Failed to queue backup")
+		}
+	}
+}
@@ -19,16 +19,246 @@
 package controllers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrBridgeNotFound = errors.New("network not found")
+	ErrBridgeDisabled = errors.New("network is disabled")
+	ErrBridgeInUse    = errors.New("network is still used by virtual machines")
+	ErrBridgePools    = errors.New("network still has ip pools")
+	ErrBridgeExists   = errors.New("a network with this bridge already exists on the hypervisor")
 )
 
 type Bridge struct {
 	Mutex   sync.Mutex `json:"-" db:"-"`
 	ID      uuid.UUID  `json:"id"`
 	HV      uuid.UUID  `json:"hv" db:"hv_id"`
-	Enabled bool       `json:"enabled"`
 	Name    string     `json:"name"`
+	Bridge  string     `json:"bridge"` // interface name on the hypervisor
+	Enabled bool       `json:"enabled"`
+	VLAN    *int       `json:"vlan_id" db:"vlan_id"`
+	MTU     int        `json:"mtu"`
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
 	Remarks string     `json:"remarks"`
 }
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// Load the networks of every hypervisor in the cloud, the caller holds the
+// cloud lock
+func getBridges(cloud *HVList) error {
+	rows, err := db.Pool.Query(context.Background(), "SELECT * FROM hv_network")
+	if err != nil {
+		return fmt.Errorf("Error reading hv_network: %w", err)
+	}
+
+	bridges, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[Bridge])
+	if err != nil {
+		return fmt.Errorf("Error collecting hv_network: %w", err)
+	}
+
+	for _, hv := range cloud.HVs {
+		hv.Bridges = make(map[uuid.UUID]*Bridge)
+	}
+
+	for _, b := range bridges {
+		if hv, ok := cloud.HVs[b.HV]; ok {
+			hv.Bridges[b.ID] = b
+		}
+	}
+
+	return nil
+}
+
+// Networks of the hypervisor, sorted by name
+func (hv *HV) ListBridges() []*Bridge {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	bridges := make([]*Bridge, 0, len(hv.Bridges))
+	for _, b := range hv.Bridges {
+		bridges = append(bridges, b)
+	}
+
+	sort.Slice(bridges, func(i, j int) bool {
+		return bridges[i].Name < bridges[j].Name
+	})
+
+	return bridges
+}
+
+func (hv *HV) GetBridge(id uuid.UUID) (*Bridge, bool) {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	b, ok := hv.Bridges[id]
+	return b, ok
+}
+
+// Find the enabled network of the hypervisor with the given bridge interface
+func (hv *HV) enabledBridge(name string) (*Bridge, error) {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	for _, b := range hv.Bridges {
+		if b.Bridge != name {
+			continue
+		}
+
+		b.Mutex.Lock()
+		enabled := b.Enabled
+		b.Mutex.Unlock()
+
+		if !enabled {
+			return nil, fmt.Errorf("%w: %s", ErrBridgeDisabled, name)
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrBridgeNotFound, name)
+}
+
+func (hv *HV) CreateBridge(ctx context.Context, req *util.NetworkCreateRequest) (*Bridge, error) {
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	mtu := 1500
+	if req.MTU != nil {
+		mtu = *req.MTU
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		"INSERT INTO hv_network (id, hv_id, name, bridge, enabled, vlan_id, mtu, remarks) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *",
+		uuid.New(),
+		hv.ID,
+		req.Name,
+		req.Bridge,
+		enabled,
+		req.VLAN,
+		mtu,
+		req.Remarks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error inserting hv_network: %w", err)
+	}
+
+	b, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Bridge])
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrBridgeExists
+		}
+		return nil, fmt.Errorf("Error collecting hv_network: %w", err)
+	}
+
+	hv.Mutex.Lock()
+	hv.Bridges[b.ID] = b
+	hv.Mutex.Unlock()
+
+	return b, nil
+}
+
+func (hv *HV) UpdateBridge(ctx context.Context, id uuid.UUID, req *util.NetworkUpdateRequest) (*Bridge, error) {
+	b, ok := hv.GetBridge(id)
+	if !ok {
+		return nil, ErrBridgeNotFound
+	}
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	name, enabled, vlan, mtu, remarks := b.Name, b.Enabled, b.VLAN, b.MTU, b.Remarks
+
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	if req.VLAN != nil {
+		// 0 takes the network off its VLAN
+		vlan = req.VLAN
+		if *req.VLAN == 0 {
+			vlan = nil
+		}
+	}
+	if req.MTU != nil {
+		mtu = *req.MTU
+	}
+	if req.Remarks != nil {
+		remarks = *req.Remarks
+	}
+
+	var updated time.Time
+	if err := db.Pool.QueryRow(ctx,
+		"UPDATE hv_network SET name = $1, enabled = $2, vlan_id = $3, mtu = $4, remarks = $5, updated = now() WHERE id = $6 RETURNING updated",
+		name, enabled, vlan, mtu, remarks, id,
+	).Scan(&updated); err != nil {
+		return nil, fmt.Errorf("Error updating hv_network: %w", err)
+	}
+
+	b.Name, b.Enabled, b.VLAN, b.MTU, b.Remarks, b.Updated = name, enabled, vlan, mtu, remarks, updated
+
+	return b, nil
+}
+
+// Delete a network, as long as no NIC is attached to it
+func (hv *HV) DeleteBridge(ctx context.Context, id uuid.UUID) error {
+	b, ok := hv.GetBridge(id)
+	if !ok {
+		return ErrBridgeNotFound
+	}
+
+	b.Mutex.Lock()
+	bridge := b.Bridge
+	b.Mutex.Unlock()
+
+	var nics int
+	if err := db.Pool.QueryRow(ctx,
+		"SELECT count(*) FROM vm_nic n JOIN vm ON vm.id = n.vm_id WHERE n.network_id = $1 OR (vm.hv_id = $2 AND n.bridge = $3)",
+		id, hv.ID, bridge,
+	).Scan(&nics); err != nil {
+		return err
+	}
+
+	if nics != 0 {
+		return ErrBridgeInUse
+	}
+
+	// Its pools would take their addresses with them, they go first
+	var pools int
+	if err := db.Pool.QueryRow(ctx, "SELECT count(*) FROM ip_pools WHERE network_id = $1", id).Scan(&pools); err != nil {
+		return err
+	}
+
+	if pools != 0 {
+		return ErrBridgePools
+	}
+
+	if _, err := db.Pool.Exec(ctx, "DELETE FROM hv_network WHERE id = $1", id); err != nil {
+		return fmt.Errorf("Error deleting hv_network: %w", err)
+	}
+
+	hv.Mutex.Lock()
+	delete(hv.Bridges, id)
+	hv.Mutex.Unlock()
+
+	return nil
+}
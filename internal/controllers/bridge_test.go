@@ -0,0 +1,86 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBridgeHV(bridges ...*Bridge) *HV {
+	hv := &HV{ID: uuid.New(), Bridges: make(map[uuid.UUID]*Bridge)}
+	for _, b := range bridges {
+		b.HV = hv.ID
+		hv.Bridges[b.ID] = b
+	}
+	return hv
+}
+
+func ifaceRequest(t *testing.T, bridges ...string) *util.VMCreateRequest {
+	ifaces := make([]map[string]string, len(bridges))
+	for i, b := range bridges {
+		ifaces[i] = map[string]string{"bridge": b}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"iface": ifaces})
+	assert.NoError(t, err)
+
+	req := new(util.VMCreateRequest)
+	assert.NoError(t, json.Unmarshal(body, req))
+	return req
+}
+
+func TestCheckIfaces(t *testing.T) {
+	public := &Bridge{ID: uuid.New(), Name: "public", Bridge: "br0", Enabled: true}
+	private := &Bridge{ID: uuid.New(), Name: "private", Bridge: "br1", Enabled: true}
+	retired := &Bridge{ID: uuid.New(), Name: "retired", Bridge: "br2"}
+	hv := testBridgeHV(public, private, retired)
+
+	// Networks come back in the order of the interfaces
+	networks, err := hv.CheckIfaces(ifaceRequest(t, "br1", "br0", "br1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{private.ID, public.ID, private.ID}, networks)
+
+	networks, err = hv.CheckIfaces(ifaceRequest(t))
+	assert.NoError(t, err)
+	assert.Empty(t, networks)
+
+	_, err = hv.CheckIfaces(ifaceRequest(t, "br0", "br2"))
+	assert.True(t, errors.Is(err, ErrBridgeDisabled), err)
+
+	_, err = hv.CheckIfaces(ifaceRequest(t, "br9"))
+	assert.True(t, errors.Is(err, ErrBridgeNotFound), err)
+
+	// Bridges of another hypervisor don't count
+	_, err = testBridgeHV().CheckIfaces(ifaceRequest(t, "br0"))
+	assert.True(t, errors.Is(err, ErrBridgeNotFound), err)
+
+	// Enabling the network lets it through
+	retired.Enabled = true
+	networks, err = hv.CheckIfaces(ifaceRequest(t, "br2"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{retired.ID}, networks)
+}
+
+func TestListBridges(t *testing.T) {
+	b := &Bridge{ID: uuid.New(), Name: "b", Bridge: "br1"}
+	c := &Bridge{ID: uuid.New(), Name: "c", Bridge: "br2"}
+	a := &Bridge{ID: uuid.New(), Name: "a", Bridge: "br0"}
+	hv := testBridgeHV(b, c, a)
+
+	assert.Equal(t, []*Bridge{a, b, c}, hv.ListBridges())
+	assert.Empty(t, testBridgeHV().ListBridges())
+
+	got, ok := hv.GetBridge(c.ID)
+	assert.True(t, ok)
+	assert.Same(t, c, got)
+
+	_, ok = hv.GetBridge(uuid.New())
+	assert.False(t, ok)
+}
@@ -19,12 +19,16 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"sync"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
+var ErrVMNotFound = errors.New("virtual machine not found")
+
 type HVList struct {
 	Mutex sync.Mutex
 	HVs   map[uuid.UUID]*HV `json:"hvs"`
@@ -43,5 +47,39 @@ func InitCloud() *HVList {
 		count := len(Cloud.HVs)
 		log.Info().Int("hvs", count).Msg("Found hypervisors")
 	}
+	if err := failInterruptedEvacuations(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Failed to close interrupted evacuations")
+	}
 	return Cloud
 }
+
+// Find a VM and the hypervisor it is on
+func (cloud *HVList) FindVM(id uuid.UUID) (*HV, *VM, bool) {
+	cloud.Mutex.Lock()
+	hvs := make([]*HV, 0, len(cloud.HVs))
+	for _, hv := range cloud.HVs {
+		hvs = append(hvs, hv)
+	}
+	cloud.Mutex.Unlock()
+
+	for _, hv := range hvs {
+		hv.Mutex.Lock()
+		vm, ok := hv.VMs[id]
+		hv.Mutex.Unlock()
+		if ok {
+			return hv, vm, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// Find a VM for a queued task as it runs, it may have moved to another
+// hypervisor or been deleted since the task was queued
+func (cloud *HVList) TaskVM(id uuid.UUID) (*HV, *VM, error) {
+	hv, vm, ok := cloud.FindVM(id)
+	if !ok {
+		return nil, nil, ErrVMNotFound
+	}
+	return hv, vm, nil
+}
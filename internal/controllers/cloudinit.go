@@ -0,0 +1,98 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/BasedDevelopment/eve/internal/cloudinit"
+	"github.com/BasedDevelopment/eve/internal/ipam"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+)
+
+// The NICs of a new VM as cloud-init sees them, with what IPAM gave each
+func cloudInitNICs(ctx context.Context, vm *util.VMCreateRequest, addrs []ipam.Address) ([]cloudinit.NIC, error) {
+	nics := make([]cloudinit.NIC, len(vm.Iface))
+	for i := range vm.Iface {
+		nics[i].MAC = vm.Iface[i].MAC
+	}
+
+	pools := make(map[uuid.UUID]ipam.Pool)
+	for _, addr := range addrs {
+		if addr.NIC == nil || *addr.NIC < 0 || *addr.NIC >= len(nics) {
+			continue
+		}
+
+		pool, ok := pools[addr.PoolID]
+		if !ok {
+			var err error
+			if pool, err = ipam.GetPool(ctx, addr.PoolID); err != nil {
+				return nil, err
+			}
+			pools[addr.PoolID] = pool
+		}
+
+		nic := &nics[*addr.NIC]
+		nic.Addresses = append(nic.Addresses, netip.PrefixFrom(addr.Address, pool.Prefix.Bits()))
+		if pool.Gateway != nil {
+			nic.Gateways = append(nic.Gateways, *pool.Gateway)
+		}
+		nic.DNS = append(nic.DNS, pool.DNS...)
+	}
+
+	return nics, nil
+}
+
+// renderCloudInit generates the user-data, meta-data and network config of a
+// new VM from its cloud-init fields, once its MACs and addresses are known
+func renderCloudInit(ctx context.Context, vmid uuid.UUID, vm *util.VMCreateRequest, addrs []ipam.Address) error {
+	if vm.CloudInit == nil {
+		return nil
+	}
+
+	metaData, err := cloudinit.MetaData(vmid, vm.Hostname)
+	if err != nil {
+		return err
+	}
+
+	userData, err := cloudinit.UserData(cloudinit.Config{
+		User:         vm.CloudInit.User,
+		SSHKeys:      vm.CloudInit.SSHKeys,
+		PasswordHash: vm.CloudInit.PasswordHash,
+		Packages:     vm.CloudInit.Packages,
+		Snippets:     vm.CloudInit.Snippets,
+	})
+	if err != nil {
+		return err
+	}
+
+	nics, err := cloudInitNICs(ctx, vm, addrs)
+	if err != nil {
+		return err
+	}
+	networkConfig, err := cloudinit.NetworkConfig(nics)
+	if err != nil {
+		return err
+	}
+
+	vm.UserData, vm.MetaData, vm.NetworkConfig = userData, metaData, networkConfig
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BasedDevelopment/eve/internal/flavor"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+)
+
+var ErrFlavorDiskShrink = errors.New("root disk is larger than the flavor's, disks can't shrink")
+
+// ApplyFlavor fills the CPU count, memory and root disk size of a create
+// request from its flavor, the root disk is added if the request has none
+func ApplyFlavor(ctx context.Context, vm *util.VMCreateRequest) error {
+	if vm.Flavor == nil {
+		return nil
+	}
+
+	f, err := flavor.Usable(ctx, *vm.Flavor)
+	if err != nil {
+		return err
+	}
+
+	vm.CPU = f.CPU
+	// req is sent as mb
+	vm.Memory = int(f.Memory / 1024 / 1024)
+	if len(vm.Disk) == 0 {
+		vm.Disk = append(vm.Disk, util.VMDisk{})
+	}
+	vm.Disk[0].Size = f.Disk
+
+	return nil
+}
+
+// The root disk of the VM, nil if it has none we know of
+func (vm *VM) rootDisk() *VMStorage {
+	for _, disk := range vm.Storages {
+		if disk.Root {
+			return disk
+		}
+	}
+	return nil
+}
+
+// checkFlavorResize fills the CPU count and memory of an update request from
+// its flavor, and checks the root disk can grow to the flavor's size. It
+// returns the root disk to grow, the nil UUID if it stays as it is.
+func (hv *HV) checkFlavorResize(ctx context.Context, vm *VM, req *util.VMUpdateRequest) (uuid.UUID, int64, error) {
+	f, err := flavor.Usable(ctx, *req.Flavor)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+
+	cpu, memory := f.CPU, int(f.Memory/1024/1024)
+	req.CPU, req.Memory = &cpu, &memory
+
+	vm.Mutex.Lock()
+	var id uuid.UUID
+	var size int64
+	if root := vm.rootDisk(); root != nil {
+		root.Mutex.Lock()
+		id, size = root.ID, root.Size
+		root.Mutex.Unlock()
+	}
+	vm.Mutex.Unlock()
+
+	want := int64(f.Disk) * GiB
+	switch {
+	case id == uuid.Nil || size == want:
+		return uuid.Nil, 0, nil
+	case size > want:
+		return uuid.Nil, 0, ErrFlavorDiskShrink
+	}
+
+	if err := hv.CheckDiskResize(ctx, vm, id, int64(f.Disk)); err != nil {
+		return uuid.Nil, 0, err
+	}
+
+	return id, want, nil
+}
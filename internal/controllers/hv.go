@@ -20,6 +20,7 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -27,26 +28,45 @@ import (
 	"github.com/BasedDevelopment/auto/pkg/models"
 	"github.com/BasedDevelopment/eve/internal/auto"
 	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrHVNotFound   = errors.New("hypervisor not found")
+	ErrHVHasVMs     = errors.New("hypervisor still has virtual machines")
+	ErrHVHasPools   = errors.New("hypervisor networks still have ip pools")
+	ErrHVHasBackups = errors.New("hypervisor storage pools still hold backups or backup policies of virtual machines on other hypervisors")
+	ErrHVHandshake  = errors.New("failed to handshake with auto")
 )
 
 // This is the HV struct that will be stored in the DB.
 type HV struct {
-	ID         uuid.UUID              `json:"id"`
-	Hostname   string                 `json:"hostname"`
-	AutoUrl    string                 `json:"auto_url" db:"auto_url"`
-	AutoSerial string                 `json:"auto_serial" db:"auto_serial"`
-	Site       string                 `json:"site"`
-	Created    time.Time              `json:"created"`
-	Updated    time.Time              `json:"updated"`
-	Remarks    string                 `json:"remarks"`
-	Mutex      sync.Mutex             `json:"-" db:"-"`
-	VMs        map[uuid.UUID]*VM      `json:"-" db:"-"`
-	Storages   map[uuid.UUID]*Storage `json:"-" db:"-"` // storage places for VM disks, isos, backups
-	Bridges    map[uuid.UUID]*Bridge  `json:"-" db:"-"` // bridges for VMs
-	Auto       *auto.Auto             `json:"-" db:"-"` // auto conn details
-	Specs      *models.HV             `json:"-" db:"-"` // specs fetched from auto
+	ID         uuid.UUID `json:"id"`
+	Hostname   string    `json:"hostname"`
+	AutoUrl    string    `json:"auto_url" db:"auto_url"`
+	AutoSerial string    `json:"auto_serial" db:"auto_serial"`
+	Site       string    `json:"site"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+	Remarks    string    `json:"remarks"`
+	Tags       []string  `json:"tags"` // matched against the tags placement asks for
+	// Hypervisors in maintenance take no new VMs
+	Maintenance      bool                   `json:"maintenance"`
+	MaintenanceSince *time.Time             `json:"maintenance_since" db:"maintenance_since"`
+	Mutex            sync.Mutex             `json:"-" db:"-"`
+	VMs              map[uuid.UUID]*VM      `json:"-" db:"-"`
+	Storages         map[uuid.UUID]*Storage `json:"-" db:"-"` // storage places for VM disks, isos, backups
+	Bridges          map[uuid.UUID]*Bridge  `json:"-" db:"-"` // bridges for VMs
+	Auto             *auto.Auto             `json:"-" db:"-"` // auto conn details
+	Specs            *models.HV             `json:"-" db:"-"` // specs fetched from auto
+	Conn             HVConnStatus           `json:"-" db:"-"` // connection state kept by the monitor
+	Orphans          []OrphanDomain         `json:"-" db:"-"` // libvirt domains missing from the database
+
+	stopMonitor context.CancelFunc
+	placement   sync.Mutex // one VM create at a time, so disk placement sees the last one
 }
 
 func getHVs(cloud *HVList) (err error) {
@@ -73,14 +93,253 @@ func getHVs(cloud *HVList) (err error) {
 	cloud.HVs = make(map[uuid.UUID]*HV)
 	for i := range HVs {
 		cloud.HVs[HVs[i].ID] = &HVs[i]
-		HVs[i].Auto = &auto.Auto{
-			Url:    "https://" + HVs[i].AutoUrl,
-			Serial: HVs[i].AutoSerial,
-		}
+		HVs[i].Auto = newAuto(HVs[i].AutoUrl, HVs[i].AutoSerial)
 		HVs[i].VMs = make(map[uuid.UUID]*VM)
 	}
 
-	return
+	if err := getBridges(cloud); err != nil {
+		return err
+	}
+
+	return getStorages(cloud)
+}
+
+func newAuto(autoUrl string, serial string) *auto.Auto {
+	return &auto.Auto{
+		Url:    "https://" + autoUrl,
+		Serial: serial,
+	}
+}
+
+// Get a hypervisor from the cloud by its ID
+func (cloud *HVList) Get(id uuid.UUID) (*HV, bool) {
+	cloud.Mutex.Lock()
+	defer cloud.Mutex.Unlock()
+
+	hv, ok := cloud.HVs[id]
+	return hv, ok
+}
+
+// Add a new hypervisor to the database and the cloud, after making sure that
+// we can actually talk to its auto instance
+func (cloud *HVList) CreateHV(ctx context.Context, req *util.HVCreateRequest) (*HV, error) {
+	a := newAuto(req.AutoUrl, req.AutoSerial)
+	if err := a.Handshake(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHVHandshake, err)
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		"INSERT INTO hv (id, hostname, auto_url, auto_serial, site, remarks, tags) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *",
+		uuid.New(),
+		req.Hostname,
+		req.AutoUrl,
+		req.AutoSerial,
+		req.Site,
+		req.Remarks,
+		tags(req.Tags),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error inserting hv: %w", err)
+	}
+
+	hv, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[HV])
+	if err != nil {
+		return nil, fmt.Errorf("Error collecting hv: %w", err)
+	}
+
+	hv.Auto = a
+	hv.VMs = make(map[uuid.UUID]*VM)
+	hv.Bridges = make(map[uuid.UUID]*Bridge)
+	hv.Storages = make(map[uuid.UUID]*Storage)
+
+	cloud.Mutex.Lock()
+	cloud.HVs[hv.ID] = hv
+	cloud.Mutex.Unlock()
+
+	hv.StartMonitor()
+
+	return hv, nil
+}
+
+// The column is not null, so no tags are stored as an empty array
+func tags(t []string) []string {
+	if t == nil {
+		return []string{}
+	}
+	return t
+}
+
+// Update a hypervisor's details, the auto connection is verified and
+// re-established if the url or serial changes
+func (cloud *HVList) UpdateHV(ctx context.Context, id uuid.UUID, req *util.HVUpdateRequest) (*HV, error) {
+	hv, ok := cloud.Get(id)
+	if !ok {
+		return nil, ErrHVNotFound
+	}
+
+	hv.Mutex.Lock()
+	hostname, autoUrl, autoSerial, site, remarks, hvTags := hv.Hostname, hv.AutoUrl, hv.AutoSerial, hv.Site, hv.Remarks, hv.Tags
+	hv.Mutex.Unlock()
+	origUrl, origSerial := autoUrl, autoSerial
+
+	if req.Hostname != nil {
+		hostname = *req.Hostname
+	}
+	if req.AutoUrl != nil {
+		autoUrl = *req.AutoUrl
+	}
+	if req.AutoSerial != nil {
+		autoSerial = *req.AutoSerial
+	}
+	if req.Site != nil {
+		site = *req.Site
+	}
+	if req.Remarks != nil {
+		remarks = *req.Remarks
+	}
+	if req.Tags != nil {
+		hvTags = tags(*req.Tags)
+	}
+
+	reconnect := autoUrl != origUrl || autoSerial != origSerial
+	a := newAuto(autoUrl, autoSerial)
+	if reconnect {
+		if err := a.Handshake(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHVHandshake, err)
+		}
+	}
+
+	var updated time.Time
+	if err := db.Pool.QueryRow(ctx,
+		"UPDATE hv SET hostname = $2, auto_url = $3, auto_serial = $4, site = $5, remarks = $6, tags = $7, updated = now() WHERE id = $1 RETURNING updated",
+		id,
+		hostname,
+		autoUrl,
+		autoSerial,
+		site,
+		remarks,
+		hvTags,
+	).Scan(&updated); err != nil {
+		return nil, fmt.Errorf("Error updating hv: %w", err)
+	}
+
+	hv.Mutex.Lock()
+	hv.Hostname = hostname
+	hv.AutoUrl = autoUrl
+	hv.AutoSerial = autoSerial
+	hv.Site = site
+	hv.Remarks = remarks
+	hv.Tags = hvTags
+	hv.Updated = updated
+	if reconnect {
+		hv.Auto = a
+	}
+	hv.Mutex.Unlock()
+
+	if reconnect {
+		hv.StartMonitor()
+	}
+
+	return hv, nil
+}
+
+// Remove a hypervisor from the database and the cloud. Hypervisors that still
+// have virtual machines are only removed when force is set, in which case the
+// VM records are dropped from eve and the libvirt domains are left untouched.
+func (cloud *HVList) DeleteHV(ctx context.Context, id uuid.UUID, force bool) error {
+	if _, ok := cloud.Get(id); !ok {
+		return ErrHVNotFound
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var vmCount int
+	if err := tx.QueryRow(ctx, "SELECT count(*) FROM vm WHERE hv_id = $1", id).Scan(&vmCount); err != nil {
+		return err
+	}
+
+	if vmCount > 0 && !force {
+		return ErrHVHasVMs
+	}
+
+	// Pools would take their addresses with them, they have to go first
+	var pools int
+	if err := tx.QueryRow(ctx,
+		"SELECT count(*) FROM ip_pools p JOIN hv_network n ON n.id = p.network_id WHERE n.hv_id = $1",
+		id,
+	).Scan(&pools); err != nil {
+		return err
+	}
+
+	if pools > 0 {
+		return ErrHVHasPools
+	}
+
+	// Those of its own VMs go with them, those of other VMs would be left
+	// pointing at a pool that is gone
+	var backups int
+	if err := tx.QueryRow(ctx,
+		`SELECT (SELECT count(*) FROM backup_policy b JOIN hv_storage s ON s.id = b.storage_id
+				WHERE s.hv_id = $1 AND b.vm_id NOT IN (SELECT id FROM vm WHERE hv_id = $1))
+			+ (SELECT count(*) FROM vm_backup b JOIN hv_storage s ON s.id = b.storage_id
+				WHERE s.hv_id = $1 AND b.vm_id NOT IN (SELECT id FROM vm WHERE hv_id = $1))`,
+		id,
+	).Scan(&backups); err != nil {
+		return err
+	}
+
+	if backups > 0 {
+		return ErrHVHasBackups
+	}
+
+	// Forcing only forgets the VMs, their domains stay on the hypervisor
+	rows, err := tx.Query(ctx, "SELECT id FROM vm WHERE hv_id = $1", id)
+	if err != nil {
+		return err
+	}
+	orphans, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		"DELETE FROM ip_addresses WHERE vm_id IN (SELECT id FROM vm WHERE hv_id = $1) AND state = 'allocated'",
+		"DELETE FROM vm_nic WHERE vm_id IN (SELECT id FROM vm WHERE hv_id = $1)",
+		"DELETE FROM vm_storage WHERE vm_id IN (SELECT id FROM vm WHERE hv_id = $1)",
+		"DELETE FROM vm WHERE hv_id = $1",
+		"DELETE FROM hv_storage WHERE hv_id = $1",
+		"DELETE FROM hv_network WHERE hv_id = $1",
+		"DELETE FROM hv WHERE id = $1",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query, id); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if len(orphans) > 0 {
+		log.Warn().Str("hv", id.String()).Interface("domains", orphans).Msg("Deleted hypervisor still runs the domains of its forgotten VMs")
+	}
+
+	cloud.Mutex.Lock()
+	hv, ok := cloud.HVs[id]
+	delete(cloud.HVs, id)
+	cloud.Mutex.Unlock()
+
+	// Another delete may have got here first
+	if ok {
+		hv.StopMonitor()
+	}
+
+	return nil
 }
 
 // Initialize the HV Auto connection
@@ -0,0 +1,499 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/BasedDevelopment/eve/pkg/status"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrHVMaintenance       = errors.New("hypervisor is in maintenance")
+	ErrHVNotMaintenance    = errors.New("hypervisor must be in maintenance to be evacuated")
+	ErrEvacuationRunning   = errors.New("hypervisor is being evacuated")
+	ErrEvacuationNotFound  = errors.New("evacuation not found")
+	ErrNoEvacuationTarget  = errors.New("no hypervisor can take the virtual machine")
+	ErrEvacuationTargetBad = errors.New("evacuation target must be another hypervisor that is not in maintenance")
+)
+
+// Evacuation modes
+const (
+	EvacuateMigrate  = "migrate"
+	EvacuateShutdown = "shutdown"
+)
+
+// Evacuation and per VM states
+const (
+	EvacuationPending = "pending"
+	EvacuationRunning = "running"
+	EvacuationDone    = "done"
+	EvacuationFailed  = "failed"
+)
+
+// How long a VM gets to shut down by itself before it is stopped
+const evacuationShutdownWait = 3 * time.Minute
+
+type Evacuation struct {
+	ID       uuid.UUID      `json:"id"`
+	HV       uuid.UUID      `json:"hv" db:"hv_id"`
+	Task     *uuid.UUID     `json:"task" db:"task_id"`
+	Mode     string         `json:"mode"`
+	Target   *uuid.UUID     `json:"target" db:"target_hv_id"`
+	State    string         `json:"state"`
+	Created  time.Time      `json:"created"`
+	Finished *time.Time     `json:"finished"`
+	VMs      []EvacuationVM `json:"vms" db:"-"`
+	Summary  map[string]int `json:"summary" db:"-"` // VM count by state
+}
+
+// What happened to one VM of an evacuation
+type EvacuationVM struct {
+	ID             uuid.UUID  `json:"-"`
+	Evacuation     uuid.UUID  `json:"-" db:"evacuation_id"`
+	VM             uuid.UUID  `json:"vm" db:"vm_id"`
+	Hostname       string     `json:"hostname"`
+	Target         *uuid.UUID `json:"target" db:"target_hv_id"`
+	TargetHostname string     `json:"target_hostname" db:"target_hostname"`
+	State          string     `json:"state"`
+	Progress       int        `json:"progress"`
+	Error          string     `json:"error"`
+	Updated        time.Time  `json:"updated"`
+}
+
+func (hv *HV) InMaintenance() bool {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	return hv.Maintenance
+}
+
+// Put a hypervisor in or out of maintenance. It can't come out while it is
+// being evacuated.
+func (hv *HV) SetMaintenance(ctx context.Context, on bool) error {
+	if !on {
+		if _, err := hv.runningEvacuation(ctx); err == nil {
+			return ErrEvacuationRunning
+		} else if !errors.Is(err, ErrEvacuationNotFound) {
+			return err
+		}
+	}
+
+	var (
+		since   *time.Time
+		updated time.Time
+	)
+	if err := db.Pool.QueryRow(ctx,
+		"UPDATE hv SET maintenance = $1, maintenance_since = CASE WHEN $1 THEN coalesce(maintenance_since, now()) END, updated = now() WHERE id = $2 RETURNING maintenance_since, updated",
+		on, hv.ID,
+	).Scan(&since, &updated); err != nil {
+		return fmt.Errorf("Error updating hv: %w", err)
+	}
+
+	hv.Mutex.Lock()
+	hv.Maintenance = on
+	hv.MaintenanceSince = since
+	hv.Updated = updated
+	hv.Mutex.Unlock()
+
+	return nil
+}
+
+// Mark evacuations that were running when eve stopped as failed, their task
+// is gone
+func failInterruptedEvacuations(ctx context.Context) error {
+	queries := []string{
+		"UPDATE hv_evacuation_vm SET state = 'failed', error = 'interrupted', updated = now() WHERE state IN ('pending', 'running') AND evacuation_id IN (SELECT id FROM hv_evacuation WHERE state = 'running')",
+		"UPDATE hv_evacuation SET state = 'failed', finished = now() WHERE state = 'running'",
+	}
+	for _, query := range queries {
+		if _, err := db.Pool.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getEvacuationVMs(ctx context.Context, ev *Evacuation) error {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM hv_evacuation_vm WHERE evacuation_id = $1 ORDER BY hostname", ev.ID)
+	if err != nil {
+		return fmt.Errorf("Error reading hv_evacuation_vm: %w", err)
+	}
+
+	vms, err := pgx.CollectRows(rows, pgx.RowToStructByName[EvacuationVM])
+	if err != nil {
+		return fmt.Errorf("Error collecting hv_evacuation_vm: %w", err)
+	}
+
+	ev.VMs = vms
+	ev.Summary = make(map[string]int)
+	for _, vm := range vms {
+		ev.Summary[vm.State]++
+	}
+	return nil
+}
+
+func (hv *HV) ListEvacuations(ctx context.Context) ([]Evacuation, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM hv_evacuation WHERE hv_id = $1 ORDER BY created DESC", hv.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading hv_evacuation: %w", err)
+	}
+
+	evs, err := pgx.CollectRows(rows, pgx.RowToStructByName[Evacuation])
+	if err != nil {
+		return nil, fmt.Errorf("Error collecting hv_evacuation: %w", err)
+	}
+
+	for i := range evs {
+		if err := getEvacuationVMs(ctx, &evs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return evs, nil
+}
+
+func (hv *HV) GetEvacuation(ctx context.Context, id uuid.UUID) (*Evacuation, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM hv_evacuation WHERE id = $1 AND hv_id = $2", id, hv.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading hv_evacuation: %w", err)
+	}
+
+	ev, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Evacuation])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrEvacuationNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Error collecting hv_evacuation: %w", err)
+	}
+
+	if err := getEvacuationVMs(ctx, ev); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+func (hv *HV) runningEvacuation(ctx context.Context) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := db.Pool.QueryRow(ctx,
+		"SELECT id FROM hv_evacuation WHERE hv_id = $1 AND state = 'running'", hv.ID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return id, ErrEvacuationNotFound
+	}
+	return id, err
+}
+
+// Hypervisors VMs can be evacuated to, the ones with the most free memory
+// first so the VMs are spread out
+func (hv *HV) evacuationTargets(target *uuid.UUID) ([]*HV, error) {
+	if target != nil {
+		t, ok := Cloud.Get(*target)
+		if !ok {
+			return nil, ErrHVNotFound
+		}
+		if t == hv || t.InMaintenance() {
+			return nil, ErrEvacuationTargetBad
+		}
+		return []*HV{t}, nil
+	}
+
+	Cloud.Mutex.Lock()
+	targets := make([]*HV, 0, len(Cloud.HVs))
+	for _, t := range Cloud.HVs {
+		if t != hv {
+			targets = append(targets, t)
+		}
+	}
+	Cloud.Mutex.Unlock()
+
+	free := make(map[*HV]uint64, len(targets))
+	eligible := targets[:0]
+	for _, t := range targets {
+		t.Mutex.Lock()
+		maintenance, specs := t.Maintenance, t.Specs
+		t.Mutex.Unlock()
+
+		if maintenance || t.ConnStatus().State != ConnOnline {
+			continue
+		}
+		if specs != nil {
+			free[t] = specs.RAMFree
+		}
+		eligible = append(eligible, t)
+	}
+
+	sort.SliceStable(eligible, func(i, j int) bool {
+		if free[eligible[i]] != free[eligible[j]] {
+			return free[eligible[i]] > free[eligible[j]]
+		}
+		return eligible[i].Hostname < eligible[j].Hostname
+	})
+	return eligible, nil
+}
+
+// PlanEvacuation records an evacuation of every VM of the hypervisor, to be
+// carried out by Evacuate. The hypervisor has to be in maintenance so no new
+// VMs land on it in the meantime.
+func (hv *HV) PlanEvacuation(ctx context.Context, req *util.EvacuateRequest) (*Evacuation, error) {
+	if !hv.InMaintenance() {
+		return nil, ErrHVNotMaintenance
+	}
+
+	if req.Mode == EvacuateMigrate {
+		if _, err := hv.evacuationTargets(req.Target); err != nil {
+			return nil, err
+		}
+	}
+
+	hv.Mutex.Lock()
+	vms := make([]*VM, 0, len(hv.VMs))
+	for _, vm := range hv.VMs {
+		vms = append(vms, vm)
+	}
+	hv.Mutex.Unlock()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize evacuation plans of the hypervisor
+	if _, err := tx.Exec(ctx, "SELECT id FROM hv WHERE id = $1 FOR UPDATE", hv.ID); err != nil {
+		return nil, err
+	}
+
+	var running int
+	if err := tx.QueryRow(ctx,
+		"SELECT count(*) FROM hv_evacuation WHERE hv_id = $1 AND state = 'running'", hv.ID,
+	).Scan(&running); err != nil {
+		return nil, err
+	}
+	if running > 0 {
+		return nil, ErrEvacuationRunning
+	}
+
+	id := uuid.New()
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO hv_evacuation (id, hv_id, mode, target_hv_id, state) VALUES ($1, $2, $3, $4, 'running')",
+		id, hv.ID, req.Mode, req.Target,
+	); err != nil {
+		return nil, fmt.Errorf("Error inserting hv_evacuation: %w", err)
+	}
+
+	for _, vm := range vms {
+		vm.Mutex.Lock()
+		vmid, hostname := vm.ID, vm.Hostname
+		vm.Mutex.Unlock()
+
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO hv_evacuation_vm (id, evacuation_id, vm_id, hostname, state) VALUES ($1, $2, $3, $4, 'pending')",
+			uuid.New(), id, vmid, hostname,
+		); err != nil {
+			return nil, fmt.Errorf("Error inserting hv_evacuation_vm: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return hv.GetEvacuation(ctx, id)
+}
+
+// Record the task carrying out an evacuation
+func (ev *Evacuation) SetTask(ctx context.Context, task uuid.UUID) error {
+	if _, err := db.Pool.Exec(ctx,
+		"UPDATE hv_evacuation SET task_id = $1 WHERE id = $2", task, ev.ID,
+	); err != nil {
+		return err
+	}
+	ev.Task = &task
+	return nil
+}
+
+// Give up on an evacuation that could not be started
+func (ev *Evacuation) Abort(ctx context.Context, reason error) error {
+	return ev.finish(ctx, reason.Error())
+}
+
+// Close the evacuation, whatever VM hasn't been handled yet fails with reason
+func (ev *Evacuation) finish(ctx context.Context, reason string) error {
+	if _, err := db.Pool.Exec(ctx,
+		"UPDATE hv_evacuation_vm SET state = 'failed', error = $1, updated = now() WHERE evacuation_id = $2 AND state IN ('pending', 'running')",
+		reason, ev.ID,
+	); err != nil {
+		return err
+	}
+
+	var failed int
+	if err := db.Pool.QueryRow(ctx,
+		"SELECT count(*) FROM hv_evacuation_vm WHERE evacuation_id = $1 AND state = 'failed'", ev.ID,
+	).Scan(&failed); err != nil {
+		return err
+	}
+
+	state := EvacuationDone
+	if failed > 0 {
+		state = EvacuationFailed
+	}
+	_, err := db.Pool.Exec(ctx,
+		"UPDATE hv_evacuation SET state = $1, finished = now() WHERE id = $2", state, ev.ID)
+	return err
+}
+
+func (e *EvacuationVM) update(ctx context.Context) {
+	if _, err := db.Pool.Exec(ctx,
+		"UPDATE hv_evacuation_vm SET target_hv_id = $1, target_hostname = $2, state = $3, progress = $4, error = $5, updated = now() WHERE id = $6",
+		e.Target, e.TargetHostname, e.State, e.Progress, e.Error, e.ID,
+	); err != nil {
+		log.Warn().Err(err).Str("vm", e.VM.String()).Msg("Failed to update evacuation")
+	}
+}
+
+// Evacuate carries out an evacuation plan, one VM after another. A VM that
+// fails is recorded and the next one is tried. progress is called with how
+// far along the whole evacuation is, in percent.
+func (hv *HV) Evacuate(ctx context.Context, ev *Evacuation, progress func(int)) error {
+	failed := 0
+	for i := range ev.VMs {
+		e := &ev.VMs[i]
+		report := func(percent int) {
+			progress((i*100 + percent) / len(ev.VMs))
+		}
+
+		e.State = EvacuationRunning
+		e.update(ctx)
+
+		var err error
+		hv.Mutex.Lock()
+		vm, ok := hv.VMs[e.VM]
+		hv.Mutex.Unlock()
+		if !ok {
+			// Deleted or moved away since the plan was made
+			e.Progress = 100
+			e.State = EvacuationDone
+			e.update(ctx)
+			report(100)
+			continue
+		}
+
+		switch ev.Mode {
+		case EvacuateMigrate:
+			err = hv.evacuateMigrate(ctx, ev, e, vm, report)
+		case EvacuateShutdown:
+			err = hv.evacuateShutdown(ctx, vm)
+		default:
+			err = fmt.Errorf("unknown evacuation mode %q", ev.Mode)
+		}
+
+		if err != nil {
+			failed++
+			e.State = EvacuationFailed
+			e.Error = err.Error()
+		} else {
+			e.State = EvacuationDone
+			e.Progress = 100
+		}
+		e.update(ctx)
+		report(100)
+	}
+
+	if err := ev.finish(ctx, "not evacuated"); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d virtual machines could not be evacuated", failed, len(ev.VMs))
+	}
+	return nil
+}
+
+// Migrate a VM to the first hypervisor that can take it
+func (hv *HV) evacuateMigrate(ctx context.Context, ev *Evacuation, e *EvacuationVM, vm *VM, progress func(int)) error {
+	targets, err := hv.evacuationTargets(ev.Target)
+	if err != nil {
+		return err
+	}
+
+	var target *HV
+	for _, t := range targets {
+		if _, err = hv.PlanMigration(ctx, vm, t, nil); err == nil {
+			target = t
+			break
+		}
+	}
+	if target == nil {
+		if len(targets) == 1 {
+			return err
+		}
+		return ErrNoEvacuationTarget
+	}
+
+	e.Target = &target.ID
+	e.TargetHostname = target.Hostname
+	e.update(ctx)
+
+	return hv.MigrateVM(ctx, vm, target, nil, func(percent int) {
+		e.Progress = percent
+		e.update(ctx)
+		progress(percent)
+	})
+}
+
+// Shut a VM down, stopping it if it doesn't do so in time
+func (hv *HV) evacuateShutdown(ctx context.Context, vm *VM) error {
+	state, err := hv.GetVMState(vm)
+	if err != nil {
+		return err
+	}
+	if state.State == status.StatusShutoff {
+		return nil
+	}
+
+	if _, err := hv.SetVMState(vm, "poweroff"); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(evacuationShutdownWait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationPoll):
+		}
+
+		if state, err := hv.GetVMState(vm); err == nil && state.State == status.StatusShutoff {
+			return nil
+		}
+	}
+
+	log.Warn().Str("vm", vm.ID.String()).Msg("VM did not shut down in time, stopping it")
+	_, err = hv.SetVMState(vm, "stop")
+	return err
+}
@@ -0,0 +1,89 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func useCloud(t *testing.T, hvs ...*HV) {
+	cloud := &HVList{HVs: make(map[uuid.UUID]*HV)}
+	for _, hv := range hvs {
+		cloud.HVs[hv.ID] = hv
+	}
+
+	old := Cloud
+	Cloud = cloud
+	t.Cleanup(func() { Cloud = old })
+}
+
+func TestEvacuationTargets(t *testing.T) {
+	source := testTargetHV("source", 8, 64)
+	source.Maintenance = true
+	small := testTargetHV("small", 8, 16)
+	big := testTargetHV("big", 8, 64)
+	tieA := testTargetHV("tie-a", 8, 32)
+	tieB := testTargetHV("tie-b", 8, 32)
+	busy := testTargetHV("busy", 8, 128)
+	busy.Maintenance = true
+	down := testTargetHV("down", 8, 128)
+	down.Conn.State = ConnOffline
+	unknown := testTargetHV("unknown", 8, 0)
+	unknown.Specs = nil
+	useCloud(t, source, small, tieB, big, busy, down, tieA, unknown)
+
+	// Most free memory first, then by hostname, without the source and
+	// hypervisors that can't take VMs
+	targets, err := source.evacuationTargets(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*HV{big, tieA, tieB, small, unknown}, targets)
+
+	// A given target is used alone
+	targets, err = source.evacuationTargets(&small.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []*HV{small}, targets)
+
+	_, err = source.evacuationTargets(&source.ID)
+	assert.True(t, errors.Is(err, ErrEvacuationTargetBad), err)
+
+	_, err = source.evacuationTargets(&busy.ID)
+	assert.True(t, errors.Is(err, ErrEvacuationTargetBad), err)
+
+	missing := uuid.New()
+	_, err = source.evacuationTargets(&missing)
+	assert.True(t, errors.Is(err, ErrHVNotFound), err)
+
+	// Nothing to go to is not an error until a VM needs a target
+	useCloud(t, source)
+	targets, err = source.evacuationTargets(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, targets)
+}
+
+func TestPlanEvacuationTarget(t *testing.T) {
+	source := testTargetHV("source", 8, 64)
+	other := testTargetHV("other", 8, 64)
+	other.Maintenance = true
+	useCloud(t, source, other)
+
+	ctx := context.Background()
+
+	_, err := source.PlanEvacuation(ctx, &util.EvacuateRequest{Mode: EvacuateMigrate})
+	assert.True(t, errors.Is(err, ErrHVNotMaintenance), err)
+
+	// The target is checked before anything is recorded
+	source.Maintenance = true
+	_, err = source.PlanEvacuation(ctx, &util.EvacuateRequest{Mode: EvacuateMigrate, Target: &other.ID})
+	assert.True(t, errors.Is(err, ErrEvacuationTargetBad), err)
+
+	missing := uuid.New()
+	_, err = source.PlanEvacuation(ctx, &util.EvacuateRequest{Mode: EvacuateMigrate, Target: &missing})
+	assert.True(t, errors.Is(err, ErrHVNotFound), err)
+}
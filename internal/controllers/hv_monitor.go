@@ -0,0 +1,185 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Connection state of a hypervisor as seen by its monitor
+type ConnState uint8
+
+const (
+	ConnConnecting ConnState = iota
+	ConnOnline
+	ConnDegraded
+	ConnOffline
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnConnecting:
+		return "connecting"
+	case ConnOnline:
+		return "online"
+	case ConnDegraded:
+		return "degraded"
+	case ConnOffline:
+		return "offline"
+	default:
+		return "unknown"
+	}
+}
+
+func (s ConnState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+const (
+	monitorInterval    = 30 * time.Second
+	monitorBaseBackoff = 5 * time.Second
+	monitorMaxBackoff  = 5 * time.Minute
+)
+
+// The connection details of a hypervisor, returned by the state endpoint
+type HVConnStatus struct {
+	State     ConnState `json:"state"`
+	LastSeen  time.Time `json:"last_seen"`
+	LastError string    `json:"last_error"`
+	Failures  int       `json:"failures"`
+}
+
+// Exponential backoff for the given amount of consecutive failures
+func backoff(failures int) time.Duration {
+	if failures < 1 {
+		return monitorBaseBackoff
+	}
+
+	wait := monitorBaseBackoff
+	for i := 1; i < failures; i++ {
+		wait *= 2
+		if wait >= monitorMaxBackoff {
+			return monitorMaxBackoff
+		}
+	}
+	return wait
+}
+
+// Start the supervisor goroutine of the hypervisor, replacing any that is
+// already running
+func (hv *HV) StartMonitor() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	hv.Mutex.Lock()
+	if hv.stopMonitor != nil {
+		hv.stopMonitor()
+	}
+	hv.stopMonitor = cancel
+	hv.Conn = HVConnStatus{State: ConnConnecting}
+	hv.Mutex.Unlock()
+
+	go hv.monitor(ctx)
+}
+
+// Stop the supervisor goroutine of the hypervisor
+func (hv *HV) StopMonitor() {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	if hv.stopMonitor != nil {
+		hv.stopMonitor()
+		hv.stopMonitor = nil
+	}
+}
+
+// Get a copy of the connection status of the hypervisor
+func (hv *HV) ConnStatus() HVConnStatus {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	return hv.Conn
+}
+
+func (hv *HV) monitor(ctx context.Context) {
+	for {
+		wait := hv.check()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Refresh the hypervisor and its VMs once, update the connection state and
+// return how long to wait until the next check
+func (hv *HV) check() time.Duration {
+	state := ConnOnline
+
+	err := hv.Refresh()
+	if err != nil {
+		state = ConnOffline
+	} else if err = hv.InitVMs(); err != nil {
+		state = ConnDegraded
+	}
+
+	hv.Mutex.Lock()
+	prev := hv.Conn.State
+	hv.Conn.State = state
+	if state != ConnOffline {
+		hv.Conn.LastSeen = time.Now()
+	}
+	if err != nil {
+		hv.Conn.LastError = err.Error()
+		hv.Conn.Failures++
+	} else {
+		hv.Conn.LastError = ""
+		hv.Conn.Failures = 0
+	}
+	failures := hv.Conn.Failures
+	vms := len(hv.VMs)
+	hostname := hv.Hostname
+	hv.Mutex.Unlock()
+
+	if state != prev {
+		logger := log.With().
+			Str("hostname", hostname).
+			Str("from", prev.String()).
+			Str("to", state.String()).
+			Logger()
+
+		switch state {
+		case ConnOnline:
+			logger.Info().Int("vms", vms).Msg("Connected to hypervisor and fetched virtual machines")
+		case ConnDegraded:
+			logger.Warn().Err(err).Msg("Connected to hypervisor but failed to fetch virtual machines")
+		case ConnOffline:
+			logger.Warn().Err(err).Msg("Failed to connect to hypervisor")
+		}
+	}
+
+	if err != nil {
+		return backoff(failures)
+	}
+	return monitorInterval
+}
@@ -0,0 +1,26 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, monitorBaseBackoff, backoff(0))
+	assert.Equal(t, monitorBaseBackoff, backoff(1))
+	assert.Equal(t, 2*monitorBaseBackoff, backoff(2))
+	assert.Equal(t, 4*monitorBaseBackoff, backoff(3))
+	assert.Equal(t, monitorMaxBackoff, backoff(100))
+}
+
+func TestConnStateString(t *testing.T) {
+	assert.Equal(t, "connecting", ConnConnecting.String())
+	assert.Equal(t, "online", ConnOnline.String())
+	assert.Equal(t, "degraded", ConnDegraded.String())
+	assert.Equal(t, "offline", ConnOffline.String())
+	assert.Equal(t, "unknown", ConnState(42).String())
+}
@@ -0,0 +1,215 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/BasedDevelopment/eve/internal/auto"
+	"github.com/BasedDevelopment/eve/internal/image"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrImageUnavailable = errors.New("image is not synced to an enabled pool of the hypervisor")
+	ErrCloudInitISO     = errors.New("cloud_init only applies to cloud images")
+)
+
+// Whether a pool takes images of a kind
+func (s *Storage) holds(kind string) bool {
+	switch kind {
+	case image.KindCloud:
+		return s.CloudImage
+	case image.KindISO:
+		return s.Iso
+	}
+	return false
+}
+
+// Enabled pools of the hypervisor that take images of a kind
+func (hv *HV) imagePools(kind string) []poolSpace {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	var pools []poolSpace
+	for _, s := range hv.Storages {
+		s.Mutex.Lock()
+		if s.Enabled && s.holds(kind) {
+			pools = append(pools, poolSpace{ID: s.ID, Path: s.Path})
+		}
+		s.Mutex.Unlock()
+	}
+
+	return pools
+}
+
+// Path of the image on the hypervisor, in the first of its pools it was
+// synced to
+func (hv *HV) imagePath(ctx context.Context, img image.Image) (string, error) {
+	locations, err := image.Locations(ctx, img.ID)
+	if err != nil {
+		return "", err
+	}
+
+	synced := make(map[uuid.UUID]bool, len(locations))
+	for _, l := range locations {
+		synced[l.Storage] = l.State == image.LocationSynced
+	}
+
+	for _, pool := range hv.imagePools(img.Kind) {
+		if synced[pool.ID] {
+			return img.Path(pool.Path), nil
+		}
+	}
+
+	return "", ErrImageUnavailable
+}
+
+// UsableImage gets the image of a create request, if new VMs can be made
+// from it and the request meets its minimum
+func UsableImage(ctx context.Context, vm *util.VMCreateRequest) (image.Image, error) {
+	img, err := image.Usable(ctx, *vm.ImageID)
+	if err != nil {
+		return img, err
+	}
+
+	if vm.CloudInit != nil && img.Kind != image.KindCloud {
+		return img, ErrCloudInitISO
+	}
+
+	root := 0
+	if len(vm.Disk) > 0 {
+		root = vm.Disk[0].Size
+	}
+	return img, img.Fits(vm.Memory, root)
+}
+
+// The image of a create request and its path on the hypervisor
+func (hv *HV) resolveImage(ctx context.Context, vm *util.VMCreateRequest) (image.Image, string, error) {
+	img, err := UsableImage(ctx, vm)
+	if err != nil {
+		return img, "", err
+	}
+
+	path, err := hv.imagePath(ctx, img)
+	return img, path, err
+}
+
+// CheckImage makes sure the image of a create request can be used on the
+// hypervisor
+func (hv *HV) CheckImage(ctx context.Context, vm *util.VMCreateRequest) error {
+	if vm.ImageID == nil {
+		return nil
+	}
+
+	_, _, err := hv.resolveImage(ctx, vm)
+	return err
+}
+
+// ApplyImage fills the image paths and OS variant of a create request from
+// its image, as found on the hypervisor
+func (hv *HV) ApplyImage(ctx context.Context, vm *util.VMCreateRequest) error {
+	if vm.ImageID == nil {
+		return nil
+	}
+
+	img, path, err := hv.resolveImage(ctx, vm)
+	if err != nil {
+		return err
+	}
+
+	vm.Image, vm.CloudImage, vm.Cloud = "", "", false
+	if img.Kind == image.KindCloud {
+		vm.Cloud, vm.CloudImage = true, path
+	} else {
+		vm.Image = path
+	}
+	vm.OSVariant = img.OSVariant
+	if vm.CloudInit != nil && vm.CloudInit.User == "" {
+		vm.CloudInit.User = img.DefaultUser
+	}
+
+	return nil
+}
+
+// Have auto put the image in every pool of the hypervisor that takes it, and
+// record how that went. Returns the number of pools that failed.
+func (hv *HV) syncImage(ctx context.Context, img image.Image, pools []poolSpace, done func()) (failed int) {
+	for _, pool := range pools {
+		err := hv.Auto.SyncImage(auto.Image{
+			Path:     img.Path(pool.Path),
+			Source:   img.Source,
+			Checksum: img.Checksum,
+		})
+		if err != nil {
+			failed++
+			log.Warn().Err(err).Str("image", img.Name).Str("hv", hv.Hostname).Str("path", pool.Path).Msg("Failed to sync image")
+		}
+
+		if recErr := image.SetLocation(ctx, img.ID, pool.ID, err); recErr != nil {
+			log.Error().Err(recErr).Str("image", img.Name).Str("storage", pool.ID.String()).Msg("Failed to record image location")
+		}
+		done()
+	}
+
+	return failed
+}
+
+// SyncImage puts an image in the pools that take it on every online
+// hypervisor, progress is given the percentage of pools done
+func (cloud *HVList) SyncImage(ctx context.Context, img image.Image, progress func(int)) error {
+	cloud.Mutex.Lock()
+	all := make([]*HV, 0, len(cloud.HVs))
+	for _, hv := range cloud.HVs {
+		all = append(all, hv)
+	}
+	cloud.Mutex.Unlock()
+
+	var hvs []*HV
+	var pools [][]poolSpace
+	total := 0
+	for _, hv := range all {
+		if hv.ConnStatus().State != ConnOnline {
+			continue
+		}
+		p := hv.imagePools(img.Kind)
+		hvs, pools = append(hvs, hv), append(pools, p)
+		total += len(p)
+	}
+	if total == 0 {
+		return fmt.Errorf("no online hypervisor has an enabled pool for %s images", img.Kind)
+	}
+
+	done, failed := 0, 0
+	for i, hv := range hvs {
+		failed += hv.syncImage(ctx, img, pools[i], func() {
+			done++
+			progress(done * 100 / total)
+		})
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("image failed to sync to %d of %d pools", failed, total)
+	}
+	return nil
+}
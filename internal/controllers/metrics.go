@@ -0,0 +1,119 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hvUpDesc = prometheus.NewDesc(
+		"eve_hypervisor_up",
+		"Whether the hypervisor is online.",
+		[]string{"id", "hostname"}, nil,
+	)
+	hvStateDesc = prometheus.NewDesc(
+		"eve_hypervisor_state",
+		"Connection state of the hypervisor, 1 for the current state.",
+		[]string{"id", "hostname", "state"}, nil,
+	)
+	hvFailuresDesc = prometheus.NewDesc(
+		"eve_hypervisor_connection_failures",
+		"Consecutive failed checks of the hypervisor.",
+		[]string{"id", "hostname"}, nil,
+	)
+	vmCountDesc = prometheus.NewDesc(
+		"eve_virtual_machines",
+		"Virtual machines, by hypervisor and the last power state eve saw.",
+		[]string{"hypervisor", "state"}, nil,
+	)
+)
+
+var connStates = []ConnState{ConnConnecting, ConnOnline, ConnDegraded, ConnOffline}
+
+// Collector reports the state of the cloud when scraped
+type Collector struct {
+	cloud *HVList
+}
+
+func NewCollector(cloud *HVList) *Collector {
+	return &Collector{cloud: cloud}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hvUpDesc
+	ch <- hvStateDesc
+	ch <- hvFailuresDesc
+	ch <- vmCountDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.cloud.Mutex.Lock()
+	hvs := make([]*HV, 0, len(c.cloud.HVs))
+	for _, hv := range c.cloud.HVs {
+		hvs = append(hvs, hv)
+	}
+	c.cloud.Mutex.Unlock()
+
+	for _, hv := range hvs {
+		hv.Mutex.Lock()
+		id := hv.ID.String()
+		hostname := hv.Hostname
+		conn := hv.Conn
+		vms := make([]*VM, 0, len(hv.VMs))
+		for _, vm := range hv.VMs {
+			vms = append(vms, vm)
+		}
+		hv.Mutex.Unlock()
+
+		up := 0.0
+		if conn.State == ConnOnline {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(hvUpDesc, prometheus.GaugeValue, up, id, hostname)
+
+		for _, state := range connStates {
+			v := 0.0
+			if conn.State == state {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(hvStateDesc, prometheus.GaugeValue, v, id, hostname, state.String())
+		}
+
+		ch <- prometheus.MustNewConstMetric(hvFailuresDesc, prometheus.GaugeValue, float64(conn.Failures), id, hostname)
+
+		counts := make(map[string]int)
+		for _, vm := range vms {
+			vm.Mutex.Lock()
+			state := vm.State
+			if vm.Lost {
+				state = "lost"
+			} else if state == "" {
+				state = "unknown"
+			}
+			vm.Mutex.Unlock()
+
+			counts[state]++
+		}
+
+		for state, n := range counts {
+			ch <- prometheus.MustNewConstMetric(vmCountDesc, prometheus.GaugeValue, float64(n), hostname, state)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/BasedDevelopment/eve/internal/placement"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+)
+
+// One automatic placement at a time, so two creates don't both count on the
+// same room
+var placing sync.Mutex
+
+// Describe a hypervisor to the placement strategy. Hypervisors that can't
+// take the VM whatever their resources are rejected with the reason.
+func (hv *HV) candidate(ctx context.Context, vm *util.VMCreateRequest) placement.Candidate {
+	c, maintenance, specs := hv.resources()
+
+	switch {
+	case maintenance:
+		c.Reject = "in maintenance"
+		return c
+	case hv.ConnStatus().State != ConnOnline:
+		c.Reject = "not online"
+		return c
+	case specs == nil:
+		c.Reject = "specs unknown"
+		return c
+	}
+	if _, err := hv.CheckIfaces(vm); err != nil {
+		c.Reject = err.Error()
+		return c
+	}
+
+	if err := hv.CheckImage(ctx, vm); err != nil {
+		c.Reject = err.Error()
+		return c
+	}
+
+	// Pinned disk paths and pool sizes are checked the way CreateVM places
+	// them, the total free space is only used to rank
+	if _, err := hv.PlaceDisks(ctx, vm); err != nil {
+		c.Reject = err.Error()
+		return c
+	}
+	for _, pool := range hv.diskPools() {
+		c.DiskFree += pool.Free
+	}
+
+	return c
+}
+
+// What the hypervisor has and what its VMs use, along with whether it is in
+// maintenance and its specs, the host figures are left out without specs
+func (hv *HV) resources() (c placement.Candidate, maintenance bool, specs *models.HV) {
+	hv.Mutex.Lock()
+	c = placement.Candidate{
+		ID:       hv.ID,
+		Hostname: hv.Hostname,
+		Site:     hv.Site,
+		Tags:     hv.Tags,
+	}
+	maintenance, specs = hv.Maintenance, hv.Specs
+	for _, v := range hv.VMs {
+		v.Mutex.Lock()
+		c.UsedCPUs += v.CPU
+		c.UsedMemory += uint64(v.Memory)
+		v.Mutex.Unlock()
+	}
+	hv.Mutex.Unlock()
+
+	if specs != nil {
+		c.CPUs = int(specs.CPUCount)
+		c.Memory = specs.RAMTotal
+	}
+
+	return c, maintenance, specs
+}
+
+// How far placement may overcommit hypervisors, as configured
+func overcommit() placement.Overcommit {
+	return placement.Overcommit{
+		CPU:    config.Config.Placement.CPUOvercommit,
+		Memory: config.Config.Placement.MemoryOvercommit,
+	}
+}
+
+// PlaceVM picks the hypervisor a VM goes to with the configured strategy
+func (cloud *HVList) PlaceVM(ctx context.Context, req *util.VMPlaceRequest) (*HV, error) {
+	strategy, err := placement.StrategyByName(config.Config.Placement.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	cloud.Mutex.Lock()
+	hvs := make([]*HV, 0, len(cloud.HVs))
+	for _, hv := range cloud.HVs {
+		hvs = append(hvs, hv)
+	}
+	cloud.Mutex.Unlock()
+
+	candidates := make([]placement.Candidate, len(hvs))
+	for i, hv := range hvs {
+		candidates[i] = hv.candidate(ctx, &req.VMCreateRequest)
+	}
+
+	want := placement.Request{
+		CPUs: req.CPU,
+		// req is sent as mb
+		Memory: uint64(req.Memory) * 1024 * 1024,
+		Site:   req.Site,
+		Tags:   req.Tags,
+	}
+	for _, disk := range req.Disk {
+		want.Disk += int64(disk.Size) * GiB
+	}
+
+	picked, err := placement.Pick(candidates, want, overcommit(), strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	hv, ok := cloud.Get(picked.ID)
+	if !ok {
+		return nil, ErrHVNotFound
+	}
+	return hv, nil
+}
+
+// CreatePlacedVM creates a VM on the hypervisor PlaceVM picks for it
+func (cloud *HVList) CreatePlacedVM(ctx context.Context, req *util.VMPlaceRequest) (uuid.UUID, error) {
+	placing.Lock()
+	defer placing.Unlock()
+
+	hv, err := cloud.PlaceVM(ctx, req)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return hv.CreateVM(ctx, &req.VMCreateRequest, hv.ID)
+}
@@ -0,0 +1,239 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Limits of a profile, nil is no limit. Memory and disk are in bytes.
+type Quota struct {
+	Profile   uuid.UUID  `json:"-" db:"profile_id"`
+	CPU       *int       `json:"cpu"`
+	Memory    *int64     `json:"memory"`
+	Disk      *int64     `json:"disk"`
+	VMs       *int       `json:"vms"`
+	IPs       *int       `json:"ips"`
+	Snapshots *int       `json:"snapshots"`
+	Created   *time.Time `json:"created"`
+	Updated   *time.Time `json:"updated"`
+}
+
+// What a profile holds, or asks for on top. Memory and disk are in bytes.
+type Usage struct {
+	CPU       int   `json:"cpu"`
+	Memory    int64 `json:"memory"`
+	Disk      int64 `json:"disk"`
+	VMs       int   `json:"vms"`
+	IPs       int   `json:"ips"`
+	Snapshots int   `json:"snapshots"`
+}
+
+type QuotaReport struct {
+	Limits Quota `json:"limits"`
+	Usage  Usage `json:"usage"`
+}
+
+// Both pgx.Tx and the pool, so quotas can be read in or out of a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func getQuota(ctx context.Context, q querier, profile uuid.UUID) (Quota, error) {
+	rows, err := q.Query(ctx, "SELECT * FROM quota WHERE profile_id = $1", profile)
+	if err != nil {
+		return Quota{}, fmt.Errorf("Error reading quota: %w", err)
+	}
+
+	quota, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Quota])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Quota{Profile: profile}, nil
+	}
+	return quota, err
+}
+
+func getUsage(ctx context.Context, q querier, profile uuid.UUID) (Usage, error) {
+	var u Usage
+	err := q.QueryRow(ctx, `SELECT
+		(SELECT coalesce(sum(cpu), 0)::integer FROM vm WHERE profile_id = $1),
+		(SELECT coalesce(sum(memory), 0)::bigint FROM vm WHERE profile_id = $1),
+		(SELECT coalesce(sum(s.size), 0)::bigint FROM vm_storage s JOIN vm ON vm.id = s.vm_id WHERE vm.profile_id = $1),
+		(SELECT count(*) FROM vm WHERE profile_id = $1),
+		(SELECT count(*) FROM ip_addresses a JOIN vm ON vm.id = a.vm_id WHERE vm.profile_id = $1),
+		(SELECT count(*) FROM vm_snapshot s JOIN vm ON vm.id = s.vm_id WHERE vm.profile_id = $1)`,
+		profile,
+	).Scan(&u.CPU, &u.Memory, &u.Disk, &u.VMs, &u.IPs, &u.Snapshots)
+	if err != nil {
+		return u, fmt.Errorf("Error reading usage: %w", err)
+	}
+	return u, nil
+}
+
+func GetQuota(ctx context.Context, profile uuid.UUID) (Quota, error) {
+	return getQuota(ctx, db.Pool, profile)
+}
+
+// The limits of a profile against what it holds
+func GetQuotaReport(ctx context.Context, profile uuid.UUID) (QuotaReport, error) {
+	quota, err := GetQuota(ctx, profile)
+	if err != nil {
+		return QuotaReport{}, err
+	}
+
+	usage, err := getUsage(ctx, db.Pool, profile)
+	if err != nil {
+		return QuotaReport{}, err
+	}
+
+	return QuotaReport{Limits: quota, Usage: usage}, nil
+}
+
+// Replace the limits of a profile
+func SetQuota(ctx context.Context, profile uuid.UUID, req *util.QuotaRequest) (Quota, error) {
+	var memory, disk *int64
+	if req.Memory != nil {
+		// req is sent as mb, but we want to store it as bytes
+		m := int64(*req.Memory) * 1024 * 1024
+		memory = &m
+	}
+	if req.Disk != nil {
+		d := *req.Disk * GiB
+		disk = &d
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		`INSERT INTO quota (profile_id, cpu, memory, disk, vms, ips, snapshots) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (profile_id) DO UPDATE SET cpu = $2, memory = $3, disk = $4, vms = $5, ips = $6, snapshots = $7, updated = now()
+		RETURNING *`,
+		profile, req.CPU, memory, disk, req.VMs, req.IPs, req.Snapshots,
+	)
+	if err != nil {
+		return Quota{}, fmt.Errorf("Error updating quota: %w", err)
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[Quota])
+}
+
+// Lift all limits of a profile
+func DeleteQuota(ctx context.Context, profile uuid.UUID) error {
+	_, err := db.Pool.Exec(ctx, "DELETE FROM quota WHERE profile_id = $1", profile)
+	return err
+}
+
+// check reports every limit that used plus add goes over. Only what is being
+// added is checked, so a profile over a lowered limit can still shrink.
+func (q Quota) check(used Usage, add Usage) error {
+	var over []string
+	limit := func(name string, max *int64, used int64, add int64) {
+		if max != nil && add > 0 && used+add > *max {
+			over = append(over, fmt.Sprintf("%s: %d used, %d more asked, limit %d", name, used, add, *max))
+		}
+	}
+	count := func(name string, max *int, used int, add int) {
+		if max != nil && add > 0 && used+add > *max {
+			over = append(over, fmt.Sprintf("%s: %d used, %d more asked, limit %d", name, used, add, *max))
+		}
+	}
+
+	count("cpu", q.CPU, used.CPU, add.CPU)
+	limit("memory", q.Memory, used.Memory, add.Memory)
+	limit("disk", q.Disk, used.Disk, add.Disk)
+	count("vms", q.VMs, used.VMs, add.VMs)
+	count("ips", q.IPs, used.IPs, add.IPs)
+	count("snapshots", q.Snapshots, used.Snapshots, add.Snapshots)
+
+	if len(over) > 0 {
+		return fmt.Errorf("%w (%s)", ErrQuotaExceeded, strings.Join(over, "; "))
+	}
+	return nil
+}
+
+// checkQuota makes sure a profile may add to what it holds. The profile row
+// stays locked until tx ends, so concurrent requests of the profile count
+// each other.
+func checkQuota(ctx context.Context, tx pgx.Tx, profile uuid.UUID, add Usage) error {
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM profile WHERE id = $1 FOR UPDATE", profile); err != nil {
+		return err
+	}
+
+	quota, err := getQuota(ctx, tx, profile)
+	if err != nil {
+		return err
+	}
+
+	used, err := getUsage(ctx, tx, profile)
+	if err != nil {
+		return err
+	}
+
+	return quota.check(used, add)
+}
+
+// CheckQuota is checkQuota without holding anything, to fail requests early
+func CheckQuota(ctx context.Context, profile uuid.UUID, add Usage) error {
+	quota, err := GetQuota(ctx, profile)
+	if err != nil {
+		return err
+	}
+
+	used, err := getUsage(ctx, db.Pool, profile)
+	if err != nil {
+		return err
+	}
+
+	return quota.check(used, add)
+}
+
+// What a create request adds, IPs are counted once they are allocated
+func createUsage(vm *util.VMCreateRequest) Usage {
+	u := Usage{
+		CPU: vm.CPU,
+		// req is sent as mb
+		Memory: int64(vm.Memory) * 1024 * 1024,
+		VMs:    1,
+	}
+	for _, disk := range vm.Disk {
+		u.Disk += int64(disk.Size) * GiB
+	}
+	return u
+}
+
+func CheckCreateQuota(ctx context.Context, vm *util.VMCreateRequest) error {
+	return CheckQuota(ctx, vm.User, createUsage(vm))
+}
+
+// What growing a VM from one size to another adds, shrinking adds nothing
+func growth(from int64, to int64) int64 {
+	if to > from {
+		return to - from
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	cpu, vms := 8, 2
+	memory := int64(16 * GiB)
+	q := Quota{CPU: &cpu, Memory: &memory, VMs: &vms}
+	used := Usage{CPU: 6, Memory: 8 * GiB, VMs: 1, Disk: 500 * GiB}
+
+	// Up to the limit is fine, limits that are not set are not checked
+	assert.NoError(t, q.check(used, Usage{CPU: 2, Memory: 8 * GiB, VMs: 1, Disk: 1000 * GiB}))
+
+	err := q.check(used, Usage{CPU: 4, VMs: 2})
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	assert.Contains(t, err.Error(), "cpu: 6 used, 4 more asked, limit 8")
+	assert.Contains(t, err.Error(), "vms: 1 used, 2 more asked, limit 2")
+	assert.NotContains(t, err.Error(), "memory")
+
+	// Over a lowered limit, but asking for nothing more
+	cpu = 4
+	assert.NoError(t, q.check(used, Usage{Memory: GiB}))
+	assert.Error(t, q.check(used, Usage{CPU: 1}))
+
+	assert.NoError(t, Quota{}.check(used, Usage{CPU: 1000, Snapshots: 1000}))
+}
+
+func TestGrowth(t *testing.T) {
+	assert.Equal(t, int64(2), growth(4, 6))
+	assert.Equal(t, int64(0), growth(6, 4))
+	assert.Equal(t, int64(0), growth(4, 4))
+}
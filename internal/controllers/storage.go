@@ -19,20 +19,363 @@
 package controllers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
+var (
+	ErrStorageNotFound = errors.New("storage pool not found")
+	ErrStorageInUse    = errors.New("storage pool still holds virtual machine disks or backups")
+	ErrStorageExists   = errors.New("a storage pool with this path already exists on the hypervisor")
+	ErrStorageCapacity = errors.New("capacity is below the space allocated to disks")
+	ErrStorageFull     = errors.New("no enabled disk storage pool with enough free space")
+)
+
+// Disk and pool sizes are requested in GiB and stored in bytes
+const GiB = 1024 * 1024 * 1024
+
 type Storage struct {
 	Mutex      sync.Mutex `json:"-" db:"-"`
 	ID         uuid.UUID  `json:"id"`
 	HV         uuid.UUID  `json:"hv" db:"hv_id"`
-	Enabeld    bool       `json:"enabled" db:"enabled"`
+	Name       string     `json:"name" db:"name"`
+	Enabled    bool       `json:"enabled" db:"enabled"`
 	Type       string     `json:"type" db:"type"`
 	Path       string     `json:"path" db:"path"`
 	Iso        bool       `json:"iso" db:"iso"`
 	Disk       bool       `json:"disk" db:"disk"`
 	CloudImage bool       `json:"cloud_image" db:"cloud_image"`
+	Backup     bool       `json:"backup" db:"backup"`
+	Capacity   int64      `json:"capacity" db:"capacity"`
+	Allocated  int64      `json:"allocated" db:"-"` // sum of the vm_storage sizes on the pool
+	Created    time.Time  `json:"created" db:"created"`
+	Updated    time.Time  `json:"updated" db:"updated"`
 	Remarks    string     `json:"remarks" db:"remarks"`
 }
+
+// Where a disk of a new VM goes
+type DiskPlacement struct {
+	Storage uuid.UUID
+	Path    string
+	Size    int64
+}
+
+// Free space of a disk pool, as seen by placement
+type poolSpace struct {
+	ID   uuid.UUID
+	Path string
+	Free int64
+}
+
+// Load the storage pools of every hypervisor in the cloud, the caller holds
+// the cloud lock
+func getStorages(cloud *HVList) error {
+	rows, err := db.Pool.Query(context.Background(), "SELECT * FROM hv_storage")
+	if err != nil {
+		return fmt.Errorf("Error reading hv_storage: %w", err)
+	}
+
+	storages, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[Storage])
+	if err != nil {
+		return fmt.Errorf("Error collecting hv_storage: %w", err)
+	}
+
+	for _, hv := range cloud.HVs {
+		hv.Storages = make(map[uuid.UUID]*Storage)
+	}
+
+	for _, s := range storages {
+		if hv, ok := cloud.HVs[s.HV]; ok {
+			hv.Storages[s.ID] = s
+		}
+	}
+
+	return nil
+}
+
+// Recount the space allocated to VM disks on each storage pool of the
+// hypervisor
+func (hv *HV) RefreshStorageUsage(ctx context.Context) error {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT s.id, COALESCE(sum(v.size), 0)::bigint FROM hv_storage s LEFT JOIN vm_storage v ON v.storage_id = s.id WHERE s.hv_id = $1 GROUP BY s.id",
+		hv.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("Error reading vm_storage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[uuid.UUID]int64)
+	for rows.Next() {
+		var id uuid.UUID
+		var allocated int64
+		if err := rows.Scan(&id, &allocated); err != nil {
+			return err
+		}
+		usage[id] = allocated
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	for id, s := range hv.Storages {
+		s.Mutex.Lock()
+		s.Allocated = usage[id]
+		s.Mutex.Unlock()
+	}
+
+	return nil
+}
+
+// Storage pools of the hypervisor, sorted by name
+func (hv *HV) ListStorages() []*Storage {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	storages := make([]*Storage, 0, len(hv.Storages))
+	for _, s := range hv.Storages {
+		storages = append(storages, s)
+	}
+
+	sort.Slice(storages, func(i, j int) bool {
+		return storages[i].Name < storages[j].Name
+	})
+
+	return storages
+}
+
+func (hv *HV) GetStorage(id uuid.UUID) (*Storage, bool) {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	s, ok := hv.Storages[id]
+	return s, ok
+}
+
+func (hv *HV) CreateStorage(ctx context.Context, req *util.StorageCreateRequest) (*Storage, error) {
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	disk := true
+	if req.Disk != nil {
+		disk = *req.Disk
+	}
+
+	storageType := "dir"
+	if req.Type != "" {
+		storageType = req.Type
+	}
+
+	rows, err := db.Pool.Query(ctx,
+		"INSERT INTO hv_storage (id, hv_id, name, enabled, type, path, iso, disk, cloud_image, backup, capacity, remarks) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING *",
+		uuid.New(),
+		hv.ID,
+		req.Name,
+		enabled,
+		storageType,
+		req.Path,
+		req.Iso,
+		disk,
+		req.CloudImage,
+		req.Backup,
+		req.Capacity*GiB,
+		req.Remarks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error inserting hv_storage: %w", err)
+	}
+
+	s, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Storage])
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrStorageExists
+		}
+		return nil, fmt.Errorf("Error collecting hv_storage: %w", err)
+	}
+
+	hv.Mutex.Lock()
+	hv.Storages[s.ID] = s
+	hv.Mutex.Unlock()
+
+	return s, nil
+}
+
+// Update a storage pool, the path and type stay as they are since disks
+// already live there
+func (hv *HV) UpdateStorage(ctx context.Context, id uuid.UUID, req *util.StorageUpdateRequest) (*Storage, error) {
+	if err := hv.RefreshStorageUsage(ctx); err != nil {
+		return nil, err
+	}
+
+	s, ok := hv.GetStorage(id)
+	if !ok {
+		return nil, ErrStorageNotFound
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	name, enabled, iso, disk, cloudImage, backup, capacity, remarks := s.Name, s.Enabled, s.Iso, s.Disk, s.CloudImage, s.Backup, s.Capacity, s.Remarks
+
+	if req.Name != nil {
+		name = *req.Name
+	}
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	if req.Iso != nil {
+		iso = *req.Iso
+	}
+	if req.Disk != nil {
+		disk = *req.Disk
+	}
+	if req.CloudImage != nil {
+		cloudImage = *req.CloudImage
+	}
+	if req.Backup != nil {
+		backup = *req.Backup
+	}
+	if req.Capacity != nil {
+		capacity = *req.Capacity * GiB
+		if capacity < s.Allocated {
+			return nil, ErrStorageCapacity
+		}
+	}
+	if req.Remarks != nil {
+		remarks = *req.Remarks
+	}
+
+	var updated time.Time
+	if err := db.Pool.QueryRow(ctx,
+		"UPDATE hv_storage SET name = $1, enabled = $2, iso = $3, disk = $4, cloud_image = $5, backup = $6, capacity = $7, remarks = $8, updated = now() WHERE id = $9 RETURNING updated",
+		name, enabled, iso, disk, cloudImage, backup, capacity, remarks, id,
+	).Scan(&updated); err != nil {
+		return nil, fmt.Errorf("Error updating hv_storage: %w", err)
+	}
+
+	s.Name, s.Enabled, s.Iso, s.Disk, s.CloudImage, s.Backup, s.Capacity, s.Remarks, s.Updated = name, enabled, iso, disk, cloudImage, backup, capacity, remarks, updated
+
+	return s, nil
+}
+
+// Delete a storage pool, as long as no disk, backup or backup policy uses it
+func (hv *HV) DeleteStorage(ctx context.Context, id uuid.UUID) error {
+	if _, ok := hv.GetStorage(id); !ok {
+		return ErrStorageNotFound
+	}
+
+	var users int
+	if err := db.Pool.QueryRow(ctx,
+		"SELECT (SELECT count(*) FROM vm_storage WHERE storage_id = $1) + (SELECT count(*) FROM vm_backup WHERE storage_id = $1) + (SELECT count(*) FROM backup_policy WHERE storage_id = $1)", id,
+	).Scan(&users); err != nil {
+		return err
+	}
+
+	if users != 0 {
+		return ErrStorageInUse
+	}
+
+	if _, err := db.Pool.Exec(ctx, "DELETE FROM hv_storage WHERE id = $1", id); err != nil {
+		return fmt.Errorf("Error deleting hv_storage: %w", err)
+	}
+
+	hv.Mutex.Lock()
+	delete(hv.Storages, id)
+	hv.Mutex.Unlock()
+
+	return nil
+}
+
+// Free space of the enabled, disk capable pools of the hypervisor
+func (hv *HV) diskPools() []poolSpace {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	pools := make([]poolSpace, 0, len(hv.Storages))
+	for _, s := range hv.Storages {
+		s.Mutex.Lock()
+		if s.Enabled && s.Disk {
+			pools = append(pools, poolSpace{
+				ID:   s.ID,
+				Path: s.Path,
+				Free: s.Capacity - s.Allocated,
+			})
+		}
+		s.Mutex.Unlock()
+	}
+
+	return pools
+}
+
+// PlaceDisks picks a storage pool for every disk of a create request, from
+// the enabled disk pools of the hypervisor that have room for it
+func (hv *HV) PlaceDisks(ctx context.Context, vm *util.VMCreateRequest) ([]DiskPlacement, error) {
+	if err := hv.RefreshStorageUsage(ctx); err != nil {
+		return nil, err
+	}
+
+	disks := make([]DiskPlacement, len(vm.Disk))
+	for i := range vm.Disk {
+		disks[i] = DiskPlacement{
+			Path: vm.Disk[i].Path,
+			Size: int64(vm.Disk[i].Size) * GiB,
+		}
+	}
+
+	return placeDisks(hv.diskPools(), disks)
+}
+
+// placeDisks assigns each disk to a pool. Disks that ask for a path go to the
+// pool at that path, the others go to the pool with the most free space left.
+func placeDisks(pools []poolSpace, disks []DiskPlacement) ([]DiskPlacement, error) {
+	free := make([]int64, len(pools))
+	for i := range pools {
+		free[i] = pools[i].Free
+	}
+
+	placed := make([]DiskPlacement, len(disks))
+	for i, disk := range disks {
+		best := -1
+		for j := range pools {
+			if disk.Path != "" && pools[j].Path != disk.Path {
+				continue
+			}
+			if free[j] < disk.Size {
+				continue
+			}
+			if best == -1 || free[j] > free[best] {
+				best = j
+			}
+		}
+
+		if best == -1 {
+			if disk.Path != "" {
+				return nil, fmt.Errorf("%w at %s for disk %d", ErrStorageFull, disk.Path, i)
+			}
+			return nil, fmt.Errorf("%w for disk %d", ErrStorageFull, i)
+		}
+
+		free[best] -= disk.Size
+		placed[i] = DiskPlacement{
+			Storage: pools[best].ID,
+			Path:    pools[best].Path,
+			Size:    disk.Size,
+		}
+	}
+
+	return placed, nil
+}
@@ -0,0 +1,54 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceDisks(t *testing.T) {
+	fast := poolSpace{ID: uuid.New(), Path: "/var/lib/eve/fast", Free: 20 * GiB}
+	bulk := poolSpace{ID: uuid.New(), Path: "/var/lib/eve/bulk", Free: 100 * GiB}
+	pools := []poolSpace{fast, bulk}
+
+	// Most free space wins, and placed disks count against the pool
+	placed, err := placeDisks(pools, []DiskPlacement{
+		{Size: 60 * GiB},
+		{Size: 30 * GiB},
+		{Size: 15 * GiB},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, bulk.ID, placed[0].Storage)
+	assert.Equal(t, bulk.ID, placed[1].Storage)
+	assert.Equal(t, fast.ID, placed[2].Storage)
+	assert.Equal(t, fast.Path, placed[2].Path)
+	assert.Equal(t, int64(15*GiB), placed[2].Size)
+
+	// A path pins the disk to that pool
+	placed, err = placeDisks(pools, []DiskPlacement{{Size: 10 * GiB, Path: fast.Path}})
+	assert.NoError(t, err)
+	assert.Equal(t, fast.ID, placed[0].Storage)
+
+	_, err = placeDisks(pools, []DiskPlacement{{Size: 30 * GiB, Path: fast.Path}})
+	assert.True(t, errors.Is(err, ErrStorageFull))
+
+	_, err = placeDisks(pools, []DiskPlacement{{Size: 10 * GiB, Path: "/elsewhere"}})
+	assert.True(t, errors.Is(err, ErrStorageFull))
+
+	// Nothing fits
+	_, err = placeDisks(pools, []DiskPlacement{{Size: 101 * GiB}})
+	assert.True(t, errors.Is(err, ErrStorageFull))
+
+	_, err = placeDisks(nil, []DiskPlacement{{Size: GiB}})
+	assert.True(t, errors.Is(err, ErrStorageFull))
+
+	// No disks, no pools needed
+	placed, err = placeDisks(nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, placed)
+}
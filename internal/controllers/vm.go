@@ -21,7 +21,7 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"net"
+	"net/netip"
 	"sync"
 	"time"
 
@@ -33,42 +33,60 @@ import (
 )
 
 type VM struct {
-	Mutex    sync.Mutex           `json:"-" db:"-"`
-	ID       uuid.UUID            `json:"id"`
-	HV       uuid.UUID            `json:"hv" db:"hv_id"`
-	Hostname string               `json:"hostname"`
-	UserID   uuid.UUID            `json:"user" db:"profile_id"`
-	CPU      int                  `json:"cpu"`
-	Memory   int64                `json:"memory"`
-	Nics     map[string]VMNic     `json:"nics" db:"-"`
-	Storages map[string]VMStorage `json:"storages" db:"-"`
-	Created  time.Time            `json:"created"`
-	Updated  time.Time            `json:"updated"`
-	Remarks  string               `json:"remarks"`
-	Domain   models.VM            `json:"-" db:"-"` // data from libvirt
+	Mutex    sync.Mutex            `json:"-" db:"-"`
+	ID       uuid.UUID             `json:"id"`
+	HV       uuid.UUID             `json:"hv" db:"hv_id"`
+	Hostname string                `json:"hostname"`
+	UserID   uuid.UUID             `json:"user" db:"profile_id"`    // the VM is accounted to
+	Project  uuid.UUID             `json:"project" db:"project_id"` // members get access
+	Flavor   *uuid.UUID            `json:"flavor" db:"flavor_id"`   // none once changed by hand
+	Image    *uuid.UUID            `json:"image" db:"image_id"`     // from the catalog it was made from
+	CPU      int                   `json:"cpu"`
+	Memory   int64                 `json:"memory"`
+	Nics     map[string]*VMNic     `json:"nics" db:"-"`
+	Storages map[string]*VMStorage `json:"storages" db:"-"`
+	Created  time.Time             `json:"created"`
+	Updated  time.Time             `json:"updated"`
+	Remarks  string                `json:"remarks"`
+	Lost     bool                  `json:"lost"`
+	Domain   models.VM             `json:"-" db:"-"` // data from libvirt
+	State    string                `json:"-" db:"-"` // last power state seen, for metrics
+
+	edits   uint64    // bumped on every change, a reload read before one is stale
+	drifted []VMDrift // drift last warned about
+}
+
+// Note a change of the VM, called with its mutex held
+func (vm *VM) touch() {
+	vm.edits++
 }
 
 type VMNic struct {
-	Mutex   sync.Mutex `db:"-" json:"-"`
-	ID      uuid.UUID
-	Bridge  uuid.UUID
-	name    string
-	MAC     string
-	IP      []net.IP `db:"-"`
-	Created time.Time
-	Updated time.Time
-	Remarks string
-	State   string `db:"-"`
+	Mutex   sync.Mutex   `db:"-" json:"-"`
+	ID      uuid.UUID    `json:"id"`
+	VM      uuid.UUID    `json:"-" db:"vm_id"`
+	Name    string       `json:"name"`
+	Bridge  string       `json:"bridge"`
+	Network *uuid.UUID   `json:"network" db:"network_id"`
+	MAC     string       `json:"mac"`
+	IPs     []netip.Addr `json:"ips" db:"ips"`
+	Created time.Time    `json:"created"`
+	Updated time.Time    `json:"updated"`
+	Remarks string       `json:"remarks"`
+	State   string       `json:"-" db:"-"`
 }
 
 type VMStorage struct {
 	Mutex   sync.Mutex `db:"-" json:"-"`
-	ID      uuid.UUID
-	Storage uuid.UUID
-	Size    int
-	Created time.Time
-	Updated time.Time
-	Remarks string
+	ID      uuid.UUID  `json:"id"`
+	VM      uuid.UUID  `json:"-" db:"vm_id"`
+	Name    string     `json:"name"` // target device in the domain, vda, vdb...
+	Root    bool       `json:"root"`
+	Storage *uuid.UUID `json:"storage" db:"storage_id"`
+	Size    int64      `json:"size"`
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+	Remarks string     `json:"remarks"`
 }
 
 func (hv *HV) getVMsFromDB() (vms []VM, err error) {
@@ -90,12 +108,72 @@ func (hv *HV) getVMsFromDB() (vms []VM, err error) {
 		return nil, err
 	}
 
+	// Fetch the NICs of those VMs
+	nicRows, queryErr := db.Pool.Query(context.Background(),
+		"SELECT n.* FROM vm_nic n JOIN vm ON vm.id = n.vm_id WHERE vm.hv_id = $1", hv.ID)
+
+	if queryErr != nil {
+		return nil, fmt.Errorf("failed to query VM NICs: %w", queryErr)
+	}
+
+	nics, collectErr := pgx.CollectRows(nicRows, pgx.RowToAddrOfStructByName[VMNic])
+
+	if collectErr != nil {
+		return nil, fmt.Errorf("error collecting VM NICs: %w", collectErr)
+	}
+
+	byVM := make(map[uuid.UUID]map[string]*VMNic)
+	for _, nic := range nics {
+		if byVM[nic.VM] == nil {
+			byVM[nic.VM] = make(map[string]*VMNic)
+		}
+		byVM[nic.VM][nic.Name] = nic
+	}
+
+	// And their disks
+	diskRows, queryErr := db.Pool.Query(context.Background(),
+		"SELECT s.* FROM vm_storage s JOIN vm ON vm.id = s.vm_id WHERE vm.hv_id = $1", hv.ID)
+
+	if queryErr != nil {
+		return nil, fmt.Errorf("failed to query VM storage: %w", queryErr)
+	}
+
+	disks, collectErr := pgx.CollectRows(diskRows, pgx.RowToAddrOfStructByName[VMStorage])
+
+	if collectErr != nil {
+		return nil, fmt.Errorf("error collecting VM storage: %w", collectErr)
+	}
+
+	disksByVM := make(map[uuid.UUID]map[string]*VMStorage)
+	for _, disk := range disks {
+		if disksByVM[disk.VM] == nil {
+			disksByVM[disk.VM] = make(map[string]*VMStorage)
+		}
+		disksByVM[disk.VM][disk.ID.String()] = disk
+	}
+
+	for i := range vms {
+		vms[i].Nics = byVM[vms[i].ID]
+		vms[i].Storages = disksByVM[vms[i].ID]
+	}
+
 	return
 }
 
-// Fetch VMs from the DB and Libvirt, marshall them into the HV struct, and
+// Fetch VMs from the DB and Libvirt, merge them into the HV struct, and
 // check for inconsistencies
 func (hv *HV) InitVMs() error {
+	// What we hold before reading, a VM changed or moved since then is newer
+	// than what we read
+	hv.Mutex.Lock()
+	before := make(map[uuid.UUID]uint64, len(hv.VMs))
+	for id, vm := range hv.VMs {
+		vm.Mutex.Lock()
+		before[id] = vm.edits
+		vm.Mutex.Unlock()
+	}
+	hv.Mutex.Unlock()
+
 	// Use Auto to get the VMs from Libvirt
 	vms, err := hv.Auto.GetLibvirtVMs()
 	if err != nil {
@@ -117,57 +195,251 @@ func (hv *HV) InitVMs() error {
 			Msg("VM count mismatch")
 	}
 
+	domains := make(map[uuid.UUID]int, len(vms))
+	for i := range vms {
+		domains[vms[i].ID] = i
+	}
+
 	hv.Mutex.Lock()
 	defer hv.Mutex.Unlock()
-	// Clear VMs
-	hv.VMs = make(map[uuid.UUID]*VM)
-	// Load the VMs of a hypervisor into memory
+	if hv.VMs == nil {
+		hv.VMs = make(map[uuid.UUID]*VM)
+	}
+
+	read := make(map[uuid.UUID]bool, len(dbVMs))
 	for i := range dbVMs {
-		id := dbVMs[i].ID
+		fresh := &dbVMs[i]
+		read[fresh.ID] = true
+
+		edits, held := before[fresh.ID]
+		vm, ok := hv.VMs[fresh.ID]
+		switch {
+		case !ok && held:
+			// Moved away or deleted while we were reading
+			continue
+		case !ok:
+			hv.VMs[fresh.ID] = fresh
+			vm = fresh
+			vm.Mutex.Lock()
+		default:
+			vm.Mutex.Lock()
+			if !held || vm.edits != edits {
+				vm.Mutex.Unlock()
+				continue
+			}
+			vm.merge(fresh)
+		}
 
-		// Load from database
-		hv.VMs[id] = &dbVMs[i]
+		// Domain is the VM object, direct from libvirt
+		if j, ok := domains[vm.ID]; ok {
+			vm.Domain = vms[j]
 
-		// Consistency check w/ libvirt
-		for j := range vms {
-			// Check if libvirt VM id == database ID
-			if vms[j].ID == id {
-				// Domain is the VM object, direct from libvirt
-				hv.VMs[id].Domain = vms[j]
+			// Check consistency between our database & libvirt/auto.
+			hv.checkVMConsistency(vm)
+		} else {
+			vm.Domain = models.VM{}
+		}
+		vm.Mutex.Unlock()
+	}
 
-				// Check consistency between our database & libvirt/auto.
-				go hv.checkVMConsistency(hv.VMs[id])
-			}
+	// Drop the VMs that are gone, unless they came in while we were reading
+	for id, vm := range hv.VMs {
+		if read[id] {
+			continue
+		}
+		vm.Mutex.Lock()
+		edits, held := before[id]
+		stale := held && vm.edits == edits
+		vm.Mutex.Unlock()
+		if stale {
+			delete(hv.VMs, id)
+		}
+	}
+
+	// Keep track of domains that are not in our database
+	orphans := make([]OrphanDomain, 0, len(hv.Orphans))
+	for i := range vms {
+		if _, ok := hv.VMs[vms[i].ID]; !ok {
+			orphans = append(orphans, OrphanDomain{
+				ID:     vms[i].ID,
+				CPU:    vms[i].CPU,
+				Memory: vms[i].Memory,
+			})
 		}
 	}
 
-	//go hv.checkUndefinedVMs()
+	if len(orphans) != 0 && !sameOrphans(hv.Orphans, orphans) {
+		log.Warn().
+			Str("hv", hv.Hostname).
+			Int("orphans", len(orphans)).
+			Msg("Found libvirt domains that are not in the database")
+	}
+	hv.Orphans = orphans
 
 	return nil
 }
 
-func (hv *HV) checkVMConsistency(dbvm *VM) {
-	dom := dbvm.Domain
+// Take in what was just read for the VM but its domain, keeping the NICs
+// and disks others may hold, called with the VM's mutex held
+func (vm *VM) merge(fresh *VM) {
+	vm.HV = fresh.HV
+	vm.Hostname = fresh.Hostname
+	vm.UserID = fresh.UserID
+	vm.Project = fresh.Project
+	vm.Flavor = fresh.Flavor
+	vm.Image = fresh.Image
+	vm.CPU = fresh.CPU
+	vm.Memory = fresh.Memory
+	vm.Created = fresh.Created
+	vm.Updated = fresh.Updated
+	vm.Remarks = fresh.Remarks
+	vm.Lost = fresh.Lost
+	vm.Nics = mergeNics(vm.Nics, fresh.Nics)
+	vm.Storages = mergeStorages(vm.Storages, fresh.Storages)
+	vm.touch()
+}
+
+func mergeNics(held, fresh map[string]*VMNic) map[string]*VMNic {
+	if fresh == nil {
+		return nil
+	}
+
+	nics := make(map[string]*VMNic, len(fresh))
+	for name, f := range fresh {
+		nic, ok := held[name]
+		if !ok || nic.ID != f.ID {
+			nics[name] = f
+			continue
+		}
+
+		nic.Mutex.Lock()
+		nic.Bridge = f.Bridge
+		nic.Network = f.Network
+		nic.MAC = f.MAC
+		nic.IPs = f.IPs
+		nic.Created = f.Created
+		nic.Updated = f.Updated
+		nic.Remarks = f.Remarks
+		nic.Mutex.Unlock()
+		nics[name] = nic
+	}
+
+	return nics
+}
+
+func mergeStorages(held, fresh map[string]*VMStorage) map[string]*VMStorage {
+	if fresh == nil {
+		return nil
+	}
+
+	disks := make(map[string]*VMStorage, len(fresh))
+	for id, f := range fresh {
+		disk, ok := held[id]
+		if !ok {
+			disks[id] = f
+			continue
+		}
+
+		disk.Mutex.Lock()
+		disk.Name = f.Name
+		disk.Root = f.Root
+		disk.Storage = f.Storage
+		disk.Size = f.Size
+		disk.Created = f.Created
+		disk.Updated = f.Updated
+		disk.Remarks = f.Remarks
+		disk.Mutex.Unlock()
+		disks[id] = disk
+	}
+
+	return disks
+}
+
+func sameOrphans(a, b []OrphanDomain) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	ids := make(map[uuid.UUID]bool, len(a))
+	for _, o := range a {
+		ids[o.ID] = true
+	}
+	for _, o := range b {
+		if !ids[o.ID] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Difference between what we have in the database and what libvirt reports
+type VMDrift struct {
+	VM       uuid.UUID `json:"vm"`
+	Hostname string    `json:"hostname"`
+	Field    string    `json:"field"`
+	DB       int64     `json:"db"`
+	Libvirt  int64     `json:"libvirt"`
+}
+
+func vmDrift(dbvm *VM) (drift []VMDrift) {
+	dom := &dbvm.Domain
 
 	// Check for CPU count
 	if dom.CPU != dbvm.CPU {
-		log.Warn().
-			Str("hv", hv.Hostname).
-			Str("vm", dbvm.Hostname).
-			Int("db", dbvm.CPU).
-			Int("libvirt", dom.CPU).
-			Msg("CPU count mismatch")
+		drift = append(drift, VMDrift{
+			VM:       dbvm.ID,
+			Hostname: dbvm.Hostname,
+			Field:    "cpu",
+			DB:       int64(dbvm.CPU),
+			Libvirt:  int64(dom.CPU),
+		})
 	}
 
 	// Check for memory size
 	if dom.Memory != dbvm.Memory {
+		drift = append(drift, VMDrift{
+			VM:       dbvm.ID,
+			Hostname: dbvm.Hostname,
+			Field:    "memory",
+			DB:       dbvm.Memory,
+			Libvirt:  dom.Memory,
+		})
+	}
+
+	return
+}
+
+var driftMessages = map[string]string{
+	"cpu":    "CPU count mismatch",
+	"memory": "Memory size mismatch",
+}
+
+// Warn about the drift of the VM, unless it is what we warned about last
+// time, as the monitor reloads the VMs every round. Called with the VM's
+// mutex held.
+func (hv *HV) checkVMConsistency(dbvm *VM) []VMDrift {
+	drift := vmDrift(dbvm)
+
+	same := len(drift) == len(dbvm.drifted)
+	for i := 0; same && i < len(drift); i++ {
+		same = drift[i] == dbvm.drifted[i]
+	}
+	dbvm.drifted = drift
+	if same {
+		return drift
+	}
+
+	for _, d := range drift {
 		log.Warn().
 			Str("hv", hv.Hostname).
-			Str("vm", dbvm.Hostname).
-			Int64("db", dbvm.Memory).
-			Int64("libvirt", dom.Memory).
-			Msg("Memory size mismatch")
+			Str("vm", d.Hostname).
+			Int64("db", d.DB).
+			Int64("libvirt", d.Libvirt).
+			Msg(driftMessages[d.Field])
 	}
+
+	return drift
 }
 
 func (hv *HV) DeleteVM(ctx context.Context, vmid string) error {
@@ -175,14 +447,28 @@ func (hv *HV) DeleteVM(ctx context.Context, vmid string) error {
 		return err
 	}
 
-	if _, err := db.Pool.Exec(
-		ctx,
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Release its addresses and everything else hanging off the vm row
+	queries := []string{
+		"DELETE FROM ip_addresses WHERE vm_id = $1 AND state = 'allocated'",
+		"DELETE FROM vm_nic WHERE vm_id = $1",
+		"DELETE FROM vm_storage WHERE vm_id = $1",
 		"DELETE FROM vm WHERE id = $1",
-		vmid,
-	); err != nil {
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query, vmid); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
 		return err
 	}
 
-	err := hv.InitVMs()
-	return err
+	return hv.InitVMs()
 }
@@ -2,10 +2,16 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/ipam"
+	"github.com/BasedDevelopment/eve/internal/project"
 	"github.com/BasedDevelopment/eve/internal/util"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
 )
 
 func (hv *HV) CreateVM(ctx context.Context, vm *util.VMCreateRequest, hvid uuid.UUID) (uuid.UUID, error) {
@@ -15,28 +21,173 @@ func (hv *HV) CreateVM(ctx context.Context, vm *util.VMCreateRequest, hvid uuid.
 		return vmid, err
 	}
 
+	if hv.InMaintenance() {
+		return vmid, ErrHVMaintenance
+	}
+
+	// The image paths differ between hypervisors, so they are only known now
+	if err := hv.ApplyImage(ctx, vm); err != nil {
+		return vmid, err
+	}
+
+	// Fail before anything is made, insertVM checks again for good
+	if err := CheckCreateQuota(ctx, vm); err != nil {
+		return vmid, err
+	}
+
+	// Only hand auto bridges we know of
+	networks, err := hv.CheckIfaces(vm)
+	if err != nil {
+		return vmid, err
+	}
+
+	// Held until the disks are recorded, so the next create counts them
+	hv.placement.Lock()
+	defer hv.placement.Unlock()
+
+	disks, err := hv.PlaceDisks(ctx, vm)
+	if err != nil {
+		return vmid, err
+	}
+	for i := range disks {
+		vm.Disk[i].Path = disks[i].Path
+	}
+
+	// MACs and addresses are picked before the domain exists so it can be
+	// given them
+	if err := assignMACs(ctx, vmid, vm); err != nil {
+		return vmid, err
+	}
+
+	bridges := make([]string, len(vm.Iface))
+	for i := range vm.Iface {
+		bridges[i] = vm.Iface[i].Bridge
+	}
+
+	hv.Mutex.Lock()
+	site := hv.Site
+	hv.Mutex.Unlock()
+
+	addrs, err := ipam.AllocateForVM(ctx, hvid, site, vmid, bridges)
+	if err != nil {
+		return vmid, err
+	}
+
+	if err := renderCloudInit(ctx, vmid, vm, addrs); err != nil {
+		ipam.ReleaseVM(ctx, vmid)
+		return vmid, err
+	}
+
 	err = hv.Auto.CreateVM(vm, vmid)
 	if err != nil {
+		ipam.ReleaseVM(ctx, vmid)
+		return vmid, err
+	}
+
+	if err := insertVM(ctx, vmid, hvid, vm, networks, addrs, disks); err != nil {
+		ipam.ReleaseVM(ctx, vmid)
+		// Over quota or not recorded at all, the domain has to go or it is
+		// left behind as an orphan
+		if delErr := hv.Auto.DeleteVM(vmid.String()); delErr != nil {
+			log.Error().Err(delErr).Str("vm", vmid.String()).Msg("Failed to delete VM that could not be recorded")
+		}
 		return vmid, err
 	}
 
+	err = hv.InitVMs()
+	return vmid, err
+}
+
+// insertVM records a new VM, its NICs and its disks
+func insertVM(ctx context.Context, vmid uuid.UUID, hvid uuid.UUID, vm *util.VMCreateRequest, networks []uuid.UUID, addrs []ipam.Address, disks []DiskPlacement) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	add := createUsage(vm)
+	add.IPs = len(addrs)
+	if err := checkQuota(ctx, tx, vm.User, add); err != nil {
+		return err
+	}
+
+	projectID, err := vmProject(ctx, tx, vm)
+	if err != nil {
+		return err
+	}
+
 	//don't use vm.Id here, it's not set
-	_, err = db.Pool.Exec(
+	_, err = tx.Exec(
 		ctx,
-		"INSERT INTO vm (id, hv_id, hostname, profile_id, cpu, memory) VALUES ($1, $2, $3, $4, $5, $6)",
+		"INSERT INTO vm (id, hv_id, hostname, profile_id, project_id, flavor_id, image_id, cpu, memory) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
 		vmid,
 		hvid,
 		vm.Hostname,
 		vm.User,
+		projectID,
+		vm.Flavor,
+		vm.ImageID,
 		vm.CPU,
 		// req is sent as mb, but we want to store it as bytes
 		vm.Memory*1024*1024,
 	)
 
 	if err != nil {
-		return vmid, err
+		return err
 	}
 
-	err = hv.InitVMs()
-	return vmid, err
+	if err := insertNICs(ctx, tx, vmid, vm, networks, addrs); err != nil {
+		return err
+	}
+
+	// The first disk is the one the VM boots from
+	for i, disk := range disks {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO vm_storage (id, vm_id, name, root, storage_id, size) VALUES ($1, $2, $3, $4, $5, $6)",
+			uuid.New(), vmid, diskName(i), i == 0, disk.Storage, disk.Size,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// The project a new VM goes to: the one asked for, which the user has to be
+// able to operate in, or the user's default project
+func vmProject(ctx context.Context, tx pgx.Tx, vm *util.VMCreateRequest) (uuid.UUID, error) {
+	if vm.Project == nil {
+		return project.Default(ctx, tx, vm.User)
+	}
+
+	var role string
+	err := tx.QueryRow(ctx,
+		"SELECT role FROM project_member WHERE project_id = $1 AND profile_id = $2", *vm.Project, vm.User,
+	).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, project.ErrNotMember
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+
+	if !project.Allows(role, project.RoleOperator) {
+		return uuid.Nil, fmt.Errorf("%w as operator", project.ErrNotMember)
+	}
+	return *vm.Project, nil
+}
+
+// CheckIfaces makes sure every interface of a create request is on an enabled
+// network of the hypervisor, and returns the IDs of those networks
+func (hv *HV) CheckIfaces(vm *util.VMCreateRequest) ([]uuid.UUID, error) {
+	networks := make([]uuid.UUID, len(vm.Iface))
+	for i := range vm.Iface {
+		b, err := hv.enabledBridge(vm.Iface[i].Bridge)
+		if err != nil {
+			return nil, err
+		}
+		networks[i] = b.ID
+	}
+
+	return networks, nil
 }
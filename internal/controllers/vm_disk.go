@@ -0,0 +1,347 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrDiskNotFound = errors.New("disk not found")
+	ErrDiskShrink   = errors.New("disks can only grow")
+	ErrDiskRoot     = errors.New("the root disk can not be detached")
+	ErrDiskNoPool   = errors.New("disk is not on a known storage pool")
+)
+
+// diskName is the target device of the i-th disk of a domain: vda...vdz,
+// then vdaa, vdab and so on
+func diskName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('a'+(i-1)%26)) + name
+	}
+	return "vd" + name
+}
+
+// First target device the VM doesn't use yet, the caller holds the VM lock
+func (vm *VM) nextDiskName() string {
+	used := make(map[string]bool, len(vm.Storages))
+	for _, disk := range vm.Storages {
+		used[disk.Name] = true
+	}
+
+	for i := 0; ; i++ {
+		if name := diskName(i); !used[name] {
+			return name
+		}
+	}
+}
+
+// Disks of the VM, sorted by target device
+func (vm *VM) ListDisks() []*VMStorage {
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	disks := make([]*VMStorage, 0, len(vm.Storages))
+	for _, disk := range vm.Storages {
+		disks = append(disks, disk)
+	}
+
+	sort.Slice(disks, func(i, j int) bool {
+		if len(disks[i].Name) != len(disks[j].Name) {
+			return len(disks[i].Name) < len(disks[j].Name)
+		}
+		return disks[i].Name < disks[j].Name
+	})
+
+	return disks
+}
+
+func (vm *VM) GetDisk(id uuid.UUID) (*VMStorage, bool) {
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	disk, ok := vm.Storages[id.String()]
+	return disk, ok
+}
+
+// CheckDiskAttach makes sure a new disk has a pool to go to and fits in the
+// quota of the VM's owner
+func (hv *HV) CheckDiskAttach(ctx context.Context, vm *VM, req *util.DiskCreateRequest) (DiskPlacement, error) {
+	vm.Mutex.Lock()
+	owner := vm.UserID
+	vm.Mutex.Unlock()
+
+	if err := CheckQuota(ctx, owner, Usage{Disk: req.Size * GiB}); err != nil {
+		return DiskPlacement{}, err
+	}
+
+	if err := hv.RefreshStorageUsage(ctx); err != nil {
+		return DiskPlacement{}, err
+	}
+
+	disk := DiskPlacement{Size: req.Size * GiB}
+	if req.Storage != nil {
+		s, ok := hv.GetStorage(*req.Storage)
+		if !ok {
+			return DiskPlacement{}, ErrStorageNotFound
+		}
+		s.Mutex.Lock()
+		disk.Path = s.Path
+		s.Mutex.Unlock()
+	}
+
+	placed, err := placeDisks(hv.diskPools(), []DiskPlacement{disk})
+	if err != nil {
+		return DiskPlacement{}, err
+	}
+
+	return placed[0], nil
+}
+
+// CheckDiskResize makes sure a disk grows and that its pool and the quota of
+// the VM's owner have room for the difference
+func (hv *HV) CheckDiskResize(ctx context.Context, vm *VM, id uuid.UUID, size int64) error {
+	disk, ok := vm.GetDisk(id)
+	if !ok {
+		return ErrDiskNotFound
+	}
+
+	disk.Mutex.Lock()
+	current, pool := disk.Size, disk.Storage
+	disk.Mutex.Unlock()
+
+	if size*GiB <= current {
+		return ErrDiskShrink
+	}
+
+	vm.Mutex.Lock()
+	owner := vm.UserID
+	vm.Mutex.Unlock()
+
+	if err := CheckQuota(ctx, owner, Usage{Disk: size*GiB - current}); err != nil {
+		return err
+	}
+
+	if pool == nil {
+		return ErrDiskNoPool
+	}
+
+	if err := hv.RefreshStorageUsage(ctx); err != nil {
+		return err
+	}
+
+	for _, p := range hv.diskPools() {
+		if p.ID == *pool {
+			if p.Free < size*GiB-current {
+				return fmt.Errorf("%w at %s", ErrStorageFull, p.Path)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrDiskNoPool, pool)
+}
+
+// CheckDiskDetach makes sure a disk exists and isn't the one the VM boots from
+func (vm *VM) CheckDiskDetach(id uuid.UUID) error {
+	disk, ok := vm.GetDisk(id)
+	if !ok {
+		return ErrDiskNotFound
+	}
+
+	disk.Mutex.Lock()
+	defer disk.Mutex.Unlock()
+
+	if disk.Root {
+		return ErrDiskRoot
+	}
+
+	return nil
+}
+
+// Create a new disk on a pool with room for it and attach it to the VM
+func (hv *HV) AttachDisk(ctx context.Context, vm *VM, req *util.DiskCreateRequest) (*VMStorage, error) {
+	hv.placement.Lock()
+	defer hv.placement.Unlock()
+
+	placed, err := hv.CheckDiskAttach(ctx, vm, req)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	// Held until the disk is recorded, so the quota counts it
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkQuota(ctx, tx, vm.UserID, Usage{Disk: placed.Size}); err != nil {
+		return nil, err
+	}
+
+	name := vm.nextDiskName()
+
+	if err := hv.Auto.AttachDisk(vm.ID.String(), name, placed.Path, placed.Size); err != nil {
+		return nil, err
+	}
+
+	disk, err := recordDisk(ctx, tx, vm.ID, name, placed, req.Remarks)
+	if err != nil {
+		// Without its row nothing would know about the disk, take it off again
+		if detErr := hv.Auto.DetachDisk(vm.ID.String(), name); detErr != nil {
+			log.Error().Err(detErr).Str("vm", vm.ID.String()).Str("disk", name).Msg("Failed to detach disk that could not be recorded")
+		}
+		return nil, err
+	}
+
+	if vm.Storages == nil {
+		vm.Storages = make(map[string]*VMStorage)
+	}
+	vm.Storages[disk.ID.String()] = disk
+	vm.touch()
+
+	return disk, nil
+}
+
+// Record an attached disk and commit tx
+func recordDisk(ctx context.Context, tx pgx.Tx, vmid uuid.UUID, name string, placed DiskPlacement, remarks string) (*VMStorage, error) {
+	rows, err := tx.Query(ctx,
+		"INSERT INTO vm_storage (id, vm_id, name, root, storage_id, size, remarks) VALUES ($1, $2, $3, false, $4, $5, $6) RETURNING *",
+		uuid.New(),
+		vmid,
+		name,
+		placed.Storage,
+		placed.Size,
+		remarks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error inserting vm_storage: %w", err)
+	}
+
+	disk, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[VMStorage])
+	if err != nil {
+		return nil, fmt.Errorf("Error collecting vm_storage: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return disk, nil
+}
+
+// Grow a disk of the VM to size GiB
+func (hv *HV) ResizeDisk(ctx context.Context, vm *VM, id uuid.UUID, size int64) (*VMStorage, error) {
+	hv.placement.Lock()
+	defer hv.placement.Unlock()
+
+	if err := hv.CheckDiskResize(ctx, vm, id, size); err != nil {
+		return nil, err
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	disk, ok := vm.Storages[id.String()]
+	if !ok {
+		return nil, ErrDiskNotFound
+	}
+
+	disk.Mutex.Lock()
+	defer disk.Mutex.Unlock()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkQuota(ctx, tx, vm.UserID, Usage{Disk: growth(disk.Size, size*GiB)}); err != nil {
+		return nil, err
+	}
+
+	if err := hv.Auto.ResizeDisk(vm.ID.String(), disk.Name, size*GiB); err != nil {
+		return nil, err
+	}
+
+	var updated time.Time
+	if err := tx.QueryRow(ctx,
+		"UPDATE vm_storage SET size = $1, updated = now() WHERE id = $2 RETURNING updated",
+		size*GiB, id,
+	).Scan(&updated); err != nil {
+		return nil, fmt.Errorf("Error updating vm_storage: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	disk.Updated = updated
+
+	disk.Size = size * GiB
+	vm.touch()
+
+	return disk, nil
+}
+
+// Detach a disk from the VM, its volume is deleted
+func (hv *HV) DetachDisk(ctx context.Context, vm *VM, id uuid.UUID) error {
+	hv.placement.Lock()
+	defer hv.placement.Unlock()
+
+	if err := vm.CheckDiskDetach(id); err != nil {
+		return err
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	disk, ok := vm.Storages[id.String()]
+	if !ok {
+		return ErrDiskNotFound
+	}
+
+	if err := hv.Auto.DetachDisk(vm.ID.String(), disk.Name); err != nil {
+		return err
+	}
+
+	if _, err := db.Pool.Exec(ctx, "DELETE FROM vm_storage WHERE id = $1", id); err != nil {
+		return fmt.Errorf("Error deleting vm_storage: %w", err)
+	}
+
+	delete(vm.Storages, id.String())
+	vm.touch()
+
+	return nil
+}
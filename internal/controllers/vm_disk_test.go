@@ -0,0 +1,34 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskName(t *testing.T) {
+	assert.Equal(t, "vda", diskName(0))
+	assert.Equal(t, "vdb", diskName(1))
+	assert.Equal(t, "vdz", diskName(25))
+	assert.Equal(t, "vdaa", diskName(26))
+	assert.Equal(t, "vdaz", diskName(51))
+	assert.Equal(t, "vdba", diskName(52))
+}
+
+func TestNextDiskName(t *testing.T) {
+	vm := &VM{}
+	assert.Equal(t, "vda", vm.nextDiskName())
+
+	vm.Storages = map[string]*VMStorage{}
+	for _, name := range []string{"vda", "vdc"} {
+		id := uuid.New()
+		vm.Storages[id.String()] = &VMStorage{ID: id, Name: name}
+	}
+
+	// Gaps left by detached disks are filled first
+	assert.Equal(t, "vdb", vm.nextDiskName())
+}
@@ -0,0 +1,323 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/auto"
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/placement"
+	"github.com/BasedDevelopment/eve/pkg/status"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrMigrateSameHV = errors.New("virtual machine is already on the target hypervisor")
+	ErrHVOffline     = errors.New("hypervisor is not online")
+	ErrHVNoMemory    = errors.New("hypervisor does not have enough free memory")
+	ErrHVNoRoom      = errors.New("hypervisor is out of room within its overcommit limits")
+	ErrVMNotRunning  = errors.New("virtual machine must be running for a live migration")
+)
+
+// How often auto is asked how far a migration is
+const migrationPoll = 5 * time.Second
+
+// What a VM needs on the hypervisor it migrates to
+type MigrationPlan struct {
+	Live     bool
+	Networks map[string]uuid.UUID     // NIC name to the network on the target
+	Disks    map[string]DiskPlacement // disk ID to the pool on the target
+	names    map[string]string        // disk ID to target device
+	sources  map[string]string        // disk ID to the pool path on the source
+}
+
+// Lock the same mutex of two hypervisors, always in the same order so two
+// migrations going opposite ways don't deadlock
+func lockPair(a *HV, b *HV, lock func(*HV)) {
+	if b.ID.String() < a.ID.String() {
+		a, b = b, a
+	}
+	lock(a)
+	lock(b)
+}
+
+// PlanMigration checks that the target hypervisor can take the VM: it has to
+// be online, have the memory for it, the networks of its NICs and room for
+// its disks. Live migration is used for running VMs unless live says
+// otherwise.
+func (hv *HV) PlanMigration(ctx context.Context, vm *VM, target *HV, live *bool) (MigrationPlan, error) {
+	plan := MigrationPlan{
+		Disks:   make(map[string]DiskPlacement),
+		names:   make(map[string]string),
+		sources: make(map[string]string),
+	}
+
+	// Copy what we need so the VM isn't locked while the target is
+	vm.Mutex.Lock()
+	cpu, memory := vm.CPU, vm.Memory
+	bridges := make(map[string]string, len(vm.Nics))
+	for name, nic := range vm.Nics {
+		bridges[name] = nic.Bridge
+	}
+	ids := make([]string, 0, len(vm.Storages))
+	disks := make([]DiskPlacement, 0, len(vm.Storages))
+	pools := make(map[string]uuid.UUID)
+	for id, disk := range vm.Storages {
+		ids = append(ids, id)
+		disks = append(disks, DiskPlacement{Size: disk.Size})
+		plan.names[id] = disk.Name
+		if disk.Storage != nil {
+			pools[id] = *disk.Storage
+		}
+	}
+	vm.Mutex.Unlock()
+
+	networks, err := hv.checkMigrationTarget(target, cpu, memory, bridges)
+	if err != nil {
+		return plan, err
+	}
+	plan.Networks = networks
+
+	state, err := hv.GetVMState(vm)
+	if err != nil {
+		return plan, err
+	}
+
+	running := state.State == status.StatusRunning
+	plan.Live = running
+	if live != nil {
+		plan.Live = *live
+	}
+	if plan.Live && !running {
+		return plan, ErrVMNotRunning
+	}
+	if !plan.Live && state.State != status.StatusShutoff {
+		return plan, fmt.Errorf("%w for a cold migration, it is %s", ErrVMNotShutoff, state.State)
+	}
+
+	// Where the disks are now, to migrate them back to if need be
+	for id, pool := range pools {
+		plan.sources[id], _ = hv.storagePath(pool)
+	}
+
+	if err := target.RefreshStorageUsage(ctx); err != nil {
+		return plan, err
+	}
+
+	placed, err := placeDisks(target.diskPools(), disks)
+	if err != nil {
+		return plan, fmt.Errorf("%s: %w", target.Hostname, err)
+	}
+	for i, id := range ids {
+		plan.Disks[id] = placed[i]
+	}
+
+	return plan, nil
+}
+
+// checkMigrationTarget makes sure the target is another hypervisor that is
+// online, out of maintenance and has room for cpu and memory, and returns the
+// networks the NICs on bridges are put on there
+func (hv *HV) checkMigrationTarget(target *HV, cpu int, memory int64, bridges map[string]string) (map[string]uuid.UUID, error) {
+	if target == hv {
+		return nil, ErrMigrateSameHV
+	}
+
+	if target.InMaintenance() {
+		return nil, fmt.Errorf("%w: %s", ErrHVMaintenance, target.Hostname)
+	}
+
+	if conn := target.ConnStatus(); conn.State != ConnOnline {
+		return nil, fmt.Errorf("%w: %s is %s", ErrHVOffline, target.Hostname, conn.State)
+	}
+
+	// Held to the same overcommit limits as placing a new VM
+	c, _, specs := target.resources()
+	if specs != nil {
+		if uint64(memory) > specs.RAMFree {
+			return nil, fmt.Errorf("%w: %s", ErrHVNoMemory, target.Hostname)
+		}
+
+		want := placement.Request{CPUs: cpu, Memory: uint64(memory)}
+		if reason := placement.Fits(c, want, overcommit()); reason != "" {
+			return nil, fmt.Errorf("%w: %s: %s", ErrHVNoRoom, target.Hostname, reason)
+		}
+	}
+
+	networks := make(map[string]uuid.UUID, len(bridges))
+	for name, bridge := range bridges {
+		b, err := target.enabledBridge(bridge)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target.Hostname, err)
+		}
+		networks[name] = b.ID
+	}
+
+	return networks, nil
+}
+
+// MigrateVM moves a VM to the target hypervisor through auto and records the
+// move. If it can't be recorded the VM is migrated back. progress is called
+// with how far along the migration is, in percent.
+func (hv *HV) MigrateVM(ctx context.Context, vm *VM, target *HV, live *bool, progress func(int)) error {
+	// No disk placement on either side until the disks have moved
+	lockPair(hv, target, func(h *HV) { h.placement.Lock() })
+	defer hv.placement.Unlock()
+	defer target.placement.Unlock()
+
+	plan, err := hv.PlanMigration(ctx, vm, target, live)
+	if err != nil {
+		return err
+	}
+	progress(10)
+
+	migration := auto.Migration{Target: target.Hostname, Live: plan.Live}
+	rollback := auto.Migration{Target: hv.Hostname, Live: plan.Live}
+	for id, disk := range plan.Disks {
+		migration.Disks = append(migration.Disks, auto.MigrationDisk{Name: plan.names[id], Path: disk.Path})
+		rollback.Disks = append(rollback.Disks, auto.MigrationDisk{Name: plan.names[id], Path: plan.sources[id]})
+	}
+
+	// auto only returns once the migration is done, ask it how far it is in
+	// the meantime
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(migrationPoll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if p, err := hv.Auto.MigrationProgress(vm.ID.String()); err == nil {
+					progress(10 + p*80/100)
+				}
+			}
+		}
+	}()
+
+	err = hv.Auto.MigrateVM(vm.ID.String(), migration)
+	close(done)
+	if err != nil {
+		return fmt.Errorf("migration to %s failed, the VM stays on %s: %w", target.Hostname, hv.Hostname, err)
+	}
+	progress(90)
+
+	if err := hv.moveVM(ctx, vm, target, plan); err != nil {
+		if rbErr := target.Auto.MigrateVM(vm.ID.String(), rollback); rbErr != nil {
+			log.Error().
+				Err(rbErr).
+				Str("vm", vm.ID.String()).
+				Str("hv", target.Hostname).
+				Msg("Failed to migrate VM back after failing to record its migration")
+			return fmt.Errorf("failed to record migration: %w, and to migrate back: %v", err, rbErr)
+		}
+		return fmt.Errorf("failed to record migration, the VM was migrated back: %w", err)
+	}
+
+	return nil
+}
+
+// moveVM records a migrated VM on the target hypervisor, in the database and
+// in memory
+func (hv *HV) moveVM(ctx context.Context, vm *VM, target *HV, plan MigrationPlan) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE vm SET hv_id = $1, updated = now() WHERE id = $2", target.ID, vm.ID,
+	); err != nil {
+		return err
+	}
+
+	vm.Mutex.Lock()
+	nics := make(map[string]uuid.UUID, len(vm.Nics))
+	for name, nic := range vm.Nics {
+		nics[name] = nic.ID
+	}
+	vm.Mutex.Unlock()
+
+	for name, network := range plan.Networks {
+		if _, err := tx.Exec(ctx,
+			"UPDATE vm_nic SET network_id = $1, updated = now() WHERE id = $2", network, nics[name],
+		); err != nil {
+			return err
+		}
+	}
+
+	for id, disk := range plan.Disks {
+		if _, err := tx.Exec(ctx,
+			"UPDATE vm_storage SET storage_id = $1, updated = now() WHERE id = $2", disk.Storage, id,
+		); err != nil {
+			return err
+		}
+	}
+
+	// A backup policy can't keep using a pool of the old hypervisor
+	if _, err := tx.Exec(ctx,
+		"UPDATE backup_policy SET enabled = false, next_run = NULL, updated = now() WHERE vm_id = $1 AND storage_id NOT IN (SELECT id FROM hv_storage WHERE hv_id = $2)",
+		vm.ID, target.ID,
+	); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	lockPair(hv, target, func(h *HV) { h.Mutex.Lock() })
+	defer hv.Mutex.Unlock()
+	defer target.Mutex.Unlock()
+
+	delete(hv.VMs, vm.ID)
+	target.VMs[vm.ID] = vm
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	vm.HV = target.ID
+	vm.touch()
+	for name, network := range plan.Networks {
+		if nic, ok := vm.Nics[name]; ok {
+			network := network
+			nic.Mutex.Lock()
+			nic.Network = &network
+			nic.Mutex.Unlock()
+		}
+	}
+	for id, placed := range plan.Disks {
+		if disk, ok := vm.Storages[id]; ok {
+			storage := placed.Storage
+			disk.Mutex.Lock()
+			disk.Storage = &storage
+			disk.Mutex.Unlock()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// An online hypervisor with threads and memory in GiB, all of it free
+func testTargetHV(hostname string, cpus int, memory uint64) *HV {
+	return &HV{
+		ID:       uuid.New(),
+		Hostname: hostname,
+		VMs:      make(map[uuid.UUID]*VM),
+		Bridges:  make(map[uuid.UUID]*Bridge),
+		Specs: &models.HV{
+			CPUCount: int32(cpus),
+			RAMTotal: memory * GiB,
+			RAMFree:  memory * GiB,
+		},
+		Conn: HVConnStatus{State: ConnOnline},
+	}
+}
+
+func useOvercommit(t *testing.T, cpu float64, memory float64) {
+	old := config.Config.Placement
+	config.Config.Placement.CPUOvercommit = cpu
+	config.Config.Placement.MemoryOvercommit = memory
+	t.Cleanup(func() { config.Config.Placement = old })
+}
+
+func TestCheckMigrationTarget(t *testing.T) {
+	useOvercommit(t, 2, 1)
+
+	source := testTargetHV("source", 8, 32)
+	target := testTargetHV("target", 4, 16)
+	public := &Bridge{ID: uuid.New(), Bridge: "br0", Enabled: true}
+	target.Bridges[public.ID] = public
+
+	nics := map[string]string{"eth0": "br0"}
+
+	networks, err := source.checkMigrationTarget(target, 2, 4*GiB, nics)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uuid.UUID{"eth0": public.ID}, networks)
+
+	_, err = source.checkMigrationTarget(source, 2, 4*GiB, nics)
+	assert.True(t, errors.Is(err, ErrMigrateSameHV), err)
+
+	// Networks are matched by bridge, and have to be enabled
+	_, err = source.checkMigrationTarget(target, 2, 4*GiB, map[string]string{"eth1": "br1"})
+	assert.True(t, errors.Is(err, ErrBridgeNotFound), err)
+
+	public.Enabled = false
+	_, err = source.checkMigrationTarget(target, 2, 4*GiB, nics)
+	assert.True(t, errors.Is(err, ErrBridgeDisabled), err)
+	public.Enabled = true
+
+	// CPUs are overcommitted, 4 threads take 8 vCPUs
+	_, err = source.checkMigrationTarget(target, 8, GiB, nil)
+	assert.NoError(t, err)
+	_, err = source.checkMigrationTarget(target, 9, GiB, nil)
+	assert.True(t, errors.Is(err, ErrHVNoRoom), err)
+
+	// What the VMs already there were given counts
+	target.VMs[uuid.New()] = &VM{CPU: 6, Memory: 12 * GiB}
+	_, err = source.checkMigrationTarget(target, 2, 4*GiB, nil)
+	assert.NoError(t, err)
+	_, err = source.checkMigrationTarget(target, 3, 4*GiB, nil)
+	assert.True(t, errors.Is(err, ErrHVNoRoom), err)
+	_, err = source.checkMigrationTarget(target, 2, 5*GiB, nil)
+	assert.True(t, errors.Is(err, ErrHVNoRoom), err)
+
+	// Memory the host doesn't have free right now is refused outright
+	target.Specs.RAMFree = 2 * GiB
+	_, err = source.checkMigrationTarget(target, 1, 3*GiB, nil)
+	assert.True(t, errors.Is(err, ErrHVNoMemory), err)
+
+	// Without specs from auto only the state of the target is checked
+	target.Specs = nil
+	_, err = source.checkMigrationTarget(target, 64, 512*GiB, nil)
+	assert.NoError(t, err)
+
+	target.Maintenance = true
+	_, err = source.checkMigrationTarget(target, 1, GiB, nil)
+	assert.True(t, errors.Is(err, ErrHVMaintenance), err)
+	target.Maintenance = false
+
+	for _, state := range []ConnState{ConnConnecting, ConnDegraded, ConnOffline} {
+		target.Conn.State = state
+		_, err = source.checkMigrationTarget(target, 1, GiB, nil)
+		assert.True(t, errors.Is(err, ErrHVOffline), err)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/ipam"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// How often we rehash a generated MAC that is already taken before giving up
+const macAttempts = 16
+
+var (
+	ErrInvalidMAC   = errors.New("invalid MAC address")
+	ErrDuplicateMAC = errors.New("MAC address already in use")
+)
+
+// parseMACPrefix turns the configured prefix into bytes, it is validated when
+// the configuration is loaded
+func parseMACPrefix(prefix string) []byte {
+	b, _ := hex.DecodeString(strings.ReplaceAll(prefix, ":", ""))
+	return b
+}
+
+// generateMAC derives a MAC address from the VM ID and the index of the NIC,
+// so the same NIC always gets the same address. attempt is bumped to get
+// another one on a collision.
+func generateMAC(prefix []byte, vmid uuid.UUID, index int, attempt int) string {
+	buf := make([]byte, 0, len(vmid)+16)
+	buf = append(buf, vmid[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(index))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(attempt))
+	sum := sha256.Sum256(buf)
+
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, prefix)
+	copy(mac[len(prefix):], sum[:])
+
+	return mac.String()
+}
+
+// normalizeMAC checks that a MAC address is a unicast ethernet address, and
+// returns it in lower case colon notation
+func normalizeMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("%w: %s", ErrInvalidMAC, mac)
+	}
+
+	if hw[0]&0x01 != 0 {
+		return "", fmt.Errorf("%w: %s is multicast", ErrInvalidMAC, mac)
+	}
+
+	return hw.String(), nil
+}
+
+func macInUse(ctx context.Context, mac string) (bool, error) {
+	var exists bool
+	err := db.Pool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM vm_nic WHERE mac = $1)", mac).Scan(&exists)
+	return exists, err
+}
+
+// assignMACs checks the MAC addresses given in a create request, and
+// generates the missing ones
+func assignMACs(ctx context.Context, vmid uuid.UUID, req *util.VMCreateRequest) error {
+	prefix := parseMACPrefix(config.Config.VM.MACPrefix)
+	seen := make(map[string]bool)
+
+	for i := range req.Iface {
+		iface := &req.Iface[i]
+
+		if iface.MAC != "" {
+			mac, err := normalizeMAC(iface.MAC)
+			if err != nil {
+				return err
+			}
+
+			used, err := macInUse(ctx, mac)
+			if err != nil {
+				return err
+			}
+
+			if used || seen[mac] {
+				return fmt.Errorf("%w: %s", ErrDuplicateMAC, mac)
+			}
+
+			iface.MAC = mac
+			seen[mac] = true
+			continue
+		}
+
+		for attempt := 0; ; attempt++ {
+			if attempt == macAttempts {
+				return fmt.Errorf("nic %d: failed to generate a free MAC address", i)
+			}
+
+			mac := generateMAC(prefix, vmid, i, attempt)
+			if seen[mac] {
+				continue
+			}
+
+			used, err := macInUse(ctx, mac)
+			if err != nil {
+				return err
+			}
+
+			if !used {
+				iface.MAC = mac
+				seen[mac] = true
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// insertNICs records the NICs of a new VM with their networks and the
+// addresses IPAM gave them
+func insertNICs(ctx context.Context, tx pgx.Tx, vmid uuid.UUID, req *util.VMCreateRequest, networks []uuid.UUID, addrs []ipam.Address) error {
+	for i, iface := range req.Iface {
+		ips := []netip.Addr{}
+		for _, addr := range addrs {
+			if addr.NIC != nil && *addr.NIC == i {
+				ips = append(ips, addr.Address)
+			}
+		}
+
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO vm_nic (id, vm_id, name, bridge, mac, ips, network_id) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			uuid.New(),              // id
+			vmid,                    // vm_id
+			fmt.Sprintf("eth%d", i), // name
+			iface.Bridge,            // bridge
+			iface.MAC,               // mac
+			ips,                     // ips
+			networks[i],             // network_id
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateMAC(t *testing.T) {
+	prefix := parseMACPrefix("02:e5:e0")
+	vmid := uuid.MustParse("8f1a6f0e-6a51-4f7e-9d3c-2b1f0c6d9e11")
+
+	mac := generateMAC(prefix, vmid, 0, 0)
+	assert.True(t, strings.HasPrefix(mac, "02:e5:e0:"), mac)
+	assert.Len(t, mac, 17)
+
+	// Same input, same address
+	assert.Equal(t, mac, generateMAC(prefix, vmid, 0, 0))
+
+	// Anything else changes it
+	assert.NotEqual(t, mac, generateMAC(prefix, vmid, 1, 0))
+	assert.NotEqual(t, mac, generateMAC(prefix, vmid, 0, 1))
+	assert.NotEqual(t, mac, generateMAC(prefix, uuid.New(), 0, 0))
+
+	// Longer prefixes leave fewer bytes to the hash
+	assert.True(t, strings.HasPrefix(generateMAC(parseMACPrefix("06:01:02:03:04"), vmid, 0, 0), "06:01:02:03:04:"))
+}
+
+func TestNormalizeMAC(t *testing.T) {
+	mac, err := normalizeMAC("52:54:00:AB:CD:EF")
+	assert.Nil(t, err)
+	assert.Equal(t, "52:54:00:ab:cd:ef", mac)
+
+	mac, err = normalizeMAC("52-54-00-ab-cd-ef")
+	assert.Nil(t, err)
+	assert.Equal(t, "52:54:00:ab:cd:ef", mac)
+
+	_, err = normalizeMAC("01:00:5e:00:00:01")
+	assert.ErrorIs(t, err, ErrInvalidMAC)
+
+	_, err = normalizeMAC("not a mac")
+	assert.ErrorIs(t, err, ErrInvalidMAC)
+
+	_, err = normalizeMAC("00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01")
+	assert.ErrorIs(t, err, ErrInvalidMAC)
+}
@@ -0,0 +1,198 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/project"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotOrphan = errors.New("domain is not an orphan")
+	ErrNotGhost  = errors.New("virtual machine is not a ghost")
+)
+
+// A libvirt domain that has no row in the vm table
+type OrphanDomain struct {
+	ID     uuid.UUID `json:"id"`
+	CPU    int       `json:"cpu"`
+	Memory int64     `json:"memory"`
+}
+
+// A row in the vm table that has no libvirt domain
+type GhostVM struct {
+	ID       uuid.UUID `json:"id"`
+	Hostname string    `json:"hostname"`
+	UserID   uuid.UUID `json:"user"`
+	Lost     bool      `json:"lost"`
+}
+
+type ReconcileReport struct {
+	HV        uuid.UUID      `json:"hv"`
+	Orphans   []OrphanDomain `json:"orphans"`
+	Ghosts    []GhostVM      `json:"ghosts"`
+	Drift     []VMDrift      `json:"drift"`
+	Generated time.Time      `json:"generated"`
+}
+
+// Compare the database against libvirt and report everything that doesn't
+// line up
+func (hv *HV) Reconcile() (report ReconcileReport, err error) {
+	if err = hv.InitVMs(); err != nil {
+		return
+	}
+
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	report.HV = hv.ID
+	report.Generated = time.Now()
+	report.Orphans = append([]OrphanDomain{}, hv.Orphans...)
+	report.Ghosts = []GhostVM{}
+	report.Drift = []VMDrift{}
+
+	for _, vm := range hv.VMs {
+		vm.Mutex.Lock()
+		if vm.Domain.ID == uuid.Nil {
+			report.Ghosts = append(report.Ghosts, GhostVM{
+				ID:       vm.ID,
+				Hostname: vm.Hostname,
+				UserID:   vm.UserID,
+				Lost:     vm.Lost,
+			})
+		} else {
+			report.Drift = append(report.Drift, vmDrift(vm)...)
+		}
+		vm.Mutex.Unlock()
+	}
+
+	return
+}
+
+// Adopt an orphan libvirt domain into the database under the given profile
+func (hv *HV) AdoptDomain(ctx context.Context, domid uuid.UUID, profileID uuid.UUID, hostname string) (*VM, error) {
+	hv.Mutex.Lock()
+	_, known := hv.VMs[domid]
+	hv.Mutex.Unlock()
+	if known {
+		return nil, ErrNotOrphan
+	}
+
+	// Make sure the domain is still there before we take it in
+	dom, err := hv.Auto.GetLibvirtVM(domid.String())
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	projectID, err := project.Default(ctx, tx, profileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(
+		ctx,
+		"INSERT INTO vm (id, hv_id, hostname, profile_id, project_id, cpu, memory) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		domid,
+		hv.ID,
+		hostname,
+		profileID,
+		projectID,
+		dom.CPU,
+		dom.Memory,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := hv.InitVMs(); err != nil {
+		return nil, err
+	}
+
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	return hv.VMs[domid], nil
+}
+
+// Mark a VM without a libvirt domain as lost, or delete its records entirely
+func (hv *HV) ResolveGhost(ctx context.Context, vm *VM, state string) error {
+	vm.Mutex.Lock()
+	ghost := vm.Domain.ID == uuid.Nil
+	vm.Mutex.Unlock()
+
+	if !ghost {
+		return ErrNotGhost
+	}
+
+	switch state {
+	case "lost":
+		if _, err := db.Pool.Exec(ctx, "UPDATE vm SET lost = TRUE, updated = now() WHERE id = $1", vm.ID); err != nil {
+			return err
+		}
+
+		vm.Mutex.Lock()
+		vm.Lost = true
+		vm.touch()
+		vm.Mutex.Unlock()
+	case "deleted":
+		tx, err := db.Pool.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		queries := []string{
+			"DELETE FROM ip_addresses WHERE vm_id = $1 AND state = 'allocated'",
+			"DELETE FROM vm_nic WHERE vm_id = $1",
+			"DELETE FROM vm_storage WHERE vm_id = $1",
+			"DELETE FROM vm WHERE id = $1",
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(ctx, query, vm.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+
+		hv.Mutex.Lock()
+		delete(hv.VMs, vm.ID)
+		hv.Mutex.Unlock()
+	default:
+		return errors.New("invalid ghost state: " + state)
+	}
+
+	return nil
+}
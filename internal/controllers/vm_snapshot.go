@@ -0,0 +1,259 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/BasedDevelopment/eve/pkg/status"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrSnapshotExists   = errors.New("a snapshot with this name already exists")
+	ErrSnapshotLimit    = errors.New("snapshot limit reached for this virtual machine")
+	ErrSnapshotBusy     = errors.New("snapshot is not ready")
+	ErrVMNotShutoff     = errors.New("virtual machine must be shut off")
+)
+
+// Snapshot states
+const (
+	SnapshotCreating  = "creating"
+	SnapshotReady     = "ready"
+	SnapshotReverting = "reverting"
+	SnapshotDeleting  = "deleting"
+)
+
+type Snapshot struct {
+	ID      uuid.UUID `json:"id"`
+	VM      uuid.UUID `json:"vm" db:"vm_id"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	State   string    `json:"state"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Remarks string    `json:"remarks"`
+}
+
+// Snapshots of a VM, oldest first
+func ListSnapshots(ctx context.Context, vmid uuid.UUID) ([]Snapshot, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM vm_snapshot WHERE vm_id = $1 ORDER BY created", vmid)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading vm_snapshot: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Snapshot])
+}
+
+func GetSnapshot(ctx context.Context, vmid uuid.UUID, id uuid.UUID) (Snapshot, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM vm_snapshot WHERE vm_id = $1 AND id = $2", vmid, id)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("Error reading vm_snapshot: %w", err)
+	}
+
+	s, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Snapshot])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, ErrSnapshotNotFound
+	}
+	return s, err
+}
+
+// Move a snapshot from one state to another, failing if something else moved
+// it first
+func setSnapshotState(ctx context.Context, id uuid.UUID, from string, to string) error {
+	tag, err := db.Pool.Exec(ctx,
+		"UPDATE vm_snapshot SET state = $1, updated = now() WHERE id = $2 AND state = $3", to, id, from)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrSnapshotBusy
+	}
+
+	return nil
+}
+
+// RecordSnapshot adds a snapshot that is being created, as long as the VM is
+// under the configured limit and its owner under their quota. The snapshot
+// itself is taken by CreateSnapshot.
+func RecordSnapshot(ctx context.Context, vm *VM, req *util.SnapshotCreateRequest) (Snapshot, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The profile row is locked before the VM row, like every quota check
+	if err := checkQuota(ctx, tx, vm.UserID, Usage{Snapshots: 1}); err != nil {
+		return Snapshot{}, err
+	}
+
+	// Lock the VM row so concurrent requests count each other
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM vm WHERE id = $1 FOR UPDATE", vm.ID); err != nil {
+		return Snapshot{}, err
+	}
+
+	if limit := config.Config.VM.MaxSnapshots; limit > 0 {
+		var count int
+		if err := tx.QueryRow(ctx,
+			"SELECT count(*) FROM vm_snapshot WHERE vm_id = $1", vm.ID,
+		).Scan(&count); err != nil {
+			return Snapshot{}, err
+		}
+
+		if count >= limit {
+			return Snapshot{}, ErrSnapshotLimit
+		}
+	}
+
+	rows, err := tx.Query(ctx,
+		"INSERT INTO vm_snapshot (id, vm_id, name, state, remarks) VALUES ($1, $2, $3, $4, $5) RETURNING *",
+		uuid.New(),
+		vm.ID,
+		req.Name,
+		SnapshotCreating,
+		req.Remarks,
+	)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("Error inserting vm_snapshot: %w", err)
+	}
+
+	s, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Snapshot])
+	if err != nil {
+		if isUniqueViolation(err) {
+			return s, ErrSnapshotExists
+		}
+		return s, fmt.Errorf("Error collecting vm_snapshot: %w", err)
+	}
+
+	return s, tx.Commit(ctx)
+}
+
+// Take a recorded snapshot through auto. Snapshots that fail are dropped so
+// they don't count against the limit.
+func (hv *HV) CreateSnapshot(ctx context.Context, vm *VM, s Snapshot) error {
+	res, err := hv.Auto.CreateSnapshot(vm.ID.String(), s.Name)
+	if err != nil {
+		DropSnapshot(ctx, s)
+		return err
+	}
+
+	_, err = db.Pool.Exec(ctx,
+		"UPDATE vm_snapshot SET state = $1, size = $2, updated = now() WHERE id = $3",
+		SnapshotReady, res.Size, s.ID)
+	return err
+}
+
+// Drop a recorded snapshot that won't be taken
+func DropSnapshot(ctx context.Context, s Snapshot) error {
+	_, err := db.Pool.Exec(ctx,
+		"DELETE FROM vm_snapshot WHERE id = $1 AND state = $2", s.ID, SnapshotCreating)
+	return err
+}
+
+// CheckSnapshotRevert makes sure the snapshot is ready and the VM is shut off
+func (hv *HV) CheckSnapshotRevert(ctx context.Context, vm *VM, id uuid.UUID) (Snapshot, error) {
+	s, err := GetSnapshot(ctx, vm.ID, id)
+	if err != nil {
+		return s, err
+	}
+
+	if s.State != SnapshotReady {
+		return s, ErrSnapshotBusy
+	}
+
+	state, err := hv.GetVMState(vm)
+	if err != nil {
+		return s, err
+	}
+
+	if state.State != status.StatusShutoff {
+		return s, fmt.Errorf("%w, it is %s", ErrVMNotShutoff, state.State)
+	}
+
+	return s, nil
+}
+
+func (hv *HV) RevertSnapshot(ctx context.Context, vm *VM, id uuid.UUID) error {
+	s, err := hv.CheckSnapshotRevert(ctx, vm, id)
+	if err != nil {
+		return err
+	}
+
+	if err := setSnapshotState(ctx, s.ID, SnapshotReady, SnapshotReverting); err != nil {
+		return err
+	}
+
+	revertErr := hv.Auto.RevertSnapshot(vm.ID.String(), s.Name)
+
+	// The snapshot is still there whether the revert worked or not
+	if err := setSnapshotState(ctx, s.ID, SnapshotReverting, SnapshotReady); err != nil {
+		return err
+	}
+
+	return revertErr
+}
+
+// CheckSnapshotDelete makes sure the snapshot exists and nothing else is
+// being done with it
+func CheckSnapshotDelete(ctx context.Context, vm *VM, id uuid.UUID) (Snapshot, error) {
+	s, err := GetSnapshot(ctx, vm.ID, id)
+	if err != nil {
+		return s, err
+	}
+
+	if s.State != SnapshotReady {
+		return s, ErrSnapshotBusy
+	}
+
+	return s, nil
+}
+
+func (hv *HV) DeleteSnapshot(ctx context.Context, vm *VM, id uuid.UUID) error {
+	s, err := CheckSnapshotDelete(ctx, vm, id)
+	if err != nil {
+		return err
+	}
+
+	if err := setSnapshotState(ctx, s.ID, SnapshotReady, SnapshotDeleting); err != nil {
+		return err
+	}
+
+	if err := hv.Auto.DeleteSnapshot(vm.ID.String(), s.Name); err != nil {
+		setSnapshotState(ctx, s.ID, SnapshotDeleting, SnapshotReady)
+		return err
+	}
+
+	if _, err := db.Pool.Exec(ctx, "DELETE FROM vm_snapshot WHERE id = $1", s.ID); err != nil {
+		return fmt.Errorf("Error deleting vm_snapshot: %w", err)
+	}
+
+	return nil
+}
@@ -23,20 +23,26 @@ import (
 	"github.com/BasedDevelopment/eve/internal/auto"
 )
 
+// GetVMState asks auto for the power state of the VM and records it, the VM
+// isn't locked while waiting on auto
 func (hv *HV) GetVMState(vm *VM) (models.VMState, error) {
-	vm.Mutex.Lock()
-	defer vm.Mutex.Unlock()
+	state, err := hv.Auto.GetVMState(vm.ID.String())
+	if err == nil {
+		vm.setState(state.StateStr)
+	}
 
-	id := vm.ID.String()
-	return hv.Auto.GetVMState(id)
+	return state, err
 }
 
-func (hv *HV) SetVMState(vm *VM, state string) (models.VMState, error) {
+// setState records the last power state auto reported for the VM, which is
+// what the metrics report
+func (vm *VM) setState(state string) {
 	vm.Mutex.Lock()
-	defer vm.Mutex.Unlock()
-
-	id := vm.ID.String()
+	vm.State = state
+	vm.Mutex.Unlock()
+}
 
+func (hv *HV) SetVMState(vm *VM, state string) (models.VMState, error) {
 	var status uint8
 	switch state {
 	case "start":
@@ -50,5 +56,11 @@ func (hv *HV) SetVMState(vm *VM, state string) (models.VMState, error) {
 	case "reset":
 		status = auto.Reset
 	}
-	return hv.Auto.SetVMState(id, status)
+
+	res, err := hv.Auto.SetVMState(vm.ID.String(), status)
+	if err == nil {
+		vm.setState(res.StateStr)
+	}
+
+	return res, err
 }
@@ -0,0 +1,54 @@
+//go:build !integration
+// +build !integration
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	kept, gone := uuid.New(), uuid.New()
+	disk := &VMStorage{ID: kept, Size: 10}
+	nic := &VMNic{ID: uuid.New(), Name: "eth0"}
+	vm := &VM{
+		CPU:      1,
+		State:    "running",
+		Nics:     map[string]*VMNic{"eth0": nic},
+		Storages: map[string]*VMStorage{kept.String(): disk, gone.String(): {ID: gone}},
+	}
+
+	added := uuid.New()
+	vm.merge(&VM{
+		CPU:  2,
+		Nics: map[string]*VMNic{"eth0": {ID: nic.ID, Name: "eth0", MAC: "52:54:00:00:00:01"}},
+		Storages: map[string]*VMStorage{
+			kept.String():  {ID: kept, Size: 20},
+			added.String(): {ID: added},
+		},
+	})
+
+	assert.Equal(t, 2, vm.CPU)
+	assert.Equal(t, "running", vm.State)
+	assert.Equal(t, uint64(1), vm.edits)
+
+	// Whoever holds the NIC or disk sees the new data
+	assert.Same(t, nic, vm.Nics["eth0"])
+	assert.Equal(t, "52:54:00:00:00:01", nic.MAC)
+	assert.Same(t, disk, vm.Storages[kept.String()])
+	assert.Equal(t, int64(20), disk.Size)
+
+	assert.Contains(t, vm.Storages, added.String())
+	assert.NotContains(t, vm.Storages, gone.String())
+}
+
+func TestSameOrphans(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	assert.True(t, sameOrphans(nil, []OrphanDomain{}))
+	assert.True(t, sameOrphans([]OrphanDomain{{ID: a}, {ID: b}}, []OrphanDomain{{ID: b}, {ID: a}}))
+	assert.False(t, sameOrphans([]OrphanDomain{{ID: a}}, []OrphanDomain{{ID: b}}))
+	assert.False(t, sameOrphans([]OrphanDomain{{ID: a}}, nil))
+}
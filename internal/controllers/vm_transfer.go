@@ -0,0 +1,93 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/project"
+	"github.com/google/uuid"
+)
+
+var ErrTransferSameProject = errors.New("virtual machine is already in the project")
+
+// What a VM counts for in the quota of the profile it is accounted to
+func vmUsage(ctx context.Context, q querier, vmid uuid.UUID) (Usage, error) {
+	u := Usage{VMs: 1}
+	err := q.QueryRow(ctx, `SELECT
+		(SELECT cpu FROM vm WHERE id = $1),
+		(SELECT memory FROM vm WHERE id = $1),
+		(SELECT coalesce(sum(size), 0)::bigint FROM vm_storage WHERE vm_id = $1),
+		(SELECT count(*) FROM ip_addresses WHERE vm_id = $1),
+		(SELECT count(*) FROM vm_snapshot WHERE vm_id = $1)`,
+		vmid,
+	).Scan(&u.CPU, &u.Memory, &u.Disk, &u.IPs, &u.Snapshots)
+	return u, err
+}
+
+// TransferVM moves a VM to another project. It is then accounted to the
+// oldest owner of that project, whose quota has to have room for it.
+func (hv *HV) TransferVM(ctx context.Context, vm *VM, projectID uuid.UUID) error {
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	if vm.Project == projectID {
+		return ErrTransferSameProject
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	owner, err := project.Owner(ctx, tx, projectID)
+	if err != nil {
+		return err
+	}
+
+	if owner != vm.UserID {
+		add, err := vmUsage(ctx, tx, vm.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkQuota(ctx, tx, owner, add); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE vm SET project_id = $1, profile_id = $2, updated = now() WHERE id = $3",
+		projectID, owner, vm.ID,
+	); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	vm.Project = projectID
+	vm.UserID = owner
+	vm.touch()
+
+	return nil
+}
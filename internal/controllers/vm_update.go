@@ -0,0 +1,202 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/auto"
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Outcome of a VM update
+type VMUpdateResult struct {
+	Live           bool `json:"live"`
+	RebootRequired bool `json:"reboot_required"`
+}
+
+// Update the hostname, CPU count, memory, and remarks of a VM, or resize it to
+// a flavor. Everything is checked and committed before auto is asked, if it
+// refuses the rows are put back.
+func (hv *HV) UpdateVM(ctx context.Context, vm *VM, req *util.VMUpdateRequest) (res VMUpdateResult, err error) {
+	// Held so the space the root disk grows into stays free
+	hv.placement.Lock()
+	defer hv.placement.Unlock()
+
+	var rootID uuid.UUID
+	var rootSize int64
+	if req.Flavor != nil {
+		if rootID, rootSize, err = hv.checkFlavorResize(ctx, vm, req); err != nil {
+			return
+		}
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	// The root disk grows on its own and can't be undone, so it only does
+	// once everything else is known to go through
+	var root *VMStorage
+	if rootID != uuid.Nil {
+		var ok bool
+		if root, ok = vm.Storages[rootID.String()]; !ok {
+			return res, ErrDiskNotFound
+		}
+		root.Mutex.Lock()
+		defer root.Mutex.Unlock()
+	}
+
+	hostname, cpu, memory, remarks, flavorID := vm.Hostname, vm.CPU, vm.Memory, vm.Remarks, vm.Flavor
+	if req.Hostname != nil {
+		hostname = *req.Hostname
+	}
+	if req.CPU != nil {
+		cpu = *req.CPU
+	}
+	if req.Memory != nil {
+		// req is sent as mb, but we want to store it as bytes
+		memory = int64(*req.Memory) * 1024 * 1024
+	}
+	if req.Remarks != nil {
+		remarks = *req.Remarks
+	}
+	// Changed by hand the VM no longer matches its flavor
+	if req.Flavor != nil {
+		flavorID = req.Flavor
+	} else if cpu != vm.CPU || memory != vm.Memory {
+		flavorID = nil
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	add := Usage{
+		CPU:    int(growth(int64(vm.CPU), int64(cpu))),
+		Memory: growth(vm.Memory, memory),
+	}
+	if root != nil {
+		add.Disk = growth(root.Size, rootSize)
+	}
+	if err = checkQuota(ctx, tx, vm.UserID, add); err != nil {
+		return
+	}
+
+	var updated time.Time
+	if err = tx.QueryRow(ctx,
+		"UPDATE vm SET hostname = $2, cpu = $3, memory = $4, remarks = $5, flavor_id = $6, updated = now() WHERE id = $1 RETURNING updated",
+		vm.ID,
+		hostname,
+		cpu,
+		memory,
+		remarks,
+		flavorID,
+	).Scan(&updated); err != nil {
+		return
+	}
+
+	var rootUpdated time.Time
+	if root != nil {
+		if err = tx.QueryRow(ctx,
+			"UPDATE vm_storage SET size = $1, updated = now() WHERE id = $2 RETURNING updated",
+			rootSize, root.ID,
+		).Scan(&rootUpdated); err != nil {
+			err = fmt.Errorf("Error updating vm_storage: %w", err)
+			return
+		}
+	}
+
+	// Committed before auto is asked, so the profile isn't held while it works
+	if err = tx.Commit(ctx); err != nil {
+		return
+	}
+
+	if root != nil {
+		if err = hv.Auto.ResizeDisk(vm.ID.String(), root.Name, rootSize); err != nil {
+			vm.revertUpdate(ctx, root)
+			return
+		}
+		root.Size = rootSize
+		root.Updated = rootUpdated
+		vm.touch()
+	}
+
+	// Hostname and remarks only live in our database, the rest goes to auto
+	res.Live = true
+	if cpu != vm.CPU || memory != vm.Memory {
+		autoRes, autoErr := hv.Auto.UpdateVM(vm.ID.String(), auto.VMUpdate{
+			CPU:    cpu,
+			Memory: int(memory / 1024 / 1024),
+		})
+		if autoErr != nil {
+			// The root disk has grown for good, only the VM row goes back
+			vm.revertUpdate(ctx, nil)
+			return res, autoErr
+		}
+		res.Live = autoRes.Live
+		res.RebootRequired = !autoRes.Live
+	}
+
+	vm.Hostname = hostname
+	vm.CPU = cpu
+	vm.Memory = memory
+	vm.Remarks = remarks
+	vm.Flavor = flavorID
+	vm.Updated = updated
+	vm.touch()
+
+	return
+}
+
+// Put the VM's row, and the disk's if given, back to what is still held in
+// memory after auto refused an update, called with the VM's mutex held
+func (vm *VM) revertUpdate(ctx context.Context, disk *VMStorage) {
+	if _, err := db.Pool.Exec(ctx,
+		"UPDATE vm SET hostname = $2, cpu = $3, memory = $4, remarks = $5, flavor_id = $6, updated = $7 WHERE id = $1",
+		vm.ID,
+		vm.Hostname,
+		vm.CPU,
+		vm.Memory,
+		vm.Remarks,
+		vm.Flavor,
+		vm.Updated,
+	); err != nil {
+		log.Error().Err(err).Str("vm", vm.ID.String()).Msg("Failed to revert VM update auto refused")
+	}
+
+	if disk == nil {
+		return
+	}
+
+	if _, err := db.Pool.Exec(ctx,
+		"UPDATE vm_storage SET size = $2, updated = $3 WHERE id = $1",
+		disk.ID,
+		disk.Size,
+		disk.Updated,
+	); err != nil {
+		log.Error().Err(err).Str("vm", vm.ID.String()).Str("disk", disk.Name).Msg("Failed to revert disk resize auto refused")
+	}
+}
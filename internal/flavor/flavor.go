@@ -0,0 +1,166 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package flavor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrFlavorNotFound = errors.New("flavor not found")
+	ErrFlavorInactive = errors.New("flavor is not active")
+	ErrFlavorInUse    = errors.New("flavor is still used by virtual machines")
+)
+
+// A flavor is a plan VMs are created from. Its resources can't change once
+// made, VMs on it would no longer match, a new flavor is made instead and the
+// old one deactivated.
+type Flavor struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CPU       int       `json:"cpu" db:"cpu"`
+	Memory    int64     `json:"memory" db:"memory"`       // bytes
+	Disk      int       `json:"disk" db:"disk"`           // GiB, of the root disk
+	Bandwidth int       `json:"bandwidth" db:"bandwidth"` // Mbit/s, 0 is unmetered
+	Price     *int64    `json:"price" db:"price"`         // monthly, in the smallest unit of the currency
+	Active    bool      `json:"active" db:"active"`       // only active flavors are offered and used for new VMs
+	Created   time.Time `json:"created" db:"created"`
+	Updated   time.Time `json:"updated" db:"updated"`
+	Remarks   string    `json:"remarks" db:"remarks"`
+	VMs       int       `json:"vms,omitempty" db:"vms"` // on the flavor, only shown to admins
+}
+
+const columns = "f.*, (SELECT count(*) FROM vm WHERE vm.flavor_id = f.id)::integer AS vms"
+
+// List the flavors, inactive ones too if all is set
+func List(ctx context.Context, all bool) ([]Flavor, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT "+columns+" FROM flavor f WHERE f.active OR $1 ORDER BY f.cpu, f.memory, f.disk, f.name",
+		all,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading flavors: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Flavor])
+}
+
+func Get(ctx context.Context, id uuid.UUID) (Flavor, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT "+columns+" FROM flavor f WHERE f.id = $1", id)
+	if err != nil {
+		return Flavor{}, fmt.Errorf("Error reading flavor: %w", err)
+	}
+
+	f, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Flavor])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return f, ErrFlavorNotFound
+	}
+	return f, err
+}
+
+// Usable gets a flavor a VM can be created or resized to
+func Usable(ctx context.Context, id uuid.UUID) (Flavor, error) {
+	f, err := Get(ctx, id)
+	if err != nil {
+		return f, err
+	}
+
+	if !f.Active {
+		return f, ErrFlavorInactive
+	}
+	return f, nil
+}
+
+func Create(ctx context.Context, f Flavor) (Flavor, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		"INSERT INTO flavor (id, name, cpu, memory, disk, bandwidth, price, active, remarks) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING *, 0 AS vms",
+		uuid.New(),  // id
+		f.Name,      // name
+		f.CPU,       // cpu
+		f.Memory,    // memory
+		f.Disk,      // disk
+		f.Bandwidth, // bandwidth
+		f.Price,     // price
+		f.Active,    // active
+		f.Remarks,   // remarks
+	)
+	if err != nil {
+		return Flavor{}, fmt.Errorf("Error inserting flavor: %w", err)
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[Flavor])
+}
+
+// Update writes the name, bandwidth, price, state and remarks of a flavor
+func Update(ctx context.Context, f Flavor) (Flavor, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		"UPDATE flavor f SET name = $1, bandwidth = $2, price = $3, active = $4, remarks = $5, updated = now() WHERE id = $6 RETURNING "+columns,
+		f.Name,      // name
+		f.Bandwidth, // bandwidth
+		f.Price,     // price
+		f.Active,    // active
+		f.Remarks,   // remarks
+		f.ID,        // id
+	)
+	if err != nil {
+		return Flavor{}, fmt.Errorf("Error updating flavor: %w", err)
+	}
+
+	f, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[Flavor])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return f, ErrFlavorNotFound
+	}
+	return f, err
+}
+
+// Delete a flavor no VM is on, used ones can only be deactivated
+func Delete(ctx context.Context, id uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var vms int
+	if err := tx.QueryRow(ctx, "SELECT count(*) FROM vm WHERE flavor_id = $1", id).Scan(&vms); err != nil {
+		return err
+	}
+	if vms > 0 {
+		return ErrFlavorInUse
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM flavor WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrFlavorNotFound
+	}
+
+	return tx.Commit(ctx)
+}
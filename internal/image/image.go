@@ -0,0 +1,211 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Kinds of image, each goes to the storage pools flagged for it
+const (
+	KindCloud = "cloud" // disk image the root disk is made from
+	KindISO   = "iso"   // installer the VM boots from
+)
+
+// States of an image in a storage pool
+const (
+	LocationSynced = "synced"
+	LocationFailed = "failed"
+)
+
+var (
+	ErrImageNotFound   = errors.New("image not found")
+	ErrImageDeprecated = errors.New("image is deprecated")
+	ErrBelowMinimum    = errors.New("below the minimum of the image")
+)
+
+// An image in the catalog. The file, kind and checksum can't change once
+// registered, new content is registered as a new image and the old one
+// deprecated.
+type Image struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	Name        string     `json:"name" db:"name"`
+	Kind        string     `json:"kind" db:"kind"`
+	OSFamily    string     `json:"os_family" db:"os_family"`
+	Version     string     `json:"version" db:"version"`
+	OSVariant   string     `json:"os_variant" db:"os_variant"`     // as known to libosinfo
+	DefaultUser string     `json:"default_user" db:"default_user"` // cloud-init creates
+	MinDisk     int        `json:"min_disk" db:"min_disk"`         // GiB
+	MinMemory   int64      `json:"min_memory" db:"min_memory"`     // bytes
+	FileName    string     `json:"file_name" db:"file_name"`       // in the storage pools
+	Source      string     `json:"source" db:"source"`             // URL auto downloads it from
+	Checksum    string     `json:"checksum" db:"checksum"`         // sha256
+	Deprecated  *time.Time `json:"deprecated" db:"deprecated"`     // no new VMs are made from it since
+	Created     time.Time  `json:"created" db:"created"`
+	Updated     time.Time  `json:"updated" db:"updated"`
+	Remarks     string     `json:"remarks" db:"remarks"`
+}
+
+// Path of the image in the storage pool at dir
+func (img Image) Path(dir string) string {
+	return path.Join(dir, img.FileName)
+}
+
+// Fits checks a VM with memory MB and a root disk of disk GiB meets the
+// minimum of the image
+func (img Image) Fits(memory int, disk int) error {
+	if int64(memory)*1024*1024 < img.MinMemory {
+		return fmt.Errorf("%w: memory %d MB, needs %d MB", ErrBelowMinimum, memory, img.MinMemory/1024/1024)
+	}
+	if disk < img.MinDisk {
+		return fmt.Errorf("%w: root disk %d GiB, needs %d GiB", ErrBelowMinimum, disk, img.MinDisk)
+	}
+	return nil
+}
+
+// List the images, deprecated ones too if all is set
+func List(ctx context.Context, all bool) ([]Image, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM image WHERE deprecated IS NULL OR $1 ORDER BY os_family, name",
+		all,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading images: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Image])
+}
+
+func Get(ctx context.Context, id uuid.UUID) (Image, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM image WHERE id = $1", id)
+	if err != nil {
+		return Image{}, fmt.Errorf("Error reading image: %w", err)
+	}
+
+	img, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Image])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return img, ErrImageNotFound
+	}
+	return img, err
+}
+
+// Usable gets an image new VMs can be made from
+func Usable(ctx context.Context, id uuid.UUID) (Image, error) {
+	img, err := Get(ctx, id)
+	if err != nil {
+		return img, err
+	}
+
+	if img.Deprecated != nil {
+		return img, ErrImageDeprecated
+	}
+	return img, nil
+}
+
+func Create(ctx context.Context, img Image) (Image, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		`INSERT INTO image (id, name, kind, os_family, version, os_variant, default_user, min_disk, min_memory, file_name, source, checksum, remarks)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING *`,
+		uuid.New(),      // id
+		img.Name,        // name
+		img.Kind,        // kind
+		img.OSFamily,    // os_family
+		img.Version,     // version
+		img.OSVariant,   // os_variant
+		img.DefaultUser, // default_user
+		img.MinDisk,     // min_disk
+		img.MinMemory,   // min_memory
+		img.FileName,    // file_name
+		img.Source,      // source
+		img.Checksum,    // checksum
+		img.Remarks,     // remarks
+	)
+	if err != nil {
+		return Image{}, fmt.Errorf("Error inserting image: %w", err)
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[Image])
+}
+
+// Update writes what describes the image, not its file
+func Update(ctx context.Context, img Image) (Image, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		`UPDATE image SET name = $1, os_family = $2, version = $3, os_variant = $4, default_user = $5, min_disk = $6, min_memory = $7, source = $8, remarks = $9, updated = now()
+		WHERE id = $10 RETURNING *`,
+		img.Name,        // name
+		img.OSFamily,    // os_family
+		img.Version,     // version
+		img.OSVariant,   // os_variant
+		img.DefaultUser, // default_user
+		img.MinDisk,     // min_disk
+		img.MinMemory,   // min_memory
+		img.Source,      // source
+		img.Remarks,     // remarks
+		img.ID,          // id
+	)
+	if err != nil {
+		return Image{}, fmt.Errorf("Error updating image: %w", err)
+	}
+
+	img, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[Image])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return img, ErrImageNotFound
+	}
+	return img, err
+}
+
+// Deprecate an image, or take it back into use
+func Deprecate(ctx context.Context, id uuid.UUID, on bool) (Image, error) {
+	rows, err := db.Pool.Query(ctx,
+		"UPDATE image SET deprecated = CASE WHEN $2 THEN coalesce(deprecated, now()) END, updated = now() WHERE id = $1 RETURNING *",
+		id, on,
+	)
+	if err != nil {
+		return Image{}, fmt.Errorf("Error updating image: %w", err)
+	}
+
+	img, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Image])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return img, ErrImageNotFound
+	}
+	return img, err
+}
+
+// Delete an image from the catalog, the files stay in the pools and VMs made
+// from it no longer refer to it
+func Delete(ctx context.Context, id uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, "DELETE FROM image WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build !integration
+// +build !integration
+
+package image
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath(t *testing.T) {
+	img := Image{FileName: "debian-12.qcow2"}
+	assert.Equal(t, "/var/lib/images/debian-12.qcow2", img.Path("/var/lib/images"))
+	assert.Equal(t, "/var/lib/images/debian-12.qcow2", img.Path("/var/lib/images/"))
+}
+
+func TestFits(t *testing.T) {
+	img := Image{MinDisk: 10, MinMemory: 1024 * 1024 * 1024}
+
+	assert.NoError(t, img.Fits(1024, 10))
+	assert.NoError(t, img.Fits(2048, 20))
+	assert.True(t, errors.Is(img.Fits(512, 10), ErrBelowMinimum))
+	assert.True(t, errors.Is(img.Fits(1024, 5), ErrBelowMinimum))
+
+	assert.NoError(t, Image{}.Fits(1, 0), "no minimum")
+}
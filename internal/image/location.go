@@ -0,0 +1,70 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Where an image was synced to
+type Location struct {
+	Image   uuid.UUID `json:"-" db:"image_id"`
+	Storage uuid.UUID `json:"storage" db:"storage_id"`
+	HV      uuid.UUID `json:"hv" db:"hv_id"`
+	Path    string    `json:"path" db:"path"` // of the storage pool
+	State   string    `json:"state" db:"state"`
+	Error   string    `json:"error" db:"error"` // of the last failed sync
+	Updated time.Time `json:"updated" db:"updated"`
+}
+
+// Locations of an image, by hypervisor
+func Locations(ctx context.Context, id uuid.UUID) ([]Location, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT l.image_id, l.storage_id, s.hv_id, s.path, l.state, l.error, l.updated
+		FROM image_location l JOIN hv_storage s ON s.id = l.storage_id
+		WHERE l.image_id = $1 ORDER BY s.hv_id, s.path`,
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading image locations: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Location])
+}
+
+// SetLocation records the outcome of syncing an image to a storage pool
+func SetLocation(ctx context.Context, id uuid.UUID, storage uuid.UUID, syncErr error) error {
+	state, msg := LocationSynced, ""
+	if syncErr != nil {
+		state, msg = LocationFailed, syncErr.Error()
+	}
+
+	_, err := db.Pool.Exec(ctx,
+		`INSERT INTO image_location (image_id, storage_id, state, error) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (image_id, storage_id) DO UPDATE SET state = $3, error = $4, updated = now()`,
+		id, storage, state, msg,
+	)
+	return err
+}
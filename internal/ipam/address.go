@@ -0,0 +1,443 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	StateAllocated = "allocated" // in use by a VM, or assigned by hand
+	StateReserved  = "reserved"  // kept aside, never handed out automatically
+)
+
+// How often we retry when someone else took the address we picked
+const maxAttempts = 5
+
+var (
+	ErrAddressNotFound = errors.New("address not found")
+	ErrAddressInUse    = errors.New("address is already in use")
+	ErrAddressOutside  = errors.New("address is not usable in the prefix")
+	ErrVMNotFound      = errors.New("vm not found")
+	ErrNICNotFound     = errors.New("nic not found")
+)
+
+// Where an address goes in, the pool or a transaction
+type beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// The name of a NIC by its index, as VMs are created with
+func nicName(nic int) string {
+	return fmt.Sprintf("eth%d", nic)
+}
+
+// An address of a pool that is in use or reserved
+type Address struct {
+	ID      uuid.UUID  `json:"id" db:"id"`
+	PoolID  uuid.UUID  `json:"pool" db:"pool_id"`
+	Address netip.Addr `json:"address" db:"address"`
+	State   string     `json:"state" db:"state"`
+	VMID    *uuid.UUID `json:"vm" db:"vm_id"`
+	NIC     *int       `json:"nic" db:"nic"`
+	Created time.Time  `json:"created" db:"created"`
+	Remarks string     `json:"remarks" db:"remarks"`
+}
+
+// ListAddresses lists the addresses of a pool that are in use or reserved
+func ListAddresses(ctx context.Context, poolID uuid.UUID) ([]Address, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM ip_addresses WHERE pool_id = $1 ORDER BY address", poolID)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading ip addresses: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Address])
+}
+
+// VMAddresses lists the addresses allocated to a VM, ordered by NIC
+func VMAddresses(ctx context.Context, vmID uuid.UUID) ([]Address, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM ip_addresses WHERE vm_id = $1 ORDER BY nic, address", vmID)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading ip addresses: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Address])
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// Assign records an address of a pool as allocated or reserved. An invalid
+// (zero) address picks the next free one. An address allocated to a NIC of a
+// VM is added to the NIC along with it.
+func Assign(ctx context.Context, poolID uuid.UUID, addr netip.Addr, state string, vmID *uuid.UUID, nic *int, remarks string) (Address, error) {
+	pool, err := GetPool(ctx, poolID)
+	if err != nil {
+		return Address{}, err
+	}
+
+	if vmID == nil || nic == nil {
+		return assign(ctx, db.Pool, pool, addr, state, vmID, nic, remarks)
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return Address{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The VM is locked so it isn't deleted under the address
+	var nicID *uuid.UUID
+	if err := tx.QueryRow(ctx,
+		"SELECT vm.id, n.id FROM vm LEFT JOIN vm_nic n ON n.vm_id = vm.id AND n.name = $2 WHERE vm.id = $1 FOR UPDATE OF vm",
+		vmID, nicName(*nic),
+	).Scan(new(uuid.UUID), &nicID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Address{}, ErrVMNotFound
+		}
+		return Address{}, err
+	}
+	if nicID == nil {
+		return Address{}, ErrNICNotFound
+	}
+
+	address, err := assign(ctx, tx, pool, addr, state, vmID, nic, remarks)
+	if err != nil {
+		return Address{}, err
+	}
+
+	if state == StateAllocated {
+		if _, err := tx.Exec(ctx,
+			"UPDATE vm_nic SET ips = array_append(ips, $1), updated = now() WHERE vm_id = $2 AND name = $3",
+			address.Address, vmID, nicName(*nic),
+		); err != nil {
+			return Address{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Address{}, err
+	}
+
+	return address, nil
+}
+
+func assign(ctx context.Context, q beginner, pool Pool, addr netip.Addr, state string, vmID *uuid.UUID, nic *int, remarks string) (Address, error) {
+	pick := !addr.IsValid()
+
+	if !pick {
+		first, last := usable(pool.Prefix)
+		if addr.Compare(first) < 0 || addr.Compare(last) > 0 || addr == pool.gateway() {
+			return Address{}, ErrAddressOutside
+		}
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if pick {
+			used, reserved, err := poolUsage(ctx, pool.ID)
+			if err != nil {
+				return Address{}, err
+			}
+
+			if addr, err = nextFree(pool.Prefix, pool.gateway(), used, reserved); err != nil {
+				return Address{}, err
+			}
+		}
+
+		address, err := insertAddress(ctx, q, pool.ID, addr, state, vmID, nic, remarks)
+		if err == nil {
+			return address, nil
+		}
+
+		if !isUniqueViolation(err) {
+			return Address{}, err
+		}
+
+		// Someone was faster, pick again if it was up to us
+		if !pick {
+			return Address{}, ErrAddressInUse
+		}
+	}
+
+	return Address{}, ErrAddressInUse
+}
+
+// insertAddress records an address on its own, a transaction it is made in
+// survives the address being taken
+func insertAddress(ctx context.Context, q beginner, poolID uuid.UUID, addr netip.Addr, state string, vmID *uuid.UUID, nic *int, remarks string) (Address, error) {
+	tx, err := q.Begin(ctx)
+	if err != nil {
+		return Address{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(
+		ctx,
+		"INSERT INTO ip_addresses (id, pool_id, address, state, vm_id, nic, remarks) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *",
+		uuid.New(), // id
+		poolID,     // pool_id
+		addr,       // address
+		state,      // state
+		vmID,       // vm_id
+		nic,        // nic
+		remarks,    // remarks
+	)
+	if err != nil {
+		return Address{}, err
+	}
+
+	address, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Address])
+	if err != nil {
+		return Address{}, err
+	}
+
+	return address, tx.Commit(ctx)
+}
+
+// poolUsage returns the addresses of a pool in use and its reserved ranges
+func poolUsage(ctx context.Context, poolID uuid.UUID) (map[netip.Addr]bool, []Range, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT address FROM ip_addresses WHERE pool_id = $1", poolID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addrs, err := pgx.CollectRows(rows, pgx.RowTo[netip.Addr])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	used := make(map[netip.Addr]bool, len(addrs))
+	for _, a := range addrs {
+		used[a] = true
+	}
+
+	reservations, err := ListReservations(ctx, poolID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reserved := make([]Range, len(reservations))
+	for i := range reservations {
+		reserved[i] = reservations[i].Range()
+	}
+
+	return used, reserved, nil
+}
+
+// Release frees an address of a pool, and takes it off the NIC it was
+// allocated to
+func Release(ctx context.Context, poolID uuid.UUID, addr netip.Addr) (Address, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return Address{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "DELETE FROM ip_addresses WHERE pool_id = $1 AND address = $2 RETURNING *", poolID, addr)
+	if err != nil {
+		return Address{}, err
+	}
+
+	address, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Address])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Address{}, ErrAddressNotFound
+	}
+	if err != nil {
+		return Address{}, err
+	}
+
+	if address.State == StateAllocated && address.VMID != nil && address.NIC != nil {
+		if _, err := tx.Exec(ctx,
+			"UPDATE vm_nic SET ips = array_remove(ips, $1), updated = now() WHERE vm_id = $2 AND name = $3",
+			addr, address.VMID, nicName(*address.NIC),
+		); err != nil {
+			return Address{}, err
+		}
+	}
+
+	return address, tx.Commit(ctx)
+}
+
+// ReleaseVM frees every address allocated to a VM
+func ReleaseVM(ctx context.Context, vmID uuid.UUID) error {
+	_, err := db.Pool.Exec(ctx, "DELETE FROM ip_addresses WHERE vm_id = $1 AND state = $2", vmID, StateAllocated)
+	return err
+}
+
+// poolsFor returns the pools a NIC on a bridge of a hypervisor may get
+// addresses from, most specific first
+func poolsFor(ctx context.Context, hvID uuid.UUID, site string, bridge string) ([]Pool, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		`SELECT p.* FROM ip_pools p LEFT JOIN hv_network n ON n.id = p.network_id
+		WHERE (n.hv_id = $1 AND n.bridge = $2)
+		OR (p.network_id IS NULL AND (p.site = $3 OR p.site IS NULL))
+		ORDER BY p.network_id IS NULL, p.site IS NULL, p.name`,
+		hvID, bridge, site,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading ip pools: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Pool])
+}
+
+// AllocateForVM picks an IPv4 and an IPv6 address, where pools exist for
+// them, for every NIC of a new VM. The NICs are given as the bridges they are
+// on. Nothing is allocated if no pool covers a NIC.
+func AllocateForVM(ctx context.Context, hvID uuid.UUID, site string, vmID uuid.UUID, bridges []string) (addrs []Address, err error) {
+	// Don't leave half of the addresses behind
+	defer func() {
+		if err != nil {
+			ReleaseVM(ctx, vmID)
+		}
+	}()
+
+	for i, bridge := range bridges {
+		pools, err := poolsFor(ctx, hvID, site, bridge)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v4 := range []bool{true, false} {
+			var found, tried bool
+
+			for _, pool := range pools {
+				if pool.Prefix.Addr().Is4() != v4 {
+					continue
+				}
+				tried = true
+
+				nic := i
+				addr, err := assign(ctx, db.Pool, pool, netip.Addr{}, StateAllocated, &vmID, &nic, "")
+				if errors.Is(err, ErrPoolExhausted) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				addrs = append(addrs, addr)
+				found = true
+				break
+			}
+
+			if tried && !found {
+				return nil, fmt.Errorf("nic %d: %w", i, ErrPoolExhausted)
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
+// How much of a pool is used
+type Utilization struct {
+	Pool      uuid.UUID `json:"pool"`
+	Prefix    string    `json:"prefix"`
+	Size      uint64    `json:"size"`
+	Allocated uint64    `json:"allocated"`
+	Reserved  uint64    `json:"reserved"`
+	Free      uint64    `json:"free"`
+}
+
+// rangeSize returns the amount of addresses in a range, capped to what fits
+// in an uint64
+func rangeSize(r Range) uint64 {
+	n := new(big.Int).Sub(
+		new(big.Int).SetBytes(r.Last.AsSlice()),
+		new(big.Int).SetBytes(r.First.AsSlice()),
+	)
+	n.Add(n, big.NewInt(1))
+
+	if !n.IsUint64() {
+		return math.MaxUint64
+	}
+	return n.Uint64()
+}
+
+func add(a uint64, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+func sub(a uint64, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+// GetUtilization counts the allocated, reserved and free addresses of a pool
+func GetUtilization(ctx context.Context, poolID uuid.UUID) (Utilization, error) {
+	pool, err := GetPool(ctx, poolID)
+	if err != nil {
+		return Utilization{}, err
+	}
+
+	u := Utilization{
+		Pool:   pool.ID,
+		Prefix: pool.Prefix.String(),
+		Size:   size(pool.Prefix),
+	}
+
+	addrs, err := ListAddresses(ctx, poolID)
+	if err != nil {
+		return Utilization{}, err
+	}
+
+	_, reserved, err := poolUsage(ctx, poolID)
+	if err != nil {
+		return Utilization{}, err
+	}
+
+	for _, a := range addrs {
+		if a.State == StateAllocated {
+			u.Allocated++
+		} else {
+			u.Reserved++
+		}
+	}
+
+	for _, r := range reserved {
+		u.Reserved = add(u.Reserved, rangeSize(r))
+	}
+
+	u.Free = sub(sub(u.Size, u.Allocated), u.Reserved)
+	if pool.Gateway != nil {
+		u.Free = sub(u.Free, 1)
+	}
+
+	return u, nil
+}
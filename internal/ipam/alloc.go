@@ -0,0 +1,115 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ipam
+
+import (
+	"errors"
+	"math"
+	"net/netip"
+)
+
+var ErrPoolExhausted = errors.New("no free address left in pool")
+
+// Range is an inclusive range of addresses
+type Range struct {
+	First netip.Addr `json:"first"`
+	Last  netip.Addr `json:"last"`
+}
+
+func (r Range) Contains(addr netip.Addr) bool {
+	return r.First.Compare(addr) <= 0 && addr.Compare(r.Last) <= 0
+}
+
+// usable returns the first and last address of a prefix that may be handed
+// out, leaving out the network and broadcast addresses where they exist
+func usable(prefix netip.Prefix) (first netip.Addr, last netip.Addr) {
+	prefix = prefix.Masked()
+	first = prefix.Addr()
+	last = lastAddr(prefix)
+
+	// /31 and /32 (or /127 and /128) have no addresses to spare
+	if prefix.Bits() < first.BitLen()-1 {
+		first = first.Next()
+		if first.Is4() {
+			last = last.Prev()
+		}
+	}
+
+	return first, last
+}
+
+// lastAddr returns the highest address in a prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// size returns the amount of usable addresses of a prefix, capped to what
+// fits in an uint64 for large IPv6 prefixes
+func size(prefix netip.Prefix) uint64 {
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits >= 64 {
+		return math.MaxUint64
+	}
+
+	n := uint64(1) << hostBits
+	first, _ := usable(prefix)
+	if first != prefix.Masked().Addr() {
+		n--
+		if first.Is4() {
+			n--
+		}
+	}
+
+	return n
+}
+
+// nextFree returns the lowest usable address of a prefix that is not the
+// gateway, in use, or reserved
+func nextFree(prefix netip.Prefix, gateway netip.Addr, used map[netip.Addr]bool, reserved []Range) (netip.Addr, error) {
+	first, last := usable(prefix)
+
+	for addr := first; addr.IsValid() && addr.Compare(last) <= 0; addr = addr.Next() {
+		if addr == gateway || used[addr] {
+			continue
+		}
+
+		skip := false
+		for _, r := range reserved {
+			if r.Contains(addr) {
+				// Jump to the end of the range instead of walking it
+				addr = r.Last
+				skip = true
+				break
+			}
+		}
+		if skip {
+			continue
+		}
+
+		return addr, nil
+	}
+
+	return netip.Addr{}, ErrPoolExhausted
+}
@@ -0,0 +1,65 @@
+//go:build !integration
+// +build !integration
+
+package ipam
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextFree(t *testing.T) {
+	prefix := netip.MustParsePrefix("192.0.2.0/29")
+	gateway := netip.MustParseAddr("192.0.2.1")
+
+	addr, err := nextFree(prefix, gateway, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.2", addr.String())
+
+	used := map[netip.Addr]bool{
+		netip.MustParseAddr("192.0.2.2"): true,
+	}
+	reserved := []Range{{
+		First: netip.MustParseAddr("192.0.2.3"),
+		Last:  netip.MustParseAddr("192.0.2.5"),
+	}}
+
+	addr, err = nextFree(prefix, gateway, used, reserved)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.6", addr.String())
+
+	// .7 is the broadcast address
+	used[netip.MustParseAddr("192.0.2.6")] = true
+	_, err = nextFree(prefix, gateway, used, reserved)
+	assert.ErrorIs(t, err, ErrPoolExhausted)
+}
+
+func TestNextFreeIPv6(t *testing.T) {
+	prefix := netip.MustParsePrefix("2001:db8::/64")
+	gateway := netip.MustParseAddr("2001:db8::1")
+
+	addr, err := nextFree(prefix, gateway, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8::2", addr.String())
+}
+
+func TestNextFreeSmallPrefixes(t *testing.T) {
+	addr, err := nextFree(netip.MustParsePrefix("192.0.2.10/32"), netip.Addr{}, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.10", addr.String())
+
+	addr, err = nextFree(netip.MustParsePrefix("192.0.2.10/31"), netip.Addr{}, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.10", addr.String())
+}
+
+func TestSize(t *testing.T) {
+	assert.Equal(t, uint64(254), size(netip.MustParsePrefix("10.0.0.0/24")))
+	assert.Equal(t, uint64(2), size(netip.MustParsePrefix("10.0.0.0/31")))
+	assert.Equal(t, uint64(1), size(netip.MustParsePrefix("10.0.0.1/32")))
+	assert.Equal(t, uint64(255), size(netip.MustParsePrefix("2001:db8::/120")))
+	assert.Equal(t, uint64(math.MaxUint64), size(netip.MustParsePrefix("2001:db8::/64")))
+}
@@ -0,0 +1,189 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrPoolNotFound   = errors.New("ip pool not found")
+	ErrPoolInUse      = errors.New("ip pool still has allocated addresses")
+	ErrGatewayOutside = errors.New("gateway is not in the prefix")
+)
+
+// An IP pool is a prefix addresses are handed out from, scoped to a bridge,
+// to a site, or to everything if neither is set
+type Pool struct {
+	ID        uuid.UUID    `json:"id" db:"id"`
+	Name      string       `json:"name" db:"name"`
+	Prefix    netip.Prefix `json:"prefix" db:"prefix"`
+	Gateway   *netip.Addr  `json:"gateway" db:"gateway"`
+	DNS       []netip.Addr `json:"dns" db:"dns"`
+	Site      *string      `json:"site" db:"site"`
+	NetworkID *uuid.UUID   `json:"network" db:"network_id"`
+	Created   time.Time    `json:"created" db:"created"`
+	Updated   time.Time    `json:"updated" db:"updated"`
+	Remarks   string       `json:"remarks" db:"remarks"`
+}
+
+func (p Pool) gateway() netip.Addr {
+	if p.Gateway == nil {
+		return netip.Addr{}
+	}
+	return *p.Gateway
+}
+
+func (p Pool) check() error {
+	if p.Gateway != nil && !p.Prefix.Contains(*p.Gateway) {
+		return ErrGatewayOutside
+	}
+
+	for _, dns := range p.DNS {
+		if !dns.IsValid() {
+			return fmt.Errorf("invalid dns server")
+		}
+	}
+
+	return nil
+}
+
+// ListPools lists every IP pool
+func ListPools(ctx context.Context) ([]Pool, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM ip_pools ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("Error reading ip pools: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Pool])
+}
+
+// GetPool gets an IP pool by its ID
+func GetPool(ctx context.Context, id uuid.UUID) (Pool, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM ip_pools WHERE id = $1", id)
+	if err != nil {
+		return Pool{}, fmt.Errorf("Error reading ip pool: %w", err)
+	}
+
+	pool, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Pool])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Pool{}, ErrPoolNotFound
+	}
+
+	return pool, err
+}
+
+// CreatePool adds a new IP pool
+func CreatePool(ctx context.Context, p Pool) (Pool, error) {
+	p.Prefix = p.Prefix.Masked()
+	if p.DNS == nil {
+		p.DNS = []netip.Addr{}
+	}
+
+	if err := p.check(); err != nil {
+		return Pool{}, err
+	}
+
+	rows, err := db.Pool.Query(
+		ctx,
+		"INSERT INTO ip_pools (id, name, prefix, gateway, dns, site, network_id, remarks) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *",
+		uuid.New(),  // id
+		p.Name,      // name
+		p.Prefix,    // prefix
+		p.Gateway,   // gateway
+		p.DNS,       // dns
+		p.Site,      // site
+		p.NetworkID, // network_id
+		p.Remarks,   // remarks
+	)
+	if err != nil {
+		return Pool{}, err
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[Pool])
+}
+
+// UpdatePool writes the gateway, DNS servers and remarks of a pool, the
+// prefix and scope can't change once addresses are handed out
+func UpdatePool(ctx context.Context, p Pool) (Pool, error) {
+	if err := p.check(); err != nil {
+		return Pool{}, err
+	}
+
+	rows, err := db.Pool.Query(
+		ctx,
+		"UPDATE ip_pools SET gateway = $1, dns = $2, remarks = $3, updated = now() WHERE id = $4 RETURNING *",
+		p.Gateway, // gateway
+		p.DNS,     // dns
+		p.Remarks, // remarks
+		p.ID,      // id
+	)
+	if err != nil {
+		return Pool{}, err
+	}
+
+	pool, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Pool])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Pool{}, ErrPoolNotFound
+	}
+
+	return pool, err
+}
+
+// DeletePool deletes a pool along with its reservations, as long as no
+// address of it is allocated to a VM
+func DeletePool(ctx context.Context, id uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var allocated int
+	if err := tx.QueryRow(
+		ctx,
+		"SELECT count(*) FROM ip_addresses WHERE pool_id = $1 AND state = $2",
+		id, StateAllocated,
+	).Scan(&allocated); err != nil {
+		return err
+	}
+
+	if allocated != 0 {
+		return ErrPoolInUse
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM ip_pools WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrPoolNotFound
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,102 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrInvalidRange        = errors.New("range is not in the prefix or is reversed")
+)
+
+// A range of a pool that is never handed out automatically
+type Reservation struct {
+	ID      uuid.UUID  `json:"id" db:"id"`
+	PoolID  uuid.UUID  `json:"pool" db:"pool_id"`
+	First   netip.Addr `json:"first" db:"first"`
+	Last    netip.Addr `json:"last" db:"last"`
+	Created time.Time  `json:"created" db:"created"`
+	Remarks string     `json:"remarks" db:"remarks"`
+}
+
+func (r Reservation) Range() Range {
+	return Range{First: r.First, Last: r.Last}
+}
+
+// ListReservations lists the reserved ranges of a pool
+func ListReservations(ctx context.Context, poolID uuid.UUID) ([]Reservation, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM ip_reservations WHERE pool_id = $1 ORDER BY first", poolID)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading ip reservations: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Reservation])
+}
+
+// Reserve keeps a range of a pool from being handed out automatically,
+// addresses already allocated in it stay where they are
+func Reserve(ctx context.Context, poolID uuid.UUID, first netip.Addr, last netip.Addr, remarks string) (Reservation, error) {
+	pool, err := GetPool(ctx, poolID)
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	if !pool.Prefix.Contains(first) || !pool.Prefix.Contains(last) || last.Less(first) {
+		return Reservation{}, ErrInvalidRange
+	}
+
+	rows, err := db.Pool.Query(
+		ctx,
+		"INSERT INTO ip_reservations (id, pool_id, first, last, remarks) VALUES ($1, $2, $3, $4, $5) RETURNING *",
+		uuid.New(), // id
+		poolID,     // pool_id
+		first,      // first
+		last,       // last
+		remarks,    // remarks
+	)
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[Reservation])
+}
+
+// Unreserve deletes a reserved range of a pool
+func Unreserve(ctx context.Context, poolID uuid.UUID, id uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, "DELETE FROM ip_reservations WHERE id = $1 AND pool_id = $2", id, poolID)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrReservationNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dbAcquiredConns = prometheus.NewDesc(namespace+"_db_acquired_conns", "Connections currently in use.", nil, nil)
+	dbIdleConns     = prometheus.NewDesc(namespace+"_db_idle_conns", "Connections currently idle.", nil, nil)
+	dbTotalConns    = prometheus.NewDesc(namespace+"_db_total_conns", "Connections currently open.", nil, nil)
+	dbMaxConns      = prometheus.NewDesc(namespace+"_db_max_conns", "Maximum size of the pool.", nil, nil)
+	dbAcquires      = prometheus.NewDesc(namespace+"_db_acquires_total", "Connections acquired from the pool.", nil, nil)
+	dbEmptyAcquires = prometheus.NewDesc(namespace+"_db_empty_acquires_total", "Acquires that had to wait for a connection.", nil, nil)
+	dbAcquireWait   = prometheus.NewDesc(namespace+"_db_acquire_wait_seconds_total", "Time spent waiting for a connection.", nil, nil)
+)
+
+// dbCollector reads the pool statistics when scraped
+type dbCollector struct{}
+
+func (dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbAcquiredConns
+	ch <- dbIdleConns
+	ch <- dbTotalConns
+	ch <- dbMaxConns
+	ch <- dbAcquires
+	ch <- dbEmptyAcquires
+	ch <- dbAcquireWait
+}
+
+func (dbCollector) Collect(ch chan<- prometheus.Metric) {
+	if db.Pool == nil {
+		return
+	}
+
+	stat := db.Pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(dbAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(dbIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(dbTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(dbMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(dbAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(dbEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(dbAcquireWait, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,195 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/profile"
+	"github.com/google/uuid"
+)
+
+const (
+	issuer            = "eve"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrNotEnrolled    = errors.New("two-factor authentication enrollment not started")
+	ErrInvalidCode    = errors.New("invalid code")
+)
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Generate a set of recovery codes, formatted as xxxxx-xxxxx
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := recoveryEncoding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// Recovery codes are hashed before they are stored, ignoring formatting
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return fmt.Sprintf("%x", sum)
+}
+
+// Start enrollment by generating a new TOTP secret for the profile. It is not
+// used for logins until it is confirmed.
+func Enroll(ctx context.Context, p profile.Profile) (secret string, uri string, err error) {
+	if p.TOTPEnabled {
+		return "", "", ErrAlreadyEnabled
+	}
+
+	if secret, err = NewSecret(); err != nil {
+		return
+	}
+
+	if _, err = db.Pool.Exec(ctx, "UPDATE profile SET totp_secret = $2, updated = now() WHERE id = $1", p.ID, secret); err != nil {
+		return
+	}
+
+	return secret, ProvisioningURI(secret, p.Email, issuer), nil
+}
+
+// Confirm enrollment with a code from the authenticator, which enables
+// two-factor authentication and returns a fresh set of recovery codes
+func Confirm(ctx context.Context, p profile.Profile, code string, now time.Time) ([]string, error) {
+	if p.TOTPEnabled {
+		return nil, ErrAlreadyEnabled
+	}
+
+	if p.TOTPSecret == "" {
+		return nil, ErrNotEnrolled
+	}
+
+	step, ok := Match(p.TOTPSecret, code, now)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE owner = $1", p.ID); err != nil {
+		return nil, err
+	}
+
+	for _, c := range codes {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO recovery_codes (id, owner, code_hash) VALUES ($1, $2, $3)",
+			uuid.New(),
+			p.ID,
+			hashRecoveryCode(c),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	// The code confirming enrollment can't be used to log in
+	if _, err := tx.Exec(ctx, "UPDATE profile SET totp_enabled = TRUE, totp_last_step = $2, updated = now() WHERE id = $1", p.ID, step); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify a TOTP code or, failing that, a recovery code. Either can only be
+// used once, a TOTP code has to be from a later time step than the last one.
+func Verify(ctx context.Context, p profile.Profile, code string, now time.Time) (bool, error) {
+	if !p.TOTPEnabled {
+		return false, nil
+	}
+
+	if step, ok := Match(p.TOTPSecret, code, now); ok {
+		// Whoever raises it first got in with the code
+		tag, err := db.Pool.Exec(ctx,
+			"UPDATE profile SET totp_last_step = $2 WHERE id = $1 AND totp_enabled AND totp_last_step < $2",
+			p.ID,
+			step,
+		)
+		if err != nil {
+			return false, err
+		}
+
+		return tag.RowsAffected() == 1, nil
+	}
+
+	tag, err := db.Pool.Exec(ctx,
+		"UPDATE recovery_codes SET used = now() WHERE owner = $1 AND code_hash = $2 AND used IS NULL",
+		p.ID,
+		hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// Turn off two-factor authentication for a profile and drop its secret and
+// recovery codes
+func Disable(ctx context.Context, profileID uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE owner = $1", profileID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE profile SET totp_secret = '', totp_enabled = FALSE, totp_last_step = 0, updated = now() WHERE id = $1", profileID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,98 @@
+//go:build !integration
+// +build !integration
+
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Secret from the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range vectors {
+		code, err := Code(rfcSecret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code, unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	assert.True(t, Validate(rfcSecret, "005924", now))
+	// One step of clock drift either way is fine
+	assert.True(t, Validate(rfcSecret, "005924", now.Add(period*time.Second)))
+	assert.True(t, Validate(rfcSecret, "005924", now.Add(-period*time.Second)))
+	// More isn't
+	assert.False(t, Validate(rfcSecret, "005924", now.Add(3*period*time.Second)))
+
+	assert.False(t, Validate(rfcSecret, "000000", now))
+	assert.False(t, Validate(rfcSecret, "5924", now))
+	assert.False(t, Validate("not a secret!", "005924", now))
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / period
+
+	got, ok := Match(rfcSecret, "005924", now)
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	// Drift is accepted, but the step is still the code's own, so the code
+	// can't be used again a step later
+	got, ok = Match(rfcSecret, "005924", now.Add(period*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	_, ok = Match(rfcSecret, "000000", now)
+	assert.False(t, ok)
+}
+
+func TestNewSecret(t *testing.T) {
+	s1, err := NewSecret()
+	assert.NoError(t, err)
+	s2, err := NewSecret()
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, s1, s2)
+	assert.Len(t, s1, 32)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("JBSWY3DPEHPK3PXP", "user@example.com", "eve")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/eve:user@example.com?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=eve")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := newRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		// Formatting shouldn't matter when checking a code
+		assert.Equal(t, hashRecoveryCode(code), hashRecoveryCode(" "+strings.ToUpper(code)))
+		assert.Equal(t, hashRecoveryCode(code), hashRecoveryCode(strings.ReplaceAll(code, "-", "")))
+	}
+	assert.NotEqual(t, codes[0], codes[1])
+}
@@ -0,0 +1,181 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package placement
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoCandidate     = errors.New("no hypervisor can take the virtual machine")
+	ErrUnknownStrategy = errors.New("unknown placement strategy")
+)
+
+// A hypervisor that could take the VM, with what it has and what its VMs
+// already use. Only these figures are looked at, auto is never asked.
+type Candidate struct {
+	ID         uuid.UUID
+	Hostname   string
+	Site       string
+	Tags       []string
+	CPUs       int    // host threads
+	Memory     uint64 // bytes
+	UsedCPUs   int    // vCPUs of the VMs on it
+	UsedMemory uint64 // bytes given to the VMs on it
+	DiskFree   int64  // bytes left in its disk pools
+	Reject     string // why it can't take the VM regardless of its resources, if set
+}
+
+// What the VM needs
+type Request struct {
+	CPUs   int
+	Memory uint64   // bytes
+	Disk   int64    // bytes
+	Site   string   // any site if empty
+	Tags   []string // the hypervisor needs all of them
+}
+
+// How far the resources of a host may be handed out, 2 means a host with 8
+// threads takes VMs with 16 vCPUs between them
+type Overcommit struct {
+	CPU    float64
+	Memory float64
+}
+
+// A Strategy ranks the hypervisors that fit, the highest score wins
+type Strategy interface {
+	Score(c Candidate, req Request, oc Overcommit) float64
+}
+
+var strategies = map[string]Strategy{
+	"spread": Spread{},
+	"pack":   Pack{},
+}
+
+func StrategyByName(name string) (Strategy, error) {
+	s, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, name)
+	}
+	return s, nil
+}
+
+// Share of the overcommitted CPU and memory of a candidate left once the VM
+// is on it, 0 is full
+func free(c Candidate, req Request, oc Overcommit) float64 {
+	cpus := float64(c.CPUs) * oc.CPU
+	memory := float64(c.Memory) * oc.Memory
+	if cpus <= 0 || memory <= 0 {
+		return 0
+	}
+
+	cpu := 1 - float64(c.UsedCPUs+req.CPUs)/cpus
+	mem := 1 - float64(c.UsedMemory+req.Memory)/memory
+	return (cpu + mem) / 2
+}
+
+// Spread puts VMs on the emptiest hypervisor
+type Spread struct{}
+
+func (Spread) Score(c Candidate, req Request, oc Overcommit) float64 {
+	return free(c, req, oc)
+}
+
+// Pack fills hypervisors up one at a time, keeping the others free for large
+// VMs
+type Pack struct{}
+
+func (Pack) Score(c Candidate, req Request, oc Overcommit) float64 {
+	return -free(c, req, oc)
+}
+
+// Fits reports why a candidate can't take the VM, or "" if it can
+func Fits(c Candidate, req Request, oc Overcommit) string {
+	if c.Reject != "" {
+		return c.Reject
+	}
+	if req.Site != "" && c.Site != req.Site {
+		return fmt.Sprintf("not in site %s", req.Site)
+	}
+	for _, tag := range req.Tags {
+		if !hasTag(c.Tags, tag) {
+			return fmt.Sprintf("no tag %s", tag)
+		}
+	}
+	if float64(c.UsedCPUs+req.CPUs) > float64(c.CPUs)*oc.CPU {
+		return "not enough CPU"
+	}
+	if float64(c.UsedMemory+req.Memory) > float64(c.Memory)*oc.Memory {
+		return "not enough memory"
+	}
+	if req.Disk > c.DiskFree {
+		return "not enough disk space"
+	}
+	return ""
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Pick the candidate the strategy likes best among those that fit. Ties go to
+// the hostname that sorts first so the choice is stable.
+func Pick(candidates []Candidate, req Request, oc Overcommit, s Strategy) (Candidate, error) {
+	fit := make([]Candidate, 0, len(candidates))
+	reasons := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if reason := Fits(c, req, oc); reason != "" {
+			reasons = append(reasons, c.Hostname+": "+reason)
+			continue
+		}
+		fit = append(fit, c)
+	}
+
+	if len(fit) == 0 {
+		sort.Strings(reasons)
+		if len(reasons) == 0 {
+			return Candidate{}, ErrNoCandidate
+		}
+		return Candidate{}, fmt.Errorf("%w (%s)", ErrNoCandidate, strings.Join(reasons, "; "))
+	}
+
+	scores := make(map[uuid.UUID]float64, len(fit))
+	for _, c := range fit {
+		scores[c.ID] = s.Score(c, req, oc)
+	}
+
+	sort.SliceStable(fit, func(i, j int) bool {
+		if scores[fit[i].ID] != scores[fit[j].ID] {
+			return scores[fit[i].ID] > scores[fit[j].ID]
+		}
+		return fit[i].Hostname < fit[j].Hostname
+	})
+
+	return fit[0], nil
+}
@@ -0,0 +1,111 @@
+//go:build !integration
+// +build !integration
+
+package placement
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const gib = 1 << 30
+
+var oc = Overcommit{CPU: 2, Memory: 1}
+
+func candidate(hostname string, usedCPUs int, usedMemory uint64) Candidate {
+	return Candidate{
+		ID:         uuid.New(),
+		Hostname:   hostname,
+		Site:       "ams",
+		Tags:       []string{"ssd"},
+		CPUs:       8,
+		Memory:     64 * gib,
+		UsedCPUs:   usedCPUs,
+		UsedMemory: usedMemory,
+		DiskFree:   500 * gib,
+	}
+}
+
+func TestFits(t *testing.T) {
+	req := Request{CPUs: 4, Memory: 8 * gib, Disk: 20 * gib}
+	c := candidate("hv1", 0, 0)
+
+	assert.Equal(t, "", Fits(c, req, oc))
+
+	// 12 + 4 vCPUs is exactly 8 threads at 2x
+	c.UsedCPUs = 12
+	assert.Equal(t, "", Fits(c, req, oc))
+	c.UsedCPUs = 13
+	assert.Equal(t, "not enough CPU", Fits(c, req, oc))
+
+	c = candidate("hv1", 0, 60*gib)
+	assert.Equal(t, "not enough memory", Fits(c, req, oc))
+
+	c = candidate("hv1", 0, 0)
+	c.DiskFree = 10 * gib
+	assert.Equal(t, "not enough disk space", Fits(c, req, oc))
+
+	c = candidate("hv1", 0, 0)
+	assert.Equal(t, "not in site fra", Fits(c, Request{Site: "fra"}, oc))
+	assert.Equal(t, "no tag gpu", Fits(c, Request{Tags: []string{"ssd", "gpu"}}, oc))
+
+	c.Reject = "in maintenance"
+	assert.Equal(t, "in maintenance", Fits(c, req, oc))
+}
+
+func TestPickSpread(t *testing.T) {
+	req := Request{CPUs: 2, Memory: 4 * gib}
+	busy := candidate("hv1", 8, 32*gib)
+	idle := candidate("hv2", 2, 4*gib)
+
+	c, err := Pick([]Candidate{busy, idle}, req, oc, Spread{})
+	assert.NoError(t, err)
+	assert.Equal(t, "hv2", c.Hostname)
+}
+
+func TestPickPack(t *testing.T) {
+	req := Request{CPUs: 2, Memory: 4 * gib}
+	busy := candidate("hv1", 8, 32*gib)
+	idle := candidate("hv2", 2, 4*gib)
+
+	c, err := Pick([]Candidate{idle, busy}, req, oc, Pack{})
+	assert.NoError(t, err)
+	assert.Equal(t, "hv1", c.Hostname)
+
+	// Full hypervisors are passed over even when packing
+	full := candidate("hv0", 16, 32*gib)
+	c, err = Pick([]Candidate{full, idle, busy}, req, oc, Pack{})
+	assert.NoError(t, err)
+	assert.Equal(t, "hv1", c.Hostname)
+}
+
+func TestPickTie(t *testing.T) {
+	req := Request{CPUs: 1, Memory: gib}
+
+	c, err := Pick([]Candidate{candidate("hv2", 0, 0), candidate("hv1", 0, 0)}, req, oc, Spread{})
+	assert.NoError(t, err)
+	assert.Equal(t, "hv1", c.Hostname)
+}
+
+func TestPickNone(t *testing.T) {
+	req := Request{CPUs: 1, Memory: gib, Site: "fra"}
+
+	_, err := Pick([]Candidate{candidate("hv1", 0, 0)}, req, oc, Spread{})
+	assert.True(t, errors.Is(err, ErrNoCandidate))
+	assert.Contains(t, err.Error(), "hv1: not in site fra")
+
+	_, err = Pick(nil, req, oc, Spread{})
+	assert.True(t, errors.Is(err, ErrNoCandidate))
+}
+
+func TestStrategyByName(t *testing.T) {
+	s, err := StrategyByName("pack")
+	assert.NoError(t, err)
+	assert.Equal(t, Pack{}, s)
+
+	_, err = StrategyByName("random")
+	assert.True(t, errors.Is(err, ErrUnknownStrategy))
+}
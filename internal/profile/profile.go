@@ -43,6 +43,10 @@ type Profile struct {
 	Created   time.Time   `json:"created" db:"created"`
 	Updated   time.Time   `json:"updated" db:"updated"`
 	Remarks   string      `db:"remarks"`
+
+	TOTPSecret   string `json:"-" db:"totp_secret"`
+	TOTPEnabled  bool   `json:"totp_enabled" db:"totp_enabled"`
+	TOTPLastStep int64  `json:"-" db:"totp_last_step"`
 }
 
 func (p *Profile) New(ctx context.Context) (id string, err error) {
@@ -90,5 +94,28 @@ func (p *Profile) Get(ctx context.Context) (profile Profile, err error) {
 	return profile, nil
 }
 
-func (p *Profile) Update() {}
+// Update writes the editable fields of a profile back to the database
+func (p *Profile) Update(ctx context.Context) error {
+	tag, err := db.Pool.Exec(
+		ctx,
+		"UPDATE profile SET name = $1, email = $2, password = $3, disabled = $4, is_admin = $5, remarks = $6, updated = now() WHERE id = $7",
+		p.Name,     // name
+		p.Email,    // email
+		p.Password, // password
+		p.Disabled, // disabled
+		p.IsAdmin,  // is_admin
+		p.Remarks,  // remarks
+		p.ID,       // id
+	)
+	if err != nil {
+		return fmt.Errorf("%w %v", QueryErr, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
 func (p *Profile) Delete() {}
@@ -0,0 +1,202 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Member roles, each one can do what the ones below it can
+const (
+	RoleOwner    = "owner"    // also manages members, transfers and deletes
+	RoleOperator = "operator" // changes and powers the VMs
+	RoleViewer   = "viewer"   // reads the VMs
+)
+
+var (
+	ErrNotMember   = errors.New("not a member of the project")
+	ErrLastOwner   = errors.New("a project needs at least one owner")
+	ErrUnknownRole = errors.New("unknown project role")
+)
+
+var rank = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleOwner:    3,
+}
+
+// Allows reports whether a member with role may do what wanted needs
+func Allows(role string, wanted string) bool {
+	return rank[role] > 0 && rank[role] >= rank[wanted]
+}
+
+type Member struct {
+	Project uuid.UUID `json:"-" db:"project_id"`
+	Profile uuid.UUID `json:"profile" db:"profile_id"`
+	Role    string    `json:"role" db:"role"`
+	Created time.Time `json:"created" db:"created"`
+}
+
+func Members(ctx context.Context, id uuid.UUID) ([]Member, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT * FROM project_member WHERE project_id = $1 ORDER BY created", id)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading project_member: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Member])
+}
+
+// RoleOf gets the role of a profile in a project
+func RoleOf(ctx context.Context, id uuid.UUID, profile uuid.UUID) (string, error) {
+	var role string
+	err := db.Pool.QueryRow(ctx,
+		"SELECT role FROM project_member WHERE project_id = $1 AND profile_id = $2", id, profile,
+	).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotMember
+	}
+	return role, err
+}
+
+// Projects a profile is a member of, by ID with its role
+func RolesOf(ctx context.Context, profile uuid.UUID) (map[uuid.UUID]string, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT project_id, role FROM project_member WHERE profile_id = $1", profile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading project_member: %w", err)
+	}
+	defer rows.Close()
+
+	roles := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var (
+			id   uuid.UUID
+			role string
+		)
+		if err := rows.Scan(&id, &role); err != nil {
+			return nil, err
+		}
+		roles[id] = role
+	}
+	return roles, rows.Err()
+}
+
+// Owner gets the oldest owner of a project, the one its VMs are accounted to
+func Owner(ctx context.Context, tx pgx.Tx, id uuid.UUID) (uuid.UUID, error) {
+	var owner uuid.UUID
+	err := tx.QueryRow(ctx,
+		"SELECT profile_id FROM project_member WHERE project_id = $1 AND role = $2 ORDER BY created, profile_id LIMIT 1",
+		id, RoleOwner,
+	).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return owner, ErrProjectNotFound
+	}
+	return owner, err
+}
+
+// The project row is locked so two member changes can't both take away the
+// last owner
+func lockOwners(ctx context.Context, tx pgx.Tx, id uuid.UUID, profile uuid.UUID) (others int, err error) {
+	var found uuid.UUID
+	if err := tx.QueryRow(ctx, "SELECT id FROM project WHERE id = $1 FOR UPDATE", id).Scan(&found); errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrProjectNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	err = tx.QueryRow(ctx,
+		"SELECT count(*) FROM project_member WHERE project_id = $1 AND role = $2 AND profile_id <> $3",
+		id, RoleOwner, profile,
+	).Scan(&others)
+	return others, err
+}
+
+// Add a profile to a project or change its role
+func SetMember(ctx context.Context, id uuid.UUID, profile uuid.UUID, role string) (Member, error) {
+	if rank[role] == 0 {
+		return Member{}, fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return Member{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	others, err := lockOwners(ctx, tx, id, profile)
+	if err != nil {
+		return Member{}, err
+	}
+	if role != RoleOwner && others == 0 {
+		return Member{}, ErrLastOwner
+	}
+
+	rows, err := tx.Query(ctx,
+		`INSERT INTO project_member (project_id, profile_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (project_id, profile_id) DO UPDATE SET role = $3
+		RETURNING *`,
+		id, profile, role,
+	)
+	if err != nil {
+		return Member{}, fmt.Errorf("Error updating project_member: %w", err)
+	}
+
+	m, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Member])
+	if err != nil {
+		return m, err
+	}
+
+	return m, tx.Commit(ctx)
+}
+
+// Remove a profile from a project, the last owner can't leave
+func RemoveMember(ctx context.Context, id uuid.UUID, profile uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	others, err := lockOwners(ctx, tx, id, profile)
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastOwner
+	}
+
+	tag, err := tx.Exec(ctx,
+		"DELETE FROM project_member WHERE project_id = $1 AND profile_id = $2", id, profile)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotMember
+	}
+
+	return tx.Commit(ctx)
+}
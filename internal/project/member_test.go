@@ -0,0 +1,22 @@
+//go:build !integration
+// +build !integration
+
+package project
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllows(t *testing.T) {
+	assert.True(t, Allows(RoleOwner, RoleOwner))
+	assert.True(t, Allows(RoleOwner, RoleViewer))
+	assert.True(t, Allows(RoleOperator, RoleOperator))
+	assert.True(t, Allows(RoleViewer, RoleViewer))
+
+	assert.False(t, Allows(RoleViewer, RoleOperator))
+	assert.False(t, Allows(RoleOperator, RoleOwner))
+	assert.False(t, Allows("", RoleViewer))
+	assert.False(t, Allows("admin", RoleViewer))
+}
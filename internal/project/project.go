@@ -0,0 +1,184 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Name of the project VMs of a profile go to when none is given
+const DefaultName = "default"
+
+var (
+	ErrProjectNotFound = errors.New("project not found")
+	ErrProjectHasVMs   = errors.New("project still has virtual machines")
+)
+
+type Project struct {
+	ID      uuid.UUID `json:"id" db:"id"`
+	Name    string    `json:"name" db:"name"`
+	Created time.Time `json:"created" db:"created"`
+	Updated time.Time `json:"updated" db:"updated"`
+	Remarks string    `json:"remarks" db:"remarks"`
+	Role    string    `json:"role,omitempty" db:"role"` // of the profile the project was listed for
+}
+
+// List every project
+func List(ctx context.Context) ([]Project, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT *, '' AS role FROM project ORDER BY name, created")
+	if err != nil {
+		return nil, fmt.Errorf("Error reading projects: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Project])
+}
+
+// ListFor lists the projects a profile is a member of, with its role in them
+func ListFor(ctx context.Context, profile uuid.UUID) ([]Project, error) {
+	rows, err := db.Pool.Query(ctx,
+		"SELECT p.*, m.role FROM project p JOIN project_member m ON m.project_id = p.id WHERE m.profile_id = $1 ORDER BY p.name, p.created",
+		profile,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading projects: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Project])
+}
+
+func Get(ctx context.Context, id uuid.UUID) (Project, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT *, '' AS role FROM project WHERE id = $1", id)
+	if err != nil {
+		return Project{}, fmt.Errorf("Error reading project: %w", err)
+	}
+
+	p, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Project])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, ErrProjectNotFound
+	}
+	return p, err
+}
+
+func create(ctx context.Context, tx pgx.Tx, name string, remarks string, owner uuid.UUID) (Project, error) {
+	rows, err := tx.Query(ctx,
+		"INSERT INTO project (id, name, remarks) VALUES ($1, $2, $3) RETURNING *, $4::text AS role",
+		uuid.New(), name, remarks, RoleOwner,
+	)
+	if err != nil {
+		return Project{}, fmt.Errorf("Error inserting project: %w", err)
+	}
+
+	p, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Project])
+	if err != nil {
+		return p, fmt.Errorf("Error collecting project: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO project_member (project_id, profile_id, role) VALUES ($1, $2, $3)",
+		p.ID, owner, RoleOwner,
+	); err != nil {
+		return p, fmt.Errorf("Error inserting project_member: %w", err)
+	}
+
+	return p, nil
+}
+
+// Create a project, owned by the given profile
+func Create(ctx context.Context, name string, remarks string, owner uuid.UUID) (Project, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return Project{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	p, err := create(ctx, tx, name, remarks, owner)
+	if err != nil {
+		return p, err
+	}
+
+	return p, tx.Commit(ctx)
+}
+
+// Default gets the oldest project the profile owns, creating one if it owns
+// none
+func Default(ctx context.Context, tx pgx.Tx, profile uuid.UUID) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := tx.QueryRow(ctx,
+		"SELECT p.id FROM project p JOIN project_member m ON m.project_id = p.id WHERE m.profile_id = $1 AND m.role = $2 ORDER BY p.created LIMIT 1",
+		profile, RoleOwner,
+	).Scan(&id)
+	if err == nil {
+		return id, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return id, err
+	}
+
+	p, err := create(ctx, tx, DefaultName, "", profile)
+	return p.ID, err
+}
+
+func Update(ctx context.Context, id uuid.UUID, name *string, remarks *string) (Project, error) {
+	rows, err := db.Pool.Query(ctx,
+		"UPDATE project SET name = coalesce($2, name), remarks = coalesce($3, remarks), updated = now() WHERE id = $1 RETURNING *, '' AS role",
+		id, name, remarks,
+	)
+	if err != nil {
+		return Project{}, fmt.Errorf("Error updating project: %w", err)
+	}
+
+	p, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Project])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, ErrProjectNotFound
+	}
+	return p, err
+}
+
+// Delete a project that has no VMs left
+func Delete(ctx context.Context, id uuid.UUID) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var vms int
+	if err := tx.QueryRow(ctx, "SELECT count(*) FROM vm WHERE project_id = $1", id).Scan(&vms); err != nil {
+		return err
+	}
+	if vms > 0 {
+		return ErrProjectHasVMs
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM project WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrProjectNotFound
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,126 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rbac
+
+import "strings"
+
+// Permissions are dot separated, a trailing "*" grants everything below it
+const (
+	All = "*"
+
+	HVRead  = "hv.read"
+	HVWrite = "hv.write"
+
+	VMRead    = "vm.read"
+	VMWrite   = "vm.write"
+	VMState   = "vm.state"
+	VMConsole = "vm.console"
+
+	TaskRead = "task.read"
+
+	UserRead  = "user.read"
+	UserWrite = "user.write"
+
+	RoleRead  = "role.read"
+	RoleWrite = "role.write"
+
+	BillingRead  = "billing.read"
+	BillingWrite = "billing.write"
+
+	IPAMRead  = "ipam.read"
+	IPAMWrite = "ipam.write"
+
+	ProjectRead  = "project.read"
+	ProjectWrite = "project.write"
+
+	FlavorRead  = "flavor.read"
+	FlavorWrite = "flavor.write"
+
+	ImageRead  = "image.read"
+	ImageWrite = "image.write"
+
+	// Permissions on resources owned by the requester
+	SelfVMRead    = "self.vm.read"
+	SelfVMWrite   = "self.vm.write"
+	SelfVMState   = "self.vm.state"
+	SelfVMConsole = "self.vm.console"
+	SelfTaskRead  = "self.task.read"
+
+	// Projects the requester is a member of, the member role decides the rest
+	SelfProjectRead  = "self.project.read"
+	SelfProjectWrite = "self.project.write"
+)
+
+// Known lists every permission a role may be given
+var Known = []string{
+	HVRead, HVWrite,
+	VMRead, VMWrite, VMState, VMConsole,
+	TaskRead,
+	UserRead, UserWrite,
+	RoleRead, RoleWrite,
+	BillingRead, BillingWrite,
+	IPAMRead, IPAMWrite,
+	ProjectRead, ProjectWrite,
+	FlavorRead, FlavorWrite,
+	ImageRead, ImageWrite,
+	SelfVMRead, SelfVMWrite, SelfVMState, SelfVMConsole, SelfTaskRead,
+	SelfProjectRead, SelfProjectWrite,
+}
+
+// Valid reports whether a permission, or wildcard, matches anything we know of
+func Valid(perm string) bool {
+	if perm == All {
+		return true
+	}
+
+	for _, known := range Known {
+		if matches(perm, known) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches reports whether the granted permission covers the wanted one
+func matches(granted string, wanted string) bool {
+	if granted == All || granted == wanted {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(granted, "*"); ok && strings.HasSuffix(prefix, ".") {
+		return strings.HasPrefix(wanted, prefix)
+	}
+
+	return false
+}
+
+// Set is the effective permissions of a profile
+type Set []string
+
+// Has reports whether the set grants a permission
+func (s Set) Has(perm string) bool {
+	for _, granted := range s {
+		if matches(granted, perm) {
+			return true
+		}
+	}
+
+	return false
+}
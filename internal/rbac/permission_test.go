@@ -0,0 +1,31 @@
+//go:build !integration
+// +build !integration
+
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetHas(t *testing.T) {
+	assert.True(t, Set{All}.Has(VMWrite))
+	assert.True(t, Set{VMRead}.Has(VMRead))
+	assert.False(t, Set{VMRead}.Has(VMWrite))
+	assert.True(t, Set{"self.*"}.Has(SelfVMConsole))
+	assert.False(t, Set{"self.*"}.Has(VMConsole))
+	assert.True(t, Set{"billing.*"}.Has(BillingWrite))
+	assert.False(t, Set{"vm*"}.Has(VMRead))
+	assert.False(t, Set{}.Has(VMRead))
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(All))
+	assert.True(t, Valid(HVRead))
+	assert.True(t, Valid("vm.*"))
+	assert.True(t, Valid("self.vm.*"))
+	assert.False(t, Valid("vm.reed"))
+	assert.False(t, Valid("nothing.*"))
+	assert.False(t, Valid(""))
+}
@@ -0,0 +1,268 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Built-in roles, every profile has RoleUser and profiles with is_admin set
+// have RoleAdmin without them being assigned
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleExists        = errors.New("role already exists")
+	ErrBuiltinRole       = errors.New("built-in roles can't be deleted")
+	ErrImmutableRole     = errors.New("the admin role can't be changed")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+type Role struct {
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Builtin     bool      `json:"builtin" db:"builtin"`
+	Created     time.Time `json:"created" db:"created"`
+	Updated     time.Time `json:"updated" db:"updated"`
+	Permissions []string  `json:"permissions" db:"permissions"`
+}
+
+const roleQuery = `SELECT r.*, COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}') AS permissions
+	FROM roles r LEFT JOIN role_permissions rp ON rp.role = r.name`
+
+func checkPermissions(perms []string) error {
+	for _, perm := range perms {
+		if !Valid(perm) {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, perm)
+		}
+	}
+
+	return nil
+}
+
+// Permissions returns the effective permissions of a profile
+func Permissions(ctx context.Context, profile uuid.UUID, isAdmin bool) (Set, error) {
+	rows, err := db.Pool.Query(
+		ctx,
+		`SELECT DISTINCT permission FROM role_permissions
+		WHERE role = $2
+		OR (role = $3 AND $4)
+		OR role IN (SELECT role FROM profile_roles WHERE profile = $1)`,
+		profile, RoleUser, RoleAdmin, isAdmin,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading permissions: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// ListRoles lists every role with its permissions
+func ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := db.Pool.Query(ctx, roleQuery+" GROUP BY r.name ORDER BY r.name")
+	if err != nil {
+		return nil, fmt.Errorf("Error reading roles: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Role])
+}
+
+// GetRole gets a role by name
+func GetRole(ctx context.Context, name string) (Role, error) {
+	rows, err := db.Pool.Query(ctx, roleQuery+" WHERE r.name = $1 GROUP BY r.name", name)
+	if err != nil {
+		return Role{}, fmt.Errorf("Error reading role: %w", err)
+	}
+
+	role, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Role])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Role{}, ErrRoleNotFound
+	}
+
+	return role, err
+}
+
+func setPermissions(ctx context.Context, tx pgx.Tx, role string, perms []string) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM role_permissions WHERE role = $1", role); err != nil {
+		return err
+	}
+
+	for _, perm := range perms {
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO role_permissions (role, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			role, perm,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateRole creates a custom role
+func CreateRole(ctx context.Context, name string, description string, perms []string) (Role, error) {
+	if err := checkPermissions(perms); err != nil {
+		return Role{}, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return Role{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(
+		ctx,
+		"INSERT INTO roles (name, description) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		name, description,
+	)
+	if err != nil {
+		return Role{}, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return Role{}, ErrRoleExists
+	}
+
+	if err := setPermissions(ctx, tx, name, perms); err != nil {
+		return Role{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Role{}, err
+	}
+
+	return GetRole(ctx, name)
+}
+
+// UpdateRole changes the description and/or permissions of a role, nil leaves
+// the field as it is
+func UpdateRole(ctx context.Context, name string, description *string, perms []string) (Role, error) {
+	// Locking everyone out of administration is not something we let happen
+	if name == RoleAdmin {
+		return Role{}, ErrImmutableRole
+	}
+
+	if err := checkPermissions(perms); err != nil {
+		return Role{}, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return Role{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(
+		ctx,
+		"UPDATE roles SET description = COALESCE($1, description), updated = now() WHERE name = $2",
+		description, name,
+	)
+	if err != nil {
+		return Role{}, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return Role{}, ErrRoleNotFound
+	}
+
+	if perms != nil {
+		if err := setPermissions(ctx, tx, name, perms); err != nil {
+			return Role{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Role{}, err
+	}
+
+	return GetRole(ctx, name)
+}
+
+// DeleteRole deletes a custom role, profiles that had it lose it
+func DeleteRole(ctx context.Context, name string) error {
+	role, err := GetRole(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if role.Builtin {
+		return ErrBuiltinRole
+	}
+
+	_, err = db.Pool.Exec(ctx, "DELETE FROM roles WHERE name = $1", name)
+
+	return err
+}
+
+// ProfileRoles lists the roles assigned to a profile, not including the
+// implicit ones
+func ProfileRoles(ctx context.Context, profile uuid.UUID) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT role FROM profile_roles WHERE profile = $1 ORDER BY role", profile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading profile roles: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// SetProfileRoles replaces the roles assigned to a profile
+func SetProfileRoles(ctx context.Context, profile uuid.UUID, roles []string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM profile_roles WHERE profile = $1", profile); err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		var exists bool
+		if err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrRoleNotFound, role)
+		}
+
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO profile_roles (profile, role) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			profile, role,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
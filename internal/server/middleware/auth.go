@@ -19,15 +19,14 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strings"
 
-	"github.com/BasedDevelopment/eve/internal/profile"
 	"github.com/BasedDevelopment/eve/internal/sessions"
 	"github.com/BasedDevelopment/eve/internal/tokens"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
-	"github.com/google/uuid"
 )
 
 var (
@@ -70,7 +69,9 @@ func getTokenFromQuery(w http.ResponseWriter, r *http.Request) (tokens.Token, er
 	return token, nil
 }
 
-// Auth forces a user to be authenticated before continuing to the route
+// Auth forces a user to be authenticated, with either a session token or an
+// API key, before continuing to the route. The owner of the token is appended
+// to the request context.
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -98,35 +99,14 @@ func Auth(next http.Handler) http.Handler {
 			}
 		}
 
-		if !sessions.ValidateSession(ctx, requestToken) {
+		owner, ok := sessions.Authenticate(ctx, requestToken)
+		if !ok {
 			eUtil.WriteError(w, r, nil, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
-
-// MustBeAdmin verifies is an **already authenticated** user is an admin
-func MustBeAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-
-		profile := profile.Profile{ID: ctx.Value("owner").(uuid.UUID)}
-		profile, err := profile.Get(ctx)
-
-		if err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+		ctx = context.WithValue(ctx, "owner", owner)
 
-			return
-		}
-
-		if !profile.IsAdmin {
-			eUtil.WriteError(w, r, nil, http.StatusUnauthorized, "Unauthorized")
-
-			return
-		}
-
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
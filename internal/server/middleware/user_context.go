@@ -23,59 +23,36 @@ import (
 	"net/http"
 
 	"github.com/BasedDevelopment/eve/internal/profile"
-	"github.com/BasedDevelopment/eve/internal/sessions"
-	"github.com/BasedDevelopment/eve/internal/tokens"
+	"github.com/BasedDevelopment/eve/internal/rbac"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/google/uuid"
 )
 
-// UserContext fetches the owner of the request from the current session and
-// and appends it to the request context. Requires Auth, required MustBeAdmin.
+// UserContext makes sure the owner of the request, which Auth appended to the
+// request context, exists and is not disabled, and appends their permissions
+// to the request context. Requires Auth, required by Require.
 func UserContext(next http.Handler) http.Handler {
 	// This function doesn't check whether a user is authenticated
 	// and as such should only be used after Auth has been called.
 
-	// It is required for the MustBeAdmin middleware though, since
-	// that middleware uses the profile in the request context.
+	// It is required for the Require middleware though, since
+	// that middleware uses the permissions in the request context.
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		// init empty requestToken and err
-		requestToken := tokens.Token{}
-		var err error
-		// Get session
-		if r.Header.Get("Authorization") != "" {
-			requestToken, err = getTokenFromHeader(w, r) // function from auth middleware; gets token from authorization header
-		} else {
-			requestToken, err = getTokenFromQuery(w, r)
-		}
-
-		if err != nil {
-			switch err {
-			case ErrBadHeader:
-				eUtil.WriteError(w, r, nil, http.StatusBadRequest, ErrBadHeader.Error())
-				return
-			case ErrBadToken:
-				eUtil.WriteError(w, r, nil, http.StatusUnauthorized, ErrBadToken.Error())
-				return
-			case ErrMissingHeader:
-				eUtil.WriteError(w, r, nil, http.StatusBadRequest, ErrMissingHeader.Error())
-				return
-			}
-		}
-
-		session, err := sessions.GetSession(ctx, requestToken)
-
-		if err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "internal server error")
+		owner, ok := ctx.Value("owner").(uuid.UUID)
+		if !ok {
+			eUtil.WriteError(w, r, nil, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
 		// Check if the user exists and is not disabled
-		profile := profile.Profile{ID: session.Owner}
-		profile, err = profile.Get(ctx)
+		profile := profile.Profile{ID: owner}
+		profile, err := profile.Get(ctx)
 
 		if err != nil {
 			eUtil.WriteError(w, r, nil, http.StatusInternalServerError, "internal server error")
+			return
 		}
 
 		// Error if user is suspended
@@ -84,8 +61,13 @@ func UserContext(next http.Handler) http.Handler {
 			return
 		}
 
-		// Add the owner of the session to r.Context
-		ctx = context.WithValue(ctx, "owner", session.Owner)
+		perms, err := rbac.Permissions(ctx, profile.ID, profile.IsAdmin)
+		if err != nil {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		ctx = context.WithValue(ctx, "permissions", perms)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
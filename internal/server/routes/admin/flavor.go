@@ -0,0 +1,169 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/flavor"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeFlavorError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, flavor.ErrFlavorNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, err.Error())
+	case errors.Is(err, flavor.ErrFlavorInUse):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func getFlavor(w http.ResponseWriter, r *http.Request) (flavor.Flavor, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "flavor"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid flavor ID")
+		return flavor.Flavor{}, false
+	}
+
+	f, err := flavor.Get(r.Context(), id)
+	if err != nil {
+		writeFlavorError(w, r, err, "Failed to get flavor")
+		return flavor.Flavor{}, false
+	}
+
+	return f, true
+}
+
+func GetFlavors(w http.ResponseWriter, r *http.Request) {
+	flavors, err := flavor.List(r.Context(), true)
+	if err != nil {
+		writeFlavorError(w, r, err, "Failed to get flavors")
+		return
+	}
+
+	if err := eUtil.WriteResponse(flavors, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetFlavor(w http.ResponseWriter, r *http.Request) {
+	f, ok := getFlavor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := eUtil.WriteResponse(f, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateFlavor(w http.ResponseWriter, r *http.Request) {
+	req := new(util.FlavorCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	f := flavor.Flavor{
+		Name: req.Name,
+		CPU:  req.CPU,
+		// req is sent as mb, but we want to store it as bytes
+		Memory:    int64(req.Memory) * 1024 * 1024,
+		Disk:      req.Disk,
+		Bandwidth: req.Bandwidth,
+		Price:     req.Price,
+		Active:    req.Active == nil || *req.Active,
+		Remarks:   req.Remarks,
+	}
+
+	f, err := flavor.Create(r.Context(), f)
+	if err != nil {
+		writeFlavorError(w, r, err, "Failed to create flavor")
+		return
+	}
+
+	if err := eUtil.WriteResponse(f, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateFlavor(w http.ResponseWriter, r *http.Request) {
+	f, ok := getFlavor(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.FlavorUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if req.Name != nil {
+		f.Name = *req.Name
+	}
+	if req.Bandwidth != nil {
+		f.Bandwidth = *req.Bandwidth
+	}
+	// A negative price removes it
+	if req.Price != nil {
+		f.Price = req.Price
+		if *req.Price < 0 {
+			f.Price = nil
+		}
+	}
+	if req.Active != nil {
+		f.Active = *req.Active
+	}
+	if req.Remarks != nil {
+		f.Remarks = *req.Remarks
+	}
+
+	f, err := flavor.Update(r.Context(), f)
+	if err != nil {
+		writeFlavorError(w, r, err, "Failed to update flavor")
+		return
+	}
+
+	if err := eUtil.WriteResponse(f, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteFlavor(w http.ResponseWriter, r *http.Request) {
+	f, ok := getFlavor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := flavor.Delete(r.Context(), f.ID); err != nil {
+		writeFlavorError(w, r, err, "Failed to delete flavor")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "flavor deleted",
+	}, w, http.StatusOK)
+}
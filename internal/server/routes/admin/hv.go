@@ -19,9 +19,12 @@
 package admin
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/BasedDevelopment/eve/pkg/status"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -51,7 +54,7 @@ func getHV(w http.ResponseWriter, r *http.Request) *controllers.HV {
 		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid hypervisor ID")
 		return nil
 	}
-	hv, ok := controllers.Cloud.HVs[hvid]
+	hv, ok := controllers.Cloud.Get(hvid)
 	if !ok {
 		eUtil.WriteError(w, r, err, http.StatusNotFound, "Hypervisor not found")
 		return nil
@@ -86,20 +89,119 @@ func GetHVState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := hv.Refresh(); err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to refresh hypervisor state")
-	}
+	conn := hv.ConnStatus()
 
 	hv.Mutex.Lock()
 	defer hv.Mutex.Unlock()
 
+	// Libvirt status is whatever the monitor fetched last, if anything
+	libvirtState := status.StatusUnknown
+	reason := ""
+	if hv.Specs != nil {
+		libvirtState = hv.Specs.Status
+		reason = hv.Specs.StatusReason
+	}
+
 	response := map[string]interface{}{
-		"state":     hv.Specs.Status,
-		"state_str": hv.Specs.Status.String(),
-		"reason":    hv.Specs.StatusReason,
+		"state":      libvirtState,
+		"state_str":  libvirtState.String(),
+		"reason":     reason,
+		"connection": conn,
 	}
 
 	if err := eUtil.WriteResponse(response, w, http.StatusOK); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
+
+func CreateHV(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := new(util.HVCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	hv, err := controllers.Cloud.CreateHV(ctx, req)
+	if err != nil {
+		if errors.Is(err, controllers.ErrHVHandshake) {
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to verify auto url and serial")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to create hypervisor")
+		return
+	}
+
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(hv, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateHV(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	req := new(util.HVUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	hv, err := controllers.Cloud.UpdateHV(ctx, hv.ID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrHVNotFound):
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "Hypervisor not found")
+		case errors.Is(err, controllers.ErrHVHandshake):
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to verify auto url and serial")
+		default:
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to update hypervisor")
+		}
+		return
+	}
+
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(hv, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteHV(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	// Hypervisors with VMs are only removed when explicitly forced
+	force := r.URL.Query().Get("force") == "true"
+
+	if err := controllers.Cloud.DeleteHV(ctx, hv.ID, force); err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrHVNotFound):
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "Hypervisor not found")
+		case errors.Is(err, controllers.ErrHVHasVMs):
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Hypervisor still has virtual machines, use force to delete anyway")
+		case errors.Is(err, controllers.ErrHVHasPools), errors.Is(err, controllers.ErrHVHasBackups):
+			eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+		default:
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to delete hypervisor")
+		}
+		return
+	}
+
+	if err := eUtil.WriteResponse(hv.ID, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
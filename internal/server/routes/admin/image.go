@@ -0,0 +1,263 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/image"
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+func writeImageError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, image.ErrImageNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, err.Error())
+	case errors.Is(err, image.ErrImageDeprecated):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func getImage(w http.ResponseWriter, r *http.Request) (image.Image, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "image"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid image ID")
+		return image.Image{}, false
+	}
+
+	img, err := image.Get(r.Context(), id)
+	if err != nil {
+		writeImageError(w, r, err, "Failed to get image")
+		return image.Image{}, false
+	}
+
+	return img, true
+}
+
+func GetImages(w http.ResponseWriter, r *http.Request) {
+	images, err := image.List(r.Context(), true)
+	if err != nil {
+		writeImageError(w, r, err, "Failed to get images")
+		return
+	}
+
+	if err := eUtil.WriteResponse(images, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetImage(w http.ResponseWriter, r *http.Request) {
+	img, ok := getImage(w, r)
+	if !ok {
+		return
+	}
+
+	if err := eUtil.WriteResponse(img, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+// CreateImage registers an image, it is synced separately
+func CreateImage(w http.ResponseWriter, r *http.Request) {
+	req := new(util.ImageCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	img := image.Image{
+		Name:        req.Name,
+		Kind:        req.Kind,
+		OSFamily:    req.OSFamily,
+		Version:     req.Version,
+		OSVariant:   req.OSVariant,
+		DefaultUser: req.DefaultUser,
+		MinDisk:     req.MinDisk,
+		// req is sent as mb, but we want to store it as bytes
+		MinMemory: int64(req.MinMemory) * 1024 * 1024,
+		FileName:  req.FileName,
+		Source:    req.Source,
+		Checksum:  req.Checksum,
+		Remarks:   req.Remarks,
+	}
+
+	img, err := image.Create(r.Context(), img)
+	if err != nil {
+		writeImageError(w, r, err, "Failed to create image")
+		return
+	}
+
+	if err := eUtil.WriteResponse(img, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateImage(w http.ResponseWriter, r *http.Request) {
+	img, ok := getImage(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.ImageUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if req.Name != nil {
+		img.Name = *req.Name
+	}
+	if req.OSFamily != nil {
+		img.OSFamily = *req.OSFamily
+	}
+	if req.Version != nil {
+		img.Version = *req.Version
+	}
+	if req.OSVariant != nil {
+		img.OSVariant = *req.OSVariant
+	}
+	if req.DefaultUser != nil {
+		img.DefaultUser = *req.DefaultUser
+	}
+	if req.MinDisk != nil {
+		img.MinDisk = *req.MinDisk
+	}
+	if req.MinMemory != nil {
+		img.MinMemory = int64(*req.MinMemory) * 1024 * 1024
+	}
+	if req.Source != nil {
+		img.Source = *req.Source
+	}
+	if req.Remarks != nil {
+		img.Remarks = *req.Remarks
+	}
+
+	img, err := image.Update(r.Context(), img)
+	if err != nil {
+		writeImageError(w, r, err, "Failed to update image")
+		return
+	}
+
+	if err := eUtil.WriteResponse(img, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteImage(w http.ResponseWriter, r *http.Request) {
+	img, ok := getImage(w, r)
+	if !ok {
+		return
+	}
+
+	if err := image.Delete(r.Context(), img.ID); err != nil {
+		writeImageError(w, r, err, "Failed to delete image")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "image deleted",
+	}, w, http.StatusOK)
+}
+
+func setImageDeprecated(w http.ResponseWriter, r *http.Request, on bool) {
+	img, ok := getImage(w, r)
+	if !ok {
+		return
+	}
+
+	img, err := image.Deprecate(r.Context(), img.ID, on)
+	if err != nil {
+		writeImageError(w, r, err, "Failed to update image")
+		return
+	}
+
+	if err := eUtil.WriteResponse(img, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+// DeprecateImage stops new VMs from being made from an image, the ones made
+// from it keep running
+func DeprecateImage(w http.ResponseWriter, r *http.Request) {
+	setImageDeprecated(w, r, true)
+}
+
+func UndeprecateImage(w http.ResponseWriter, r *http.Request) {
+	setImageDeprecated(w, r, false)
+}
+
+func GetImageLocations(w http.ResponseWriter, r *http.Request) {
+	img, ok := getImage(w, r)
+	if !ok {
+		return
+	}
+
+	locations, err := image.Locations(r.Context(), img.ID)
+	if err != nil {
+		writeImageError(w, r, err, "Failed to get image locations")
+		return
+	}
+
+	if err := eUtil.WriteResponse(locations, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+// SyncImage puts an image in the pools that take it on every online
+// hypervisor
+func SyncImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	img, ok := getImage(w, r)
+	if !ok {
+		return
+	}
+
+	// No point in spreading an image no VM can be made from
+	if img.Deprecated != nil {
+		writeImageError(w, r, image.ErrImageDeprecated, "")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	task, err := tasks.Submit(ctx, owner, "image.sync", img.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		return img.ID, controllers.Cloud.SyncImage(ctx, img, func(percent int) {
+			if err := t.SetProgress(ctx, percent); err != nil {
+				log.Warn().Err(err).Str("task", t.ID.String()).Msg("Failed to update task progress")
+			}
+		})
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
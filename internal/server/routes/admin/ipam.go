@@ -0,0 +1,369 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/ipam"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeIPAMError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, ipam.ErrPoolNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "IP pool not found")
+	case errors.Is(err, ipam.ErrAddressNotFound), errors.Is(err, ipam.ErrReservationNotFound),
+		errors.Is(err, ipam.ErrVMNotFound), errors.Is(err, ipam.ErrNICNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, err.Error())
+	case errors.Is(err, ipam.ErrPoolInUse), errors.Is(err, ipam.ErrAddressInUse), errors.Is(err, ipam.ErrPoolExhausted):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	case errors.Is(err, ipam.ErrGatewayOutside), errors.Is(err, ipam.ErrAddressOutside), errors.Is(err, ipam.ErrInvalidRange):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+// Reload the VM an address was assigned to or released from, so its NICs
+// show it right away rather than on the next round of the monitor
+func reloadVM(id *uuid.UUID) {
+	if id == nil {
+		return
+	}
+
+	if hv, _, ok := controllers.Cloud.FindVM(*id); ok {
+		hv.InitVMs()
+	}
+}
+
+func getPoolID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "ip_pool"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid IP pool ID")
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func GetIPPools(w http.ResponseWriter, r *http.Request) {
+	pools, err := ipam.ListPools(r.Context())
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get IP pools")
+		return
+	}
+
+	if err := eUtil.WriteResponse(pools, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetIPPool(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	pool, err := ipam.GetPool(r.Context(), id)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to get IP pool")
+		return
+	}
+
+	if err := eUtil.WriteResponse(pool, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func parseAddrs(in []string) []netip.Addr {
+	out := make([]netip.Addr, 0, len(in))
+	for _, s := range in {
+		// Already validated by the request
+		out = append(out, netip.MustParseAddr(s))
+	}
+	return out
+}
+
+func CreateIPPool(w http.ResponseWriter, r *http.Request) {
+	req := new(util.IPPoolCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	pool := ipam.Pool{
+		Name:      req.Name,
+		Prefix:    netip.MustParsePrefix(req.Prefix),
+		DNS:       parseAddrs(req.DNS),
+		Site:      req.Site,
+		NetworkID: req.Network,
+		Remarks:   req.Remarks,
+	}
+
+	if req.Gateway != nil {
+		gw := netip.MustParseAddr(*req.Gateway)
+		pool.Gateway = &gw
+	}
+
+	pool, err := ipam.CreatePool(r.Context(), pool)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to create IP pool")
+		return
+	}
+
+	if err := eUtil.WriteResponse(pool, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateIPPool(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.IPPoolUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	pool, err := ipam.GetPool(ctx, id)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to get IP pool")
+		return
+	}
+
+	// An empty gateway removes it
+	if req.Gateway != nil {
+		pool.Gateway = nil
+		if *req.Gateway != "" {
+			gw := netip.MustParseAddr(*req.Gateway)
+			pool.Gateway = &gw
+		}
+	}
+	if req.DNS != nil {
+		pool.DNS = parseAddrs(req.DNS)
+	}
+	if req.Remarks != nil {
+		pool.Remarks = *req.Remarks
+	}
+
+	pool, err = ipam.UpdatePool(ctx, pool)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to update IP pool")
+		return
+	}
+
+	if err := eUtil.WriteResponse(pool, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteIPPool(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := ipam.DeletePool(r.Context(), id); err != nil {
+		writeIPAMError(w, r, err, "Failed to delete IP pool")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "ip pool deleted",
+	}, w, http.StatusOK)
+}
+
+func GetIPPoolUtilization(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := ipam.GetUtilization(r.Context(), id)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to get IP pool utilization")
+		return
+	}
+
+	if err := eUtil.WriteResponse(u, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetIPAddresses(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	addrs, err := ipam.ListAddresses(r.Context(), id)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get IP addresses")
+		return
+	}
+
+	if err := eUtil.WriteResponse(addrs, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+// AssignIPAddress allocates or reserves an address by hand, the next free one
+// is picked when no address is given
+func AssignIPAddress(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.IPAssignRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	var addr netip.Addr
+	if req.Address != "" {
+		var err error
+		if addr, err = netip.ParseAddr(req.Address); err != nil {
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid IP address")
+			return
+		}
+	}
+
+	address, err := ipam.Assign(r.Context(), id, addr, req.State, req.VM, req.NIC, req.Remarks)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to assign IP address")
+		return
+	}
+	reloadVM(address.VMID)
+
+	if err := eUtil.WriteResponse(address, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func ReleaseIPAddress(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	addr, err := netip.ParseAddr(chi.URLParam(r, "address"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid IP address")
+		return
+	}
+
+	released, err := ipam.Release(r.Context(), id, addr)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to release IP address")
+		return
+	}
+	reloadVM(released.VMID)
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "ip address released",
+	}, w, http.StatusOK)
+}
+
+func GetIPReservations(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	reservations, err := ipam.ListReservations(r.Context(), id)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get reservations")
+		return
+	}
+
+	if err := eUtil.WriteResponse(reservations, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateIPReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.IPReserveRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	first, err := netip.ParseAddr(req.First)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid first address")
+		return
+	}
+
+	last, err := netip.ParseAddr(req.Last)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid last address")
+		return
+	}
+
+	reservation, err := ipam.Reserve(r.Context(), id, first, last, req.Remarks)
+	if err != nil {
+		writeIPAMError(w, r, err, "Failed to reserve range")
+		return
+	}
+
+	if err := eUtil.WriteResponse(reservation, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteIPReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := getPoolID(w, r)
+	if !ok {
+		return
+	}
+
+	reservationID, err := uuid.Parse(chi.URLParam(r, "reservation"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid reservation ID")
+		return
+	}
+
+	if err := ipam.Unreserve(r.Context(), id, reservationID); err != nil {
+		writeIPAMError(w, r, err, "Failed to delete reservation")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "reservation deleted",
+	}, w, http.StatusOK)
+}
@@ -0,0 +1,157 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+func writeMaintenanceError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, controllers.ErrEvacuationNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Evacuation not found")
+	case errors.Is(err, controllers.ErrHVNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Target hypervisor not found")
+	case errors.Is(err, controllers.ErrEvacuationTargetBad):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	case errors.Is(err, controllers.ErrHVNotMaintenance),
+		errors.Is(err, controllers.ErrEvacuationRunning):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func setMaintenance(w http.ResponseWriter, r *http.Request, on bool) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	if err := hv.SetMaintenance(r.Context(), on); err != nil {
+		writeMaintenanceError(w, r, err, "Failed to update hypervisor")
+		return
+	}
+
+	if err := eUtil.WriteResponse(hv, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func EnterMaintenance(w http.ResponseWriter, r *http.Request) {
+	setMaintenance(w, r, true)
+}
+
+func ExitMaintenance(w http.ResponseWriter, r *http.Request) {
+	setMaintenance(w, r, false)
+}
+
+func EvacuateHV(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	req := new(util.EvacuateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	ev, err := hv.PlanEvacuation(ctx, req)
+	if err != nil {
+		writeMaintenanceError(w, r, err, "Failed to plan evacuation")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	task, err := tasks.Submit(ctx, owner, "hv.evacuate", hv.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		return hv.ID, hv.Evacuate(ctx, ev, func(percent int) {
+			if err := t.SetProgress(ctx, percent); err != nil {
+				log.Warn().Err(err).Str("task", t.ID.String()).Msg("Failed to update task progress")
+			}
+		})
+	})
+	if err != nil {
+		if abortErr := ev.Abort(ctx, err); abortErr != nil {
+			log.Error().Err(abortErr).Str("evacuation", ev.ID.String()).Msg("Failed to abort evacuation")
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := ev.SetTask(ctx, task.ID); err != nil {
+		log.Warn().Err(err).Str("evacuation", ev.ID.String()).Msg("Failed to record evacuation task")
+	}
+
+	if err := eUtil.WriteResponse(ev, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetEvacuations(w http.ResponseWriter, r *http.Request) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	evs, err := hv.ListEvacuations(r.Context())
+	if err != nil {
+		writeMaintenanceError(w, r, err, "Failed to get evacuations")
+		return
+	}
+
+	if err := eUtil.WriteResponse(evs, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetEvacuation(w http.ResponseWriter, r *http.Request) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "evacuation"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid evacuation ID")
+		return
+	}
+
+	ev, err := hv.GetEvacuation(r.Context(), id)
+	if err != nil {
+		writeMaintenanceError(w, r, err, "Failed to get evacuation")
+		return
+	}
+
+	if err := eUtil.WriteResponse(ev, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
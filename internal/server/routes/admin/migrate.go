@@ -0,0 +1,95 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+func writeMigrateError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, controllers.ErrMigrateSameHV),
+		errors.Is(err, controllers.ErrBridgeNotFound),
+		errors.Is(err, controllers.ErrBridgeDisabled):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	case errors.Is(err, controllers.ErrHVOffline),
+		errors.Is(err, controllers.ErrHVMaintenance),
+		errors.Is(err, controllers.ErrHVNoMemory),
+		errors.Is(err, controllers.ErrHVNoRoom),
+		errors.Is(err, controllers.ErrStorageFull),
+		errors.Is(err, controllers.ErrVMNotRunning),
+		errors.Is(err, controllers.ErrVMNotShutoff):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func MigrateVM(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.VMMigrateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	target, ok := controllers.Cloud.Get(req.Target)
+	if !ok {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Target hypervisor not found")
+		return
+	}
+
+	// Catch targets that can't take the VM before queueing, the task checks
+	// again
+	if _, err := hv.PlanMigration(ctx, vm, target, req.Live); err != nil {
+		writeMigrateError(w, r, err, "Failed to plan migration")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	task, err := tasks.Submit(ctx, owner, "vm.migrate", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		return vm.ID, hv.MigrateVM(ctx, vm, target, req.Live, func(percent int) {
+			if err := t.SetProgress(ctx, percent); err != nil {
+				log.Warn().Err(err).Str("task", t.ID.String()).Msg("Failed to update task progress")
+			}
+		})
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeNetworkError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, controllers.ErrBridgeNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Network not found")
+	case errors.Is(err, controllers.ErrBridgeInUse), errors.Is(err, controllers.ErrBridgePools), errors.Is(err, controllers.ErrBridgeExists):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func getNetwork(w http.ResponseWriter, r *http.Request) (*controllers.HV, *controllers.Bridge) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return nil, nil
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "network"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid network ID")
+		return nil, nil
+	}
+
+	b, ok := hv.GetBridge(id)
+	if !ok {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Network not found")
+		return nil, nil
+	}
+
+	return hv, b
+}
+
+func GetNetworks(w http.ResponseWriter, r *http.Request) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	if err := eUtil.WriteResponse(hv.ListBridges(), w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetNetwork(w http.ResponseWriter, r *http.Request) {
+	_, b := getNetwork(w, r)
+	if b == nil {
+		return
+	}
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(b, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateNetwork(w http.ResponseWriter, r *http.Request) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	req := new(util.NetworkCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	b, err := hv.CreateBridge(r.Context(), req)
+	if err != nil {
+		writeNetworkError(w, r, err, "Failed to create network")
+		return
+	}
+
+	if err := eUtil.WriteResponse(b, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateNetwork(w http.ResponseWriter, r *http.Request) {
+	hv, b := getNetwork(w, r)
+	if b == nil {
+		return
+	}
+
+	req := new(util.NetworkUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	b, err := hv.UpdateBridge(r.Context(), b.ID, req)
+	if err != nil {
+		writeNetworkError(w, r, err, "Failed to update network")
+		return
+	}
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(b, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteNetwork(w http.ResponseWriter, r *http.Request) {
+	hv, b := getNetwork(w, r)
+	if b == nil {
+		return
+	}
+
+	if err := hv.DeleteBridge(r.Context(), b.ID); err != nil {
+		writeNetworkError(w, r, err, "Failed to delete network")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "network deleted",
+	}, w, http.StatusOK)
+}
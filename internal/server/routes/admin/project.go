@@ -0,0 +1,179 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/project"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeProjectError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, project.ErrProjectNotFound), errors.Is(err, project.ErrNotMember):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, err.Error())
+	case errors.Is(err, project.ErrUnknownRole):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	case errors.Is(err, project.ErrLastOwner),
+		errors.Is(err, controllers.ErrTransferSameProject),
+		errors.Is(err, controllers.ErrQuotaExceeded):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func getProject(w http.ResponseWriter, r *http.Request) (project.Project, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "project"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid project ID")
+		return project.Project{}, false
+	}
+
+	p, err := project.Get(r.Context(), id)
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to get project")
+		return project.Project{}, false
+	}
+
+	return p, true
+}
+
+func GetProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := project.List(r.Context())
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to get projects")
+		return
+	}
+
+	if err := eUtil.WriteResponse(projects, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetProject(w http.ResponseWriter, r *http.Request) {
+	p, ok := getProject(w, r)
+	if !ok {
+		return
+	}
+
+	if err := eUtil.WriteResponse(p, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetProjectMembers(w http.ResponseWriter, r *http.Request) {
+	p, ok := getProject(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := project.Members(r.Context(), p.ID)
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to get project members")
+		return
+	}
+
+	if err := eUtil.WriteResponse(members, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func SetProjectMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, ok := getProject(w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.ProjectMemberRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	m, err := project.SetMember(ctx, p.ID, user.ID, req.Role)
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to set project member")
+		return
+	}
+
+	if err := eUtil.WriteResponse(m, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func RemoveProjectMember(w http.ResponseWriter, r *http.Request) {
+	p, ok := getProject(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := project.RemoveMember(r.Context(), p.ID, id); err != nil {
+		writeProjectError(w, r, err, "Failed to remove project member")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "project member removed",
+	}, w, http.StatusOK)
+}
+
+func TransferVM(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.VMTransferRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if err := hv.TransferVM(ctx, vm, req.Project); err != nil {
+		writeProjectError(w, r, err, "Failed to transfer VM")
+		return
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(vm, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
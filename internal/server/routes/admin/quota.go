@@ -0,0 +1,83 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+)
+
+func GetUserQuota(w http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := controllers.GetQuotaReport(r.Context(), user.ID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get quota")
+		return
+	}
+
+	if err := eUtil.WriteResponse(report, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func SetUserQuota(w http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.QuotaRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	quota, err := controllers.SetQuota(r.Context(), user.ID, req)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to set quota")
+		return
+	}
+
+	if err := eUtil.WriteResponse(quota, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteUserQuota(w http.ResponseWriter, r *http.Request) {
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := controllers.DeleteQuota(r.Context(), user.ID); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to delete quota")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "quota deleted",
+	}, w, http.StatusOK)
+}
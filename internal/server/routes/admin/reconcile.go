@@ -0,0 +1,118 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/profile"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func GetReconcileReport(w http.ResponseWriter, r *http.Request) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	report, err := hv.Reconcile()
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to reconcile hypervisor")
+		return
+	}
+
+	if err := eUtil.WriteResponse(report, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func AdoptDomain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	domid, err := uuid.Parse(chi.URLParam(r, "domain"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid domain ID")
+		return
+	}
+
+	req := new(util.AdoptDomainRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	owner := profile.Profile{ID: req.User}
+	if _, err := owner.Get(ctx); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "User not found")
+		return
+	}
+
+	vm, err := hv.AdoptDomain(ctx, domid, req.User, req.Hostname)
+	if err != nil {
+		if errors.Is(err, controllers.ErrNotOrphan) {
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Domain is already known")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to adopt domain")
+		return
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(vm, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func ResolveGhost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.ResolveGhostRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if err := hv.ResolveGhost(ctx, vm, req.State); err != nil {
+		if errors.Is(err, controllers.ErrNotGhost) {
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Virtual machine has a libvirt domain")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to resolve ghost")
+		return
+	}
+
+	if err := eUtil.WriteResponse(vm.ID, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
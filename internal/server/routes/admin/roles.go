@@ -0,0 +1,175 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/rbac"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// can reports whether the requester has a permission, for checks that depend
+// on the request body rather than the route
+func can(r *http.Request, perm string) bool {
+	perms, ok := r.Context().Value("permissions").(rbac.Set)
+	return ok && perms.Has(perm)
+}
+
+func writeRoleError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, rbac.ErrRoleNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Role not found")
+	case errors.Is(err, rbac.ErrRoleExists):
+		eUtil.WriteError(w, r, err, http.StatusConflict, "Role already exists")
+	case errors.Is(err, rbac.ErrBuiltinRole), errors.Is(err, rbac.ErrImmutableRole):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	case errors.Is(err, rbac.ErrUnknownPermission):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := rbac.ListRoles(r.Context())
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get roles")
+		return
+	}
+
+	if err := eUtil.WriteResponse(roles, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetRole(w http.ResponseWriter, r *http.Request) {
+	role, err := rbac.GetRole(r.Context(), chi.URLParam(r, "role"))
+	if err != nil {
+		writeRoleError(w, r, err, "Failed to get role")
+		return
+	}
+
+	if err := eUtil.WriteResponse(role, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateRole(w http.ResponseWriter, r *http.Request) {
+	req := new(util.RoleCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	role, err := rbac.CreateRole(r.Context(), req.Name, req.Description, req.Permissions)
+	if err != nil {
+		writeRoleError(w, r, err, "Failed to create role")
+		return
+	}
+
+	if err := eUtil.WriteResponse(role, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateRole(w http.ResponseWriter, r *http.Request) {
+	req := new(util.RoleUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	role, err := rbac.UpdateRole(r.Context(), chi.URLParam(r, "role"), req.Description, req.Permissions)
+	if err != nil {
+		writeRoleError(w, r, err, "Failed to update role")
+		return
+	}
+
+	if err := eUtil.WriteResponse(role, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := rbac.DeleteRole(r.Context(), chi.URLParam(r, "role")); err != nil {
+		writeRoleError(w, r, err, "Failed to delete role")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "role deleted",
+	}, w, http.StatusOK)
+}
+
+func GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := rbac.ProfileRoles(ctx, user.ID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get roles")
+		return
+	}
+
+	perms, err := rbac.Permissions(ctx, user.ID, user.IsAdmin)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get permissions")
+		return
+	}
+
+	response := map[string]interface{}{
+		"roles":       roles,
+		"is_admin":    user.IsAdmin,
+		"permissions": perms,
+	}
+
+	if err := eUtil.WriteResponse(response, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.UserRolesRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if err := rbac.SetProfileRoles(ctx, user.ID, req.Roles); err != nil {
+		writeRoleError(w, r, err, "Failed to set roles")
+		return
+	}
+
+	GetUserRoles(w, r)
+}
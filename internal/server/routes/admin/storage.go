@@ -0,0 +1,164 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, controllers.ErrStorageNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Storage pool not found")
+	case errors.Is(err, controllers.ErrStorageInUse),
+		errors.Is(err, controllers.ErrStorageExists),
+		errors.Is(err, controllers.ErrStorageCapacity):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func getStorage(w http.ResponseWriter, r *http.Request) (*controllers.HV, *controllers.Storage) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return nil, nil
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "storage"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid storage pool ID")
+		return nil, nil
+	}
+
+	s, ok := hv.GetStorage(id)
+	if !ok {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Storage pool not found")
+		return nil, nil
+	}
+
+	return hv, s
+}
+
+func GetStorages(w http.ResponseWriter, r *http.Request) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	if err := hv.RefreshStorageUsage(r.Context()); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get storage usage")
+		return
+	}
+
+	if err := eUtil.WriteResponse(hv.ListStorages(), w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetStorage(w http.ResponseWriter, r *http.Request) {
+	hv, s := getStorage(w, r)
+	if s == nil {
+		return
+	}
+
+	if err := hv.RefreshStorageUsage(r.Context()); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get storage usage")
+		return
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(s, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateStorage(w http.ResponseWriter, r *http.Request) {
+	hv := getHV(w, r)
+	if hv == nil {
+		return
+	}
+
+	req := new(util.StorageCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	s, err := hv.CreateStorage(r.Context(), req)
+	if err != nil {
+		writeStorageError(w, r, err, "Failed to create storage pool")
+		return
+	}
+
+	if err := eUtil.WriteResponse(s, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateStorage(w http.ResponseWriter, r *http.Request) {
+	hv, s := getStorage(w, r)
+	if s == nil {
+		return
+	}
+
+	req := new(util.StorageUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	s, err := hv.UpdateStorage(r.Context(), s.ID, req)
+	if err != nil {
+		writeStorageError(w, r, err, "Failed to update storage pool")
+		return
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(s, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteStorage(w http.ResponseWriter, r *http.Request) {
+	hv, s := getStorage(w, r)
+	if s == nil {
+		return
+	}
+
+	if err := hv.DeleteStorage(r.Context(), s.ID); err != nil {
+		writeStorageError(w, r, err, "Failed to delete storage pool")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "storage pool deleted",
+	}, w, http.StatusOK)
+}
@@ -0,0 +1,67 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func GetTasks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	list, err := tasks.List(ctx, uuid.Nil)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get tasks")
+		return
+	}
+
+	if err := eUtil.WriteResponse(list, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetTask(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	taskID, err := uuid.Parse(chi.URLParam(r, "task"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid task ID")
+		return
+	}
+
+	task, err := tasks.Get(ctx, taskID)
+	if err == pgx.ErrNoRows {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "task not found")
+		return
+	}
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -23,10 +23,16 @@ import (
 	"net/http"
 
 	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/mfa"
 	"github.com/BasedDevelopment/eve/internal/profile"
+	"github.com/BasedDevelopment/eve/internal/rbac"
+	"github.com/BasedDevelopment/eve/internal/sessions"
 	"github.com/BasedDevelopment/eve/internal/util"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -41,6 +47,12 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Making someone an admin is as good as handing out roles
+	if req.IsAdmin && !can(r, rbac.RoleWrite) {
+		eUtil.WriteError(w, r, nil, http.StatusForbidden, "Forbidden")
+		return
+	}
+
 	// New profile instance
 	profile := profile.Profile{
 		Email:    req.Email,
@@ -94,3 +106,135 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 
 	eUtil.WriteResponse(users, w, http.StatusOK)
 }
+
+func getUser(w http.ResponseWriter, r *http.Request) (profile.Profile, bool) {
+	ctx := r.Context()
+
+	userID, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid user ID")
+		return profile.Profile{}, false
+	}
+
+	user := profile.Profile{ID: userID}
+	user, err = user.Get(ctx)
+	if err != nil {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "User not found")
+		return profile.Profile{}, false
+	}
+
+	return user, true
+}
+
+func ResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := mfa.Disable(ctx, user.ID); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to reset two-factor authentication")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "two-factor authentication reset",
+	}, w, http.StatusOK)
+}
+
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.UserUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	// Sessions no longer belong to who we think they do after these
+	revoke := false
+
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+	if req.Email != nil && *req.Email != user.Email {
+		existing := profile.Profile{Email: *req.Email}
+		if _, err := existing.Get(ctx); err == nil {
+			eUtil.WriteError(w, r, nil, http.StatusBadRequest, "User already exists")
+			return
+		}
+		user.Email = *req.Email
+	}
+	if req.Password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*req.Password), 10)
+		if err != nil {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+		user.Password = string(hash)
+		revoke = true
+	}
+	if req.Disabled != nil {
+		if *req.Disabled && !user.Disabled {
+			revoke = true
+		}
+		user.Disabled = *req.Disabled
+	}
+	if req.IsAdmin != nil && *req.IsAdmin != user.IsAdmin {
+		if !can(r, rbac.RoleWrite) {
+			eUtil.WriteError(w, r, nil, http.StatusForbidden, "Forbidden")
+			return
+		}
+		user.IsAdmin = *req.IsAdmin
+	}
+	if req.Remarks != nil {
+		user.Remarks = *req.Remarks
+	}
+
+	if err := user.Update(ctx); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+
+	if revoke {
+		count, err := sessions.RevokeAll(ctx, user.ID)
+		if err != nil {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "User updated, but failed to revoke sessions")
+			return
+		}
+		log.Info().
+			Str("user", user.ID.String()).
+			Int64("revoked", count).
+			Msg("Revoked sessions and API keys after user update")
+	}
+
+	if err := eUtil.WriteResponse(user, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := sessions.RevokeAll(ctx, user.ID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"revoked": count,
+	}, w, http.StatusOK)
+}
@@ -19,10 +19,17 @@
 package admin
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/flavor"
+	"github.com/BasedDevelopment/eve/internal/image"
+	"github.com/BasedDevelopment/eve/internal/placement"
+	"github.com/BasedDevelopment/eve/internal/server/routes/vmstorage"
+	"github.com/BasedDevelopment/eve/internal/tasks"
 	"github.com/BasedDevelopment/eve/internal/util"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 	"github.com/go-chi/chi/v5"
@@ -37,7 +44,11 @@ func GetVMs(w http.ResponseWriter, r *http.Request) {
 		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid hypervisor ID")
 		return
 	}
-	hv := controllers.Cloud.HVs[hvid]
+	hv, ok := controllers.Cloud.Get(hvid)
+	if !ok {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Hypervisor not found")
+		return
+	}
 
 	hv.Mutex.Lock()
 	defer hv.Mutex.Unlock()
@@ -54,6 +65,9 @@ func GetVMs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Disks, snapshots and backups of any VM
+var VMStorage = vmstorage.New(getVM)
+
 func getVM(w http.ResponseWriter, r *http.Request) (*controllers.HV, *controllers.VM) {
 	hvid, err := uuid.Parse(chi.URLParam(r, "hypervisor"))
 	if err != nil {
@@ -67,13 +81,21 @@ func getVM(w http.ResponseWriter, r *http.Request) (*controllers.HV, *controller
 		return nil, nil
 	}
 
-	vm, ok := controllers.Cloud.HVs[hvid].VMs[vmid]
+	hv, ok := controllers.Cloud.Get(hvid)
+	if !ok {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Hypervisor not found")
+		return nil, nil
+	}
+
+	hv.Mutex.Lock()
+	vm, ok := hv.VMs[vmid]
+	hv.Mutex.Unlock()
 	if !ok {
 		eUtil.WriteError(w, r, fmt.Errorf("VM not found"), http.StatusNotFound, "Invalid VM ID")
 		return nil, nil
 	}
 
-	return controllers.Cloud.HVs[hvid], vm
+	return hv, vm
 }
 
 func GetVM(w http.ResponseWriter, r *http.Request) {
@@ -110,6 +132,8 @@ func GetVMState(w http.ResponseWriter, r *http.Request) {
 }
 
 func SetVMState(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner := ctx.Value("owner").(uuid.UUID)
 	hv, vm := getVM(w, r)
 	if vm == nil {
 		return
@@ -121,25 +145,58 @@ func SetVMState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respState, err := hv.SetVMState(vm, req.State)
+	task, err := tasks.Submit(ctx, owner, "vm.state."+req.State, vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		_, err := hv.SetVMState(vm, req.State)
+		return vm.ID, err
+	})
 	if err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to set VM state")
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
 		return
 	}
 
-	if err := eUtil.WriteResponse(respState, w, http.StatusOK); err != nil {
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
 
+// The flavor of a create request is the caller's to fix
+func writeVMFlavorError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, flavor.ErrFlavorNotFound) || errors.Is(err, flavor.ErrFlavorInactive) {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	} else {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get flavor")
+	}
+}
+
+// The image of a create request is the caller's to fix, unless the
+// hypervisor is missing it
+func writeVMImageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, image.ErrImageNotFound),
+		errors.Is(err, image.ErrImageDeprecated),
+		errors.Is(err, image.ErrBelowMinimum),
+		errors.Is(err, controllers.ErrCloudInitISO):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	case errors.Is(err, controllers.ErrImageUnavailable):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get image")
+	}
+}
+
 func CreateVM(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	hvid, err := uuid.Parse(chi.URLParam(r, "hypervisor"))
 	if err != nil {
 		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid hypervisor ID")
+		return
+	}
+	hv, ok := controllers.Cloud.Get(hvid)
+	if !ok {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Hypervisor not found")
+		return
 	}
-	hv := controllers.Cloud.HVs[hvid]
 
 	vm := new(util.VMCreateRequest)
 	if err := util.ParseRequest(r, vm); err != nil {
@@ -147,13 +204,150 @@ func CreateVM(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vmid, err := hv.CreateVM(ctx, vm, hvid)
+	if err := controllers.ApplyFlavor(ctx, vm); err != nil {
+		writeVMFlavorError(w, r, err)
+		return
+	}
+
+	if hv.InMaintenance() {
+		eUtil.WriteError(w, r, controllers.ErrHVMaintenance, http.StatusConflict, controllers.ErrHVMaintenance.Error())
+		return
+	}
+
+	if err := controllers.CheckCreateQuota(ctx, vm); err != nil {
+		if errors.Is(err, controllers.ErrQuotaExceeded) {
+			eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+		} else {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to check quota")
+		}
+		return
+	}
+
+	// Catch bad networks before queueing, the task checks again
+	if _, err := hv.CheckIfaces(vm); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// And images it doesn't have
+	if err := hv.CheckImage(ctx, vm); err != nil {
+		writeVMImageError(w, r, err)
+		return
+	}
+
+	// Same for disks that have nowhere to go
+	if _, err := hv.PlaceDisks(ctx, vm); err != nil {
+		if errors.Is(err, controllers.ErrStorageFull) {
+			eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+		} else {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to place disks")
+		}
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	task, err := tasks.Submit(ctx, owner, "vm.create", uuid.Nil, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		vmid, err := hv.CreateVM(ctx, vm, hvid)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return vmid, nil
+	})
 	if err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to create VM")
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
 		return
 	}
 
-	if err := eUtil.WriteResponse(vmid, w, http.StatusCreated); err != nil {
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+// Create a VM on whichever hypervisor the placement strategy picks
+func CreatePlacedVM(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := new(util.VMPlaceRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if err := controllers.ApplyFlavor(ctx, &req.VMCreateRequest); err != nil {
+		writeVMFlavorError(w, r, err)
+		return
+	}
+
+	// Hypervisors without the image are left out by placement, the image
+	// itself has to be fine for any of them
+	if req.ImageID != nil {
+		if _, err := controllers.UsableImage(ctx, &req.VMCreateRequest); err != nil {
+			writeVMImageError(w, r, err)
+			return
+		}
+	}
+
+	if err := controllers.CheckCreateQuota(ctx, &req.VMCreateRequest); err != nil {
+		if errors.Is(err, controllers.ErrQuotaExceeded) {
+			eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+		} else {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to check quota")
+		}
+		return
+	}
+
+	// Fail right away if no hypervisor fits, the task places it again
+	if _, err := controllers.Cloud.PlaceVM(ctx, req); err != nil {
+		if errors.Is(err, placement.ErrNoCandidate) {
+			eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+		} else {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to place VM")
+		}
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	task, err := tasks.Submit(ctx, owner, "vm.create", uuid.Nil, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		return controllers.Cloud.CreatePlacedVM(ctx, req)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateVM(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.VMUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	res, err := hv.UpdateVM(ctx, vm, req)
+	if errors.Is(err, controllers.ErrQuotaExceeded) ||
+		errors.Is(err, controllers.ErrFlavorDiskShrink) ||
+		errors.Is(err, controllers.ErrStorageFull) {
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+		return
+	} else if errors.Is(err, flavor.ErrFlavorNotFound) || errors.Is(err, flavor.ErrFlavorInactive) {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to update VM")
+		return
+	}
+
+	if err := eUtil.WriteResponse(res, w, http.StatusOK); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
@@ -175,15 +369,18 @@ func DeleteVM(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vmid := vm.ID.String()
+	owner := ctx.Value("owner").(uuid.UUID)
 
 	// destroy and undefine in controller
-	if err := hv.DeleteVM(ctx, vmid); err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to delete VM")
+	task, err := tasks.Submit(ctx, owner, "vm.delete", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		return vm.ID, hv.DeleteVM(ctx, vm.ID.String())
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
 		return
 	}
 
-	if err := eUtil.WriteResponse(vmid, w, http.StatusOK); err != nil {
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
@@ -19,15 +19,30 @@
 package routes
 
 import (
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/BasedDevelopment/eve/internal/mfa"
 	"github.com/BasedDevelopment/eve/internal/profile"
 	"github.com/BasedDevelopment/eve/internal/sessions"
+	"github.com/BasedDevelopment/eve/internal/tokens"
 	"github.com/BasedDevelopment/eve/internal/util"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// clientIP returns the address of the client without the port, RealIP has
+// already swapped in the forwarded address if there is one
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 func Login(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -54,8 +69,107 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A second factor is needed before we hand out a session
+	if profile.TOTPEnabled {
+		challenge, err := sessions.NewChallenge(ctx, profile.ID)
+
+		if err != nil {
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		eUtil.WriteResponse(map[string]interface{}{
+			"mfa_required": true,
+			"challenge":    challenge.String(),
+		}, w, http.StatusOK)
+		return
+	}
+
+	// Issue token
+	userToken, err := sessions.NewSession(ctx, profile, clientIP(r), r.UserAgent())
+
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	// Send token to client
+	eUtil.WriteResponse(map[string]string{
+		"token": userToken.String(),
+	}, w, http.StatusOK)
+}
+
+// LoginMFA exchanges an MFA challenge from Login and a TOTP or recovery code
+// for a session
+func LoginMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse request body
+	req := new(util.LoginMFARequest)
+
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, nil, http.StatusBadRequest, "Failed to parse login request")
+		return
+	}
+
+	challenge, err := tokens.Parse(req.Challenge)
+	if err != nil {
+		eUtil.WriteError(w, r, nil, http.StatusUnauthorized, "Invalid challenge")
+		return
+	}
+
+	// Held until it is used up or released, a concurrent request with the
+	// same challenge is turned away
+	owner, err := sessions.ClaimChallenge(ctx, challenge)
+	if err != nil {
+		eUtil.WriteError(w, r, nil, http.StatusUnauthorized, "Invalid or expired challenge")
+		return
+	}
+
+	profile := profile.Profile{ID: owner}
+	profile, err = profile.Get(ctx)
+
+	if err != nil {
+		sessions.ReleaseChallenge(ctx, challenge)
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	// Validate code
+	ok, err := mfa.Verify(ctx, profile, req.Code, time.Now())
+
+	if err != nil {
+		sessions.ReleaseChallenge(ctx, challenge)
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if !ok {
+		sessions.ReleaseChallenge(ctx, challenge)
+		eUtil.WriteError(w, r, nil, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	if err := sessions.DeleteChallenge(ctx, challenge); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	// The profile may have been disabled since the password was checked
+	profile, err = profile.Get(ctx)
+
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if profile.Disabled {
+		eUtil.WriteError(w, r, nil, http.StatusUnauthorized, "user suspended")
+		return
+	}
+
 	// Issue token
-	userToken, err := sessions.NewSession(ctx, profile)
+	userToken, err := sessions.NewSession(ctx, profile, clientIP(r), r.UserAgent())
 
 	if err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
@@ -0,0 +1,98 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/sessions"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value("owner").(uuid.UUID)
+
+	keys, err := sessions.ListAPIKeys(ctx, userID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get API keys")
+		return
+	}
+
+	if err := eUtil.WriteResponse(keys, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value("owner").(uuid.UUID)
+
+	req := new(util.APIKeyCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	key, token, err := sessions.NewAPIKey(ctx, userID, req.Name, req.Expires)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":      key.ID,
+		"name":    key.Name,
+		"token":   token.String(),
+		"created": key.Created,
+		"expires": key.Expires,
+	}
+
+	if err := eUtil.WriteResponse(response, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value("owner").(uuid.UUID)
+
+	keyID, err := uuid.Parse(chi.URLParam(r, "api_key"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := sessions.RevokeAPIKey(ctx, userID, keyID); err != nil {
+		if errors.Is(err, sessions.ErrAPIKeyNotFound) {
+			eUtil.WriteError(w, r, nil, http.StatusNotFound, "API key not found")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	if err := eUtil.WriteResponse(keyID, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
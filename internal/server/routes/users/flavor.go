@@ -0,0 +1,44 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/flavor"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+)
+
+// GetFlavors lists the flavors on offer
+func GetFlavors(w http.ResponseWriter, r *http.Request) {
+	flavors, err := flavor.List(r.Context(), false)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get flavors")
+		return
+	}
+
+	// How many VMs are on each plan is not for users to see
+	for i := range flavors {
+		flavors[i].VMs = 0
+	}
+
+	if err := eUtil.WriteResponse(flavors, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"net/http"
 
 	"github.com/BasedDevelopment/eve/internal/profile"
+	"github.com/BasedDevelopment/eve/internal/rbac"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 	"github.com/google/uuid"
 )
@@ -45,6 +46,11 @@ func GetSelf(w http.ResponseWriter, r *http.Request) {
 		"updated":    profile.Updated,
 	}
 
+	// UserContext has already worked these out
+	if perms, ok := ctx.Value("permissions").(rbac.Set); ok {
+		response["permissions"] = perms
+	}
+
 	err = eUtil.WriteResponse(response, w, http.StatusOK)
 
 	if err != nil {
@@ -0,0 +1,143 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/mfa"
+	"github.com/BasedDevelopment/eve/internal/profile"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/google/uuid"
+)
+
+func getSelf(w http.ResponseWriter, r *http.Request) (profile.Profile, bool) {
+	ctx := r.Context()
+	self := profile.Profile{ID: ctx.Value("owner").(uuid.UUID)}
+	self, err := self.Get(ctx)
+
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Internal Server Error")
+		return self, false
+	}
+
+	return self, true
+}
+
+func EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	self, ok := getSelf(w, r)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := mfa.Enroll(ctx, self)
+	if err != nil {
+		if errors.Is(err, mfa.ErrAlreadyEnabled) {
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	response := map[string]interface{}{
+		"secret": secret,
+		"uri":    uri,
+	}
+
+	if err := eUtil.WriteResponse(response, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	self, ok := getSelf(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.MFACodeRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	codes, err := mfa.Confirm(ctx, self, req.Code, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrAlreadyEnabled):
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Two-factor authentication is already enabled")
+		case errors.Is(err, mfa.ErrNotEnrolled):
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Two-factor authentication enrollment not started")
+		case errors.Is(err, mfa.ErrInvalidCode):
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid code")
+		default:
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to confirm enrollment")
+		}
+		return
+	}
+
+	response := map[string]interface{}{
+		"recovery_codes": codes,
+	}
+
+	if err := eUtil.WriteResponse(response, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DisableMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	self, ok := getSelf(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.MFACodeRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	// Turning it off needs a valid code too, a stolen session isn't enough
+	valid, err := mfa.Verify(ctx, self, req.Code, time.Now())
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to verify code")
+		return
+	}
+
+	if !valid {
+		eUtil.WriteError(w, r, nil, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	if err := mfa.Disable(ctx, self.ID); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "two-factor authentication disabled",
+	}, w, http.StatusOK)
+}
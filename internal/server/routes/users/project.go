@@ -0,0 +1,301 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/profile"
+	"github.com/BasedDevelopment/eve/internal/project"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeProjectError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, project.ErrProjectNotFound), errors.Is(err, project.ErrNotMember):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, err.Error())
+	case errors.Is(err, project.ErrUnknownRole):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	case errors.Is(err, project.ErrLastOwner),
+		errors.Is(err, project.ErrProjectHasVMs),
+		errors.Is(err, controllers.ErrTransferSameProject),
+		errors.Is(err, controllers.ErrQuotaExceeded):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+// Get the project of the request if the user's role in it allows wanted
+func getUserProject(w http.ResponseWriter, r *http.Request, wanted string) (project.Project, bool) {
+	ctx := r.Context()
+	userID := ctx.Value("owner").(uuid.UUID)
+
+	id, err := uuid.Parse(chi.URLParam(r, "project"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid project ID")
+		return project.Project{}, false
+	}
+
+	// Projects the user is not in are not found, rather than forbidden
+	role, err := project.RoleOf(ctx, id, userID)
+	if errors.Is(err, project.ErrNotMember) {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Project not found")
+		return project.Project{}, false
+	} else if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get project role")
+		return project.Project{}, false
+	}
+
+	if !project.Allows(role, wanted) {
+		eUtil.WriteError(w, r, nil, http.StatusForbidden, fmt.Sprintf("project %s can't do this, it takes %s", role, wanted))
+		return project.Project{}, false
+	}
+
+	p, err := project.Get(ctx, id)
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to get project")
+		return project.Project{}, false
+	}
+	p.Role = role
+
+	return p, true
+}
+
+func memberID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "user"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid user ID")
+		return id, false
+	}
+	return id, true
+}
+
+func GetProjects(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	projects, err := project.ListFor(ctx, ctx.Value("owner").(uuid.UUID))
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to get projects")
+		return
+	}
+
+	if err := eUtil.WriteResponse(projects, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateProject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := new(util.ProjectCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	p, err := project.Create(ctx, req.Name, req.Remarks, ctx.Value("owner").(uuid.UUID))
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to create project")
+		return
+	}
+
+	if err := eUtil.WriteResponse(p, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetProject(w http.ResponseWriter, r *http.Request) {
+	p, ok := getUserProject(w, r, project.RoleViewer)
+	if !ok {
+		return
+	}
+
+	if err := eUtil.WriteResponse(p, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func UpdateProject(w http.ResponseWriter, r *http.Request) {
+	p, ok := getUserProject(w, r, project.RoleOwner)
+	if !ok {
+		return
+	}
+
+	req := new(util.ProjectUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	updated, err := project.Update(r.Context(), p.ID, req.Name, req.Remarks)
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to update project")
+		return
+	}
+	updated.Role = p.Role
+
+	if err := eUtil.WriteResponse(updated, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteProject(w http.ResponseWriter, r *http.Request) {
+	p, ok := getUserProject(w, r, project.RoleOwner)
+	if !ok {
+		return
+	}
+
+	if err := project.Delete(r.Context(), p.ID); err != nil {
+		writeProjectError(w, r, err, "Failed to delete project")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "project deleted",
+	}, w, http.StatusOK)
+}
+
+func GetProjectMembers(w http.ResponseWriter, r *http.Request) {
+	p, ok := getUserProject(w, r, project.RoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := project.Members(r.Context(), p.ID)
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to get project members")
+		return
+	}
+
+	if err := eUtil.WriteResponse(members, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func SetProjectMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, ok := getUserProject(w, r, project.RoleOwner)
+	if !ok {
+		return
+	}
+
+	id, ok := memberID(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.ProjectMemberRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if _, err := (&profile.Profile{ID: id}).Get(ctx); err != nil {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "User not found")
+		return
+	}
+
+	m, err := project.SetMember(ctx, p.ID, id, req.Role)
+	if err != nil {
+		writeProjectError(w, r, err, "Failed to set project member")
+		return
+	}
+
+	if err := eUtil.WriteResponse(m, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+// Owners remove members, anyone may leave
+func RemoveProjectMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, ok := memberID(w, r)
+	if !ok {
+		return
+	}
+
+	wanted := project.RoleOwner
+	if id == ctx.Value("owner").(uuid.UUID) {
+		wanted = project.RoleViewer
+	}
+
+	p, ok := getUserProject(w, r, wanted)
+	if !ok {
+		return
+	}
+
+	if err := project.RemoveMember(ctx, p.ID, id); err != nil {
+		writeProjectError(w, r, err, "Failed to remove project member")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "project member removed",
+	}, w, http.StatusOK)
+}
+
+// Move a VM to another project, the user has to own both
+func TransferVM(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value("owner").(uuid.UUID)
+
+	hv, vm := getUserVMAs(w, r, project.RoleOwner)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.VMTransferRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	role, err := project.RoleOf(ctx, req.Project, userID)
+	if errors.Is(err, project.ErrNotMember) {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "Project not found")
+		return
+	} else if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get project role")
+		return
+	}
+	if !project.Allows(role, project.RoleOwner) {
+		eUtil.WriteError(w, r, nil, http.StatusForbidden, "only owners of the target project can transfer to it")
+		return
+	}
+
+	if err := hv.TransferVM(ctx, vm, req.Project); err != nil {
+		writeProjectError(w, r, err, "Failed to transfer VM")
+		return
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	if err := eUtil.WriteResponse(vm, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
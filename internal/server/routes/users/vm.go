@@ -19,9 +19,17 @@
 package users
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 
+	"github.com/BasedDevelopment/eve/internal/config"
 	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/flavor"
+	"github.com/BasedDevelopment/eve/internal/project"
+	"github.com/BasedDevelopment/eve/internal/server/routes/vmstorage"
+	"github.com/BasedDevelopment/eve/internal/tasks"
 	"github.com/BasedDevelopment/eve/internal/util"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 	"github.com/go-chi/chi/v5"
@@ -29,10 +37,16 @@ import (
 )
 
 func GetVMs(w http.ResponseWriter, r *http.Request) {
-	// Fetch a list of VMs owner by the user across all HVs
+	// Fetch a list of VMs in the projects of the user across all HVs
 	ctx := r.Context()
 	userID := ctx.Value("owner").(uuid.UUID)
 
+	roles, err := project.RolesOf(ctx, userID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get projects")
+		return
+	}
+
 	cloud := controllers.Cloud
 	var response []interface{}
 	cloud.Mutex.Lock()
@@ -42,13 +56,15 @@ func GetVMs(w http.ResponseWriter, r *http.Request) {
 		hv.Mutex.Lock()
 		defer hv.Mutex.Unlock()
 		for _, vm := range hv.VMs {
-			if vm.UserID == userID {
-				vm.Mutex.Lock()
-				defer vm.Mutex.Unlock()
+			vm.Mutex.Lock()
+			defer vm.Mutex.Unlock()
+			if role, ok := roles[vm.Project]; ok {
 				response = append(response, map[string]interface{}{
 					"hypervisor": hv.Hostname,
 					"name":       vm.Hostname,
 					"id":         vm.ID,
+					"project":    vm.Project,
+					"role":       role,
 				})
 			}
 		}
@@ -61,9 +77,21 @@ func GetVMs(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Disks, snapshots and backups of the VMs in the user's projects
+var VMStorage = vmstorage.New(getUserVM)
+
+// Get the VM of the request if the user's role in its project allows what
+// the request does: reading for GET, operating for anything else
 func getUserVM(w http.ResponseWriter, r *http.Request) (*controllers.HV, *controllers.VM) {
+	role := project.RoleOperator
+	if r.Method == http.MethodGet {
+		role = project.RoleViewer
+	}
+	return getUserVMAs(w, r, role)
+}
+
+func getUserVMAs(w http.ResponseWriter, r *http.Request, wanted string) (*controllers.HV, *controllers.VM) {
 	ctx := r.Context()
-	cloud := controllers.Cloud
 	userID := ctx.Value("owner").(uuid.UUID)
 	reqVmid := chi.URLParam(r, "virtual_machine")
 	vmid, err := uuid.Parse(reqVmid)
@@ -72,20 +100,31 @@ func getUserVM(w http.ResponseWriter, r *http.Request) (*controllers.HV, *contro
 		return nil, nil
 	}
 
-	for _, hv := range cloud.HVs {
-		for _, vm := range hv.VMs {
-			if vm.ID == vmid {
-				if vm.UserID != userID {
-					eUtil.WriteError(w, r, nil, http.StatusForbidden, "virtual machine not owned by user")
-					return nil, nil
-				} else {
-					return hv, vm
-				}
-			}
-		}
+	hv, vm, ok := controllers.Cloud.FindVM(vmid)
+	if !ok {
+		eUtil.WriteError(w, r, nil, http.StatusNotFound, "virtual machine not found")
+		return nil, nil
 	}
-	eUtil.WriteError(w, r, nil, http.StatusNotFound, "virtual machine not found")
-	return nil, nil
+
+	vm.Mutex.Lock()
+	projectID := vm.Project
+	vm.Mutex.Unlock()
+
+	role, err := project.RoleOf(ctx, projectID, userID)
+	if errors.Is(err, project.ErrNotMember) {
+		eUtil.WriteError(w, r, nil, http.StatusForbidden, "virtual machine not in a project of the user")
+		return nil, nil
+	} else if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get project role")
+		return nil, nil
+	}
+
+	if !project.Allows(role, wanted) {
+		eUtil.WriteError(w, r, nil, http.StatusForbidden, fmt.Sprintf("project %s can't do this, it takes %s", role, wanted))
+		return nil, nil
+	}
+
+	return hv, vm
 }
 
 func GetVM(w http.ResponseWriter, r *http.Request) {
@@ -112,6 +151,7 @@ func GetVMState(w http.ResponseWriter, r *http.Request) {
 	state, err := hv.GetVMState(vm)
 	if err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get VM state")
+		return
 	}
 
 	if err := eUtil.WriteResponse(state, w, http.StatusOK); err != nil {
@@ -120,9 +160,10 @@ func GetVMState(w http.ResponseWriter, r *http.Request) {
 }
 
 func SetVMState(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := ctx.Value("owner").(uuid.UUID)
 	hv, vm := getUserVM(w, r)
 	if vm == nil {
-		eUtil.WriteError(w, r, nil, http.StatusNotFound, "virtual machine not found")
 		return
 	}
 
@@ -132,23 +173,74 @@ func SetVMState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respState, err := hv.SetVMState(vm, req.State)
+	task, err := tasks.Submit(ctx, userID, "vm.state."+req.State, vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		_, err := hv.SetVMState(vm, req.State)
+		return vm.ID, err
+	})
 	if err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to set VM state")
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
 		return
 	}
 
-	if err := eUtil.WriteResponse(respState, w, http.StatusOK); err != nil {
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
 
 func GetVMConsole(w http.ResponseWriter, r *http.Request) {
-	hv, vm := getUserVM(w, r)
+	hv, vm := getUserVMAs(w, r, project.RoleOperator)
 	if vm == nil {
-		eUtil.WriteError(w, r, nil, http.StatusNotFound, "virtual machine not found")
 		return
 	}
 
 	hv.Auto.WsReq(w, r, vm.ID.String())
 }
+
+func UpdateVM(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := getUserVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.VMUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	// Users may only change what the admins allow them to
+	for _, field := range req.Fields() {
+		if !userEditable(field) {
+			eUtil.WriteError(w, r, fmt.Errorf("%s is not editable", field), http.StatusForbidden, "Field not editable by users")
+			return
+		}
+	}
+
+	res, err := hv.UpdateVM(ctx, vm, req)
+	if errors.Is(err, controllers.ErrQuotaExceeded) ||
+		errors.Is(err, controllers.ErrFlavorDiskShrink) ||
+		errors.Is(err, controllers.ErrStorageFull) {
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+		return
+	} else if errors.Is(err, flavor.ErrFlavorNotFound) || errors.Is(err, flavor.ErrFlavorInactive) {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to update VM")
+		return
+	}
+
+	if err := eUtil.WriteResponse(res, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func userEditable(field string) bool {
+	for _, f := range config.Config.VM.UserEditable {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
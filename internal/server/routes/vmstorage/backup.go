@@ -0,0 +1,224 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package vmstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeBackupError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, controllers.ErrBackupNotFound),
+		errors.Is(err, controllers.ErrPolicyNotFound),
+		errors.Is(err, controllers.ErrStorageNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, err.Error())
+	case errors.Is(err, controllers.ErrNoBackupStorage):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	case errors.Is(err, controllers.ErrBackupBusy), errors.Is(err, controllers.ErrVMNotShutoff):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func backupID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "backup"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid backup ID")
+		return id, false
+	}
+	return id, true
+}
+
+func (h *Handlers) GetVMBackupPolicy(w http.ResponseWriter, r *http.Request) {
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	policy, err := controllers.GetBackupPolicy(r.Context(), vm.ID)
+	if err != nil {
+		writeBackupError(w, r, err, "Failed to get backup policy")
+		return
+	}
+
+	if err := eUtil.WriteResponse(policy, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) SetVMBackupPolicy(w http.ResponseWriter, r *http.Request) {
+	hv, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.BackupPolicyRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	policy, err := hv.SetBackupPolicy(r.Context(), vm, req)
+	if err != nil {
+		writeBackupError(w, r, err, "Failed to set backup policy")
+		return
+	}
+
+	if err := eUtil.WriteResponse(policy, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) DeleteVMBackupPolicy(w http.ResponseWriter, r *http.Request) {
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	if err := controllers.DeleteBackupPolicy(r.Context(), vm.ID); err != nil {
+		writeBackupError(w, r, err, "Failed to delete backup policy")
+		return
+	}
+
+	eUtil.WriteResponse(map[string]interface{}{
+		"message": "backup policy deleted",
+	}, w, http.StatusOK)
+}
+
+func (h *Handlers) GetVMBackups(w http.ResponseWriter, r *http.Request) {
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	backups, err := controllers.ListBackups(r.Context(), vm.ID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get backups")
+		return
+	}
+
+	if err := eUtil.WriteResponse(backups, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) GetVMBackup(w http.ResponseWriter, r *http.Request) {
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := backupID(w, r)
+	if !ok {
+		return
+	}
+
+	b, err := controllers.GetBackup(r.Context(), vm.ID, id)
+	if err != nil {
+		writeBackupError(w, r, err, "Failed to get backup")
+		return
+	}
+
+	if err := eUtil.WriteResponse(b, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) RestoreVMBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := backupID(w, r)
+	if !ok {
+		return
+	}
+
+	// Catch running VMs before queueing, the task checks again
+	if _, err := hv.CheckBackupRestore(ctx, vm, id); err != nil {
+		writeBackupError(w, r, err, "Failed to restore backup")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.backup.restore", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			return vmID, err
+		}
+		return vmID, hv.RestoreBackup(ctx, vm, id)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) DeleteVMBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := backupID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := controllers.CheckBackupDelete(ctx, vm, id); err != nil {
+		writeBackupError(w, r, err, "Failed to delete backup")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.backup.delete", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			return vmID, err
+		}
+		return vmID, hv.DeleteBackup(ctx, vm, id)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
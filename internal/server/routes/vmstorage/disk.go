@@ -0,0 +1,184 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package vmstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeDiskError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, controllers.ErrDiskNotFound), errors.Is(err, controllers.ErrStorageNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, err.Error())
+	case errors.Is(err, controllers.ErrDiskShrink), errors.Is(err, controllers.ErrDiskRoot):
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
+	case errors.Is(err, controllers.ErrStorageFull), errors.Is(err, controllers.ErrDiskNoPool),
+		errors.Is(err, controllers.ErrQuotaExceeded):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func diskID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "disk"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid disk ID")
+		return id, false
+	}
+	return id, true
+}
+
+func (h *Handlers) GetVMDisks(w http.ResponseWriter, r *http.Request) {
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	if err := eUtil.WriteResponse(vm.ListDisks(), w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) AttachVMDisk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.DiskCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	// Catch full pools before queueing, the task checks again
+	if _, err := hv.CheckDiskAttach(ctx, vm, req); err != nil {
+		writeDiskError(w, r, err, "Failed to place disk")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.disk.attach", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			return vmID, err
+		}
+		_, err = hv.AttachDisk(ctx, vm, req)
+		return vmID, err
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) ResizeVMDisk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := diskID(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(util.DiskResizeRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	if err := hv.CheckDiskResize(ctx, vm, id, req.Size); err != nil {
+		writeDiskError(w, r, err, "Failed to resize disk")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.disk.resize", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			return vmID, err
+		}
+		_, err = hv.ResizeDisk(ctx, vm, id, req.Size)
+		return vmID, err
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) DetachVMDisk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := diskID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := vm.CheckDiskDetach(id); err != nil {
+		writeDiskError(w, r, err, "Failed to detach disk")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.disk.detach", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			return vmID, err
+		}
+		return vmID, hv.DetachDisk(ctx, vm, id)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
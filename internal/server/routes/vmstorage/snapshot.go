@@ -0,0 +1,208 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package vmstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/tasks"
+	"github.com/BasedDevelopment/eve/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeSnapshotError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, controllers.ErrSnapshotNotFound):
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Snapshot not found")
+	case errors.Is(err, controllers.ErrSnapshotExists),
+		errors.Is(err, controllers.ErrSnapshotLimit),
+		errors.Is(err, controllers.ErrQuotaExceeded),
+		errors.Is(err, controllers.ErrSnapshotBusy),
+		errors.Is(err, controllers.ErrVMNotShutoff):
+		eUtil.WriteError(w, r, err, http.StatusConflict, err.Error())
+	default:
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, msg)
+	}
+}
+
+func snapshotID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "snapshot"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid snapshot ID")
+		return id, false
+	}
+	return id, true
+}
+
+func (h *Handlers) GetVMSnapshots(w http.ResponseWriter, r *http.Request) {
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	snapshots, err := controllers.ListSnapshots(r.Context(), vm.ID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get snapshots")
+		return
+	}
+
+	if err := eUtil.WriteResponse(snapshots, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) GetVMSnapshot(w http.ResponseWriter, r *http.Request) {
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := snapshotID(w, r)
+	if !ok {
+		return
+	}
+
+	snapshot, err := controllers.GetSnapshot(r.Context(), vm.ID, id)
+	if err != nil {
+		writeSnapshotError(w, r, err, "Failed to get snapshot")
+		return
+	}
+
+	if err := eUtil.WriteResponse(snapshot, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) CreateVMSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	req := new(util.SnapshotCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	// Recorded right away so the limit and name checks hold across requests
+	snapshot, err := controllers.RecordSnapshot(ctx, vm, req)
+	if err != nil {
+		writeSnapshotError(w, r, err, "Failed to create snapshot")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.snapshot.create", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			controllers.DropSnapshot(ctx, snapshot)
+			return vmID, err
+		}
+		return vmID, hv.CreateSnapshot(ctx, vm, snapshot)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) RevertVMSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hv, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := snapshotID(w, r)
+	if !ok {
+		return
+	}
+
+	// Catch running VMs before queueing, the task checks again
+	if _, err := hv.CheckSnapshotRevert(ctx, vm, id); err != nil {
+		writeSnapshotError(w, r, err, "Failed to revert snapshot")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.snapshot.revert", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			return vmID, err
+		}
+		return vmID, hv.RevertSnapshot(ctx, vm, id)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func (h *Handlers) DeleteVMSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_, vm := h.getVM(w, r)
+	if vm == nil {
+		return
+	}
+
+	id, ok := snapshotID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := controllers.CheckSnapshotDelete(ctx, vm, id); err != nil {
+		writeSnapshotError(w, r, err, "Failed to delete snapshot")
+		return
+	}
+
+	owner := ctx.Value("owner").(uuid.UUID)
+	vmID := vm.ID
+	task, err := tasks.Submit(ctx, owner, "vm.snapshot.delete", vm.ID, func(ctx context.Context, t *tasks.Task) (uuid.UUID, error) {
+		hv, vm, err := controllers.Cloud.TaskVM(vmID)
+		if err != nil {
+			return vmID, err
+		}
+		return vmID, hv.DeleteSnapshot(ctx, vm, id)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to queue task")
+		return
+	}
+
+	if err := eUtil.WriteResponse(task, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -16,24 +16,24 @@
  * along with this program.  If not, see <https://www.gnu.org/licenses/>.
  */
 
-package main
+package vmstorage
 
 import (
+	"net/http"
+
 	"github.com/BasedDevelopment/eve/internal/controllers"
-	"github.com/rs/zerolog/log"
 )
 
-func connHV(hv *controllers.HV) {
-	// Connect to hypervisors, fetch VMs, and check for VM consistency
-	if err := hv.Init(); err != nil {
-		log.Warn().
-			Err(err).
-			Str("hostname", hv.Hostname).
-			Msg("Failed to connect to hypervisor and fetch virtual machines")
-	} else {
-		log.Info().
-			Str("hostname", hv.Hostname).
-			Int("vms", len(hv.VMs)).
-			Msg("Connected to hypervisor and fetched virtual machines")
-	}
+// Finds the VM of a request and the hypervisor it is on. A nil VM means the
+// error response has been written.
+type VMLookup func(w http.ResponseWriter, r *http.Request) (*controllers.HV, *controllers.VM)
+
+// Handlers for the disks, snapshots and backups of a VM. The admin and user
+// routes only differ in how they find the VM, and who may see it.
+type Handlers struct {
+	getVM VMLookup
+}
+
+func New(getVM VMLookup) *Handlers {
+	return &Handlers{getVM: getVM}
 }
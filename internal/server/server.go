@@ -22,6 +22,8 @@ import (
 	"time"
 
 	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/BasedDevelopment/eve/internal/metrics"
+	"github.com/BasedDevelopment/eve/internal/rbac"
 	"github.com/BasedDevelopment/eve/internal/server/middleware"
 	"github.com/BasedDevelopment/eve/internal/server/routes"
 	"github.com/BasedDevelopment/eve/internal/server/routes/admin"
@@ -33,6 +35,17 @@ import (
 	"github.com/go-chi/httprate"
 )
 
+// Metrics serves /metrics, it is kept away from the API so it doesn't need
+// authentication
+func Metrics() *chi.Mux {
+	r := chi.NewMux()
+
+	r.Use(em.Recoverer)
+	r.Method("GET", "/metrics", metrics.Handler())
+
+	return r
+}
+
 func Service() *chi.Mux {
 	r := chi.NewMux()
 
@@ -41,6 +54,7 @@ func Service() *chi.Mux {
 		r.Use(cm.RealIP)
 	}
 	r.Use(cm.RequestID)
+	r.Use(metrics.Middleware)
 	r.Use(em.Logger)
 	r.Use(cm.GetHead)
 	r.Use(httprate.LimitByIP(100, 1*time.Minute))
@@ -49,7 +63,7 @@ func Service() *chi.Mux {
 	r.Use(cm.NoCache)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: false,
@@ -60,46 +74,190 @@ func Service() *chi.Mux {
 
 	// Login
 	r.Post("/login", routes.Login)
+	r.Post("/login/mfa", routes.LoginMFA)
+
+	// Shorthand for per-route permission checks
+	can := middleware.Require
 
 	// Admin endpoints
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.Auth)
 		r.Use(middleware.UserContext)
-		r.Use(middleware.MustBeAdmin)
 
 		r.Route("/admin", func(r chi.Router) {
 			// Hypervisor management
 			r.Route("/hypervisors", func(r chi.Router) {
-				r.Get("/", admin.GetHVs)
-				//r.Post("/", admin.CreateHV)
+				r.With(can(rbac.HVRead)).Get("/", admin.GetHVs)
+				r.With(can(rbac.HVWrite)).Post("/", admin.CreateHV)
 				r.Route("/{hypervisor}", func(r chi.Router) {
-					r.Get("/", admin.GetHV)
-					r.Get("/state", admin.GetHVState)
-					//r.Patch("/", admin.UpdateHV)
-					//r.Delete("/", admin.DeleteHV)
+					r.With(can(rbac.HVRead)).Get("/", admin.GetHV)
+					r.With(can(rbac.HVRead)).Get("/state", admin.GetHVState)
+					r.With(can(rbac.HVWrite)).Patch("/", admin.UpdateHV)
+					r.With(can(rbac.HVWrite)).Delete("/", admin.DeleteHV)
+					r.Route("/networks", func(r chi.Router) {
+						r.With(can(rbac.HVRead)).Get("/", admin.GetNetworks)
+						r.With(can(rbac.HVWrite)).Post("/", admin.CreateNetwork)
+						r.Route("/{network}", func(r chi.Router) {
+							r.With(can(rbac.HVRead)).Get("/", admin.GetNetwork)
+							r.With(can(rbac.HVWrite)).Patch("/", admin.UpdateNetwork)
+							r.With(can(rbac.HVWrite)).Delete("/", admin.DeleteNetwork)
+						})
+					})
+					r.Route("/storages", func(r chi.Router) {
+						r.With(can(rbac.HVRead)).Get("/", admin.GetStorages)
+						r.With(can(rbac.HVWrite)).Post("/", admin.CreateStorage)
+						r.Route("/{storage}", func(r chi.Router) {
+							r.With(can(rbac.HVRead)).Get("/", admin.GetStorage)
+							r.With(can(rbac.HVWrite)).Patch("/", admin.UpdateStorage)
+							r.With(can(rbac.HVWrite)).Delete("/", admin.DeleteStorage)
+						})
+					})
+					r.Route("/maintenance", func(r chi.Router) {
+						r.With(can(rbac.HVWrite)).Post("/", admin.EnterMaintenance)
+						r.With(can(rbac.HVWrite)).Delete("/", admin.ExitMaintenance)
+					})
+					r.Route("/evacuations", func(r chi.Router) {
+						r.With(can(rbac.HVRead)).Get("/", admin.GetEvacuations)
+						r.With(can(rbac.HVWrite)).Post("/", admin.EvacuateHV)
+						r.With(can(rbac.HVRead)).Get("/{evacuation}", admin.GetEvacuation)
+					})
+					r.Route("/reconcile", func(r chi.Router) {
+						r.With(can(rbac.HVRead)).Get("/", admin.GetReconcileReport)
+						r.With(can(rbac.VMWrite)).Post("/orphans/{domain}", admin.AdoptDomain)
+						r.With(can(rbac.VMWrite)).Post("/ghosts/{virtual_machine}", admin.ResolveGhost)
+					})
 					r.Route("/virtual_machines", func(r chi.Router) {
-						r.Get("/", admin.GetVMs)
-						r.Post("/", admin.CreateVM)
+						r.With(can(rbac.VMRead)).Get("/", admin.GetVMs)
+						r.With(can(rbac.VMWrite)).Post("/", admin.CreateVM)
 						r.Route("/{virtual_machine}", func(r chi.Router) {
-							r.Get("/", admin.GetVM)
-							r.Get("/console", admin.GetVMConsole)
+							r.With(can(rbac.VMRead)).Get("/", admin.GetVM)
+							r.With(can(rbac.VMConsole)).Get("/console", admin.GetVMConsole)
 							r.Route("/state", func(r chi.Router) {
-								r.Get("/", admin.GetVMState)
-								r.Patch("/", admin.SetVMState)
+								r.With(can(rbac.VMRead)).Get("/", admin.GetVMState)
+								r.With(can(rbac.VMState)).Patch("/", admin.SetVMState)
+							})
+							r.With(can(rbac.VMWrite)).Patch("/", admin.UpdateVM)
+							r.With(can(rbac.VMWrite)).Delete("/", admin.DeleteVM)
+							r.With(can(rbac.VMWrite)).Post("/migrate", admin.MigrateVM)
+							r.With(can(rbac.VMWrite)).Post("/transfer", admin.TransferVM)
+							r.Route("/disks", func(r chi.Router) {
+								r.With(can(rbac.VMRead)).Get("/", admin.VMStorage.GetVMDisks)
+								r.With(can(rbac.VMWrite)).Post("/", admin.VMStorage.AttachVMDisk)
+								r.With(can(rbac.VMWrite)).Patch("/{disk}", admin.VMStorage.ResizeVMDisk)
+								r.With(can(rbac.VMWrite)).Delete("/{disk}", admin.VMStorage.DetachVMDisk)
+							})
+							r.Route("/snapshots", func(r chi.Router) {
+								r.With(can(rbac.VMRead)).Get("/", admin.VMStorage.GetVMSnapshots)
+								r.With(can(rbac.VMWrite)).Post("/", admin.VMStorage.CreateVMSnapshot)
+								r.Route("/{snapshot}", func(r chi.Router) {
+									r.With(can(rbac.VMRead)).Get("/", admin.VMStorage.GetVMSnapshot)
+									r.With(can(rbac.VMWrite)).Post("/revert", admin.VMStorage.RevertVMSnapshot)
+									r.With(can(rbac.VMWrite)).Delete("/", admin.VMStorage.DeleteVMSnapshot)
+								})
+							})
+							r.Route("/backup_policy", func(r chi.Router) {
+								r.With(can(rbac.VMRead)).Get("/", admin.VMStorage.GetVMBackupPolicy)
+								r.With(can(rbac.VMWrite)).Put("/", admin.VMStorage.SetVMBackupPolicy)
+								r.With(can(rbac.VMWrite)).Delete("/", admin.VMStorage.DeleteVMBackupPolicy)
+							})
+							r.Route("/backups", func(r chi.Router) {
+								r.With(can(rbac.VMRead)).Get("/", admin.VMStorage.GetVMBackups)
+								r.Route("/{backup}", func(r chi.Router) {
+									r.With(can(rbac.VMRead)).Get("/", admin.VMStorage.GetVMBackup)
+									r.With(can(rbac.VMWrite)).Post("/restore", admin.VMStorage.RestoreVMBackup)
+									r.With(can(rbac.VMWrite)).Delete("/", admin.VMStorage.DeleteVMBackup)
+								})
 							})
-							//r.Patch("/", admin.UpdateVM)
-							r.Delete("/", admin.DeleteVM)
 						})
 					})
 				})
 			})
+			r.With(can(rbac.VMWrite)).Post("/virtual_machines", admin.CreatePlacedVM)
+
+			r.Route("/tasks", func(r chi.Router) {
+				r.Use(can(rbac.TaskRead))
+				r.Get("/", admin.GetTasks)
+				r.Get("/{task}", admin.GetTask)
+			})
 			r.Route("/users", func(r chi.Router) {
-				r.Post("/", admin.CreateUser)
-				r.Get("/", admin.GetUsers)
-				//r.Route("/{user}", func(r chi.Router) {
-				//r.Get("/", admin.GetUser)
-				//r.Patch("/", admin.UpdateUser)
-				//r.Delete("/", admin.DeleteUser)
+				r.With(can(rbac.UserWrite)).Post("/", admin.CreateUser)
+				r.With(can(rbac.UserRead)).Get("/", admin.GetUsers)
+				r.Route("/{user}", func(r chi.Router) {
+					//r.Get("/", admin.GetUser)
+					r.With(can(rbac.UserWrite)).Patch("/", admin.UpdateUser)
+					//r.Delete("/", admin.DeleteUser)
+					r.With(can(rbac.UserWrite)).Delete("/mfa", admin.ResetUserMFA)
+					r.With(can(rbac.UserWrite)).Delete("/sessions", admin.RevokeUserSessions)
+					r.Route("/quota", func(r chi.Router) {
+						r.With(can(rbac.UserRead)).Get("/", admin.GetUserQuota)
+						r.With(can(rbac.UserWrite)).Put("/", admin.SetUserQuota)
+						r.With(can(rbac.UserWrite)).Delete("/", admin.DeleteUserQuota)
+					})
+					r.Route("/roles", func(r chi.Router) {
+						r.With(can(rbac.RoleRead)).Get("/", admin.GetUserRoles)
+						r.With(can(rbac.RoleWrite)).Put("/", admin.SetUserRoles)
+					})
+				})
+			})
+			r.Route("/ip_pools", func(r chi.Router) {
+				r.With(can(rbac.IPAMRead)).Get("/", admin.GetIPPools)
+				r.With(can(rbac.IPAMWrite)).Post("/", admin.CreateIPPool)
+				r.Route("/{ip_pool}", func(r chi.Router) {
+					r.With(can(rbac.IPAMRead)).Get("/", admin.GetIPPool)
+					r.With(can(rbac.IPAMWrite)).Patch("/", admin.UpdateIPPool)
+					r.With(can(rbac.IPAMWrite)).Delete("/", admin.DeleteIPPool)
+					r.With(can(rbac.IPAMRead)).Get("/utilization", admin.GetIPPoolUtilization)
+					r.Route("/addresses", func(r chi.Router) {
+						r.With(can(rbac.IPAMRead)).Get("/", admin.GetIPAddresses)
+						r.With(can(rbac.IPAMWrite)).Post("/", admin.AssignIPAddress)
+						r.With(can(rbac.IPAMWrite)).Delete("/{address}", admin.ReleaseIPAddress)
+					})
+					r.Route("/reservations", func(r chi.Router) {
+						r.With(can(rbac.IPAMRead)).Get("/", admin.GetIPReservations)
+						r.With(can(rbac.IPAMWrite)).Post("/", admin.CreateIPReservation)
+						r.With(can(rbac.IPAMWrite)).Delete("/{reservation}", admin.DeleteIPReservation)
+					})
+				})
+			})
+			r.Route("/flavors", func(r chi.Router) {
+				r.With(can(rbac.FlavorRead)).Get("/", admin.GetFlavors)
+				r.With(can(rbac.FlavorWrite)).Post("/", admin.CreateFlavor)
+				r.Route("/{flavor}", func(r chi.Router) {
+					r.With(can(rbac.FlavorRead)).Get("/", admin.GetFlavor)
+					r.With(can(rbac.FlavorWrite)).Patch("/", admin.UpdateFlavor)
+					r.With(can(rbac.FlavorWrite)).Delete("/", admin.DeleteFlavor)
+				})
+			})
+			r.Route("/images", func(r chi.Router) {
+				r.With(can(rbac.ImageRead)).Get("/", admin.GetImages)
+				r.With(can(rbac.ImageWrite)).Post("/", admin.CreateImage)
+				r.Route("/{image}", func(r chi.Router) {
+					r.With(can(rbac.ImageRead)).Get("/", admin.GetImage)
+					r.With(can(rbac.ImageWrite)).Patch("/", admin.UpdateImage)
+					r.With(can(rbac.ImageWrite)).Delete("/", admin.DeleteImage)
+					r.With(can(rbac.ImageWrite)).Post("/deprecation", admin.DeprecateImage)
+					r.With(can(rbac.ImageWrite)).Delete("/deprecation", admin.UndeprecateImage)
+					r.With(can(rbac.ImageRead)).Get("/locations", admin.GetImageLocations)
+					r.With(can(rbac.ImageWrite)).Post("/sync", admin.SyncImage)
+				})
+			})
+			r.Route("/projects", func(r chi.Router) {
+				r.With(can(rbac.ProjectRead)).Get("/", admin.GetProjects)
+				r.Route("/{project}", func(r chi.Router) {
+					r.With(can(rbac.ProjectRead)).Get("/", admin.GetProject)
+					r.With(can(rbac.ProjectRead)).Get("/members", admin.GetProjectMembers)
+					r.With(can(rbac.ProjectWrite)).Put("/members/{user}", admin.SetProjectMember)
+					r.With(can(rbac.ProjectWrite)).Delete("/members/{user}", admin.RemoveProjectMember)
+				})
+			})
+			r.Route("/roles", func(r chi.Router) {
+				r.With(can(rbac.RoleRead)).Get("/", admin.GetRoles)
+				r.With(can(rbac.RoleWrite)).Post("/", admin.CreateRole)
+				r.Route("/{role}", func(r chi.Router) {
+					r.With(can(rbac.RoleRead)).Get("/", admin.GetRole)
+					r.With(can(rbac.RoleWrite)).Patch("/", admin.UpdateRole)
+					r.With(can(rbac.RoleWrite)).Delete("/", admin.DeleteRole)
+				})
 			})
 		})
 	})
@@ -110,19 +268,82 @@ func Service() *chi.Mux {
 		r.Use(middleware.UserContext)
 
 		r.Get("/me", users.GetSelf)
+		r.Get("/me/quota", users.GetQuota)
 		//r.Patch("/me", users.UpdateSelf)
+		r.Route("/me/api_keys", func(r chi.Router) {
+			r.Get("/", users.GetAPIKeys)
+			r.Post("/", users.CreateAPIKey)
+			r.Delete("/{api_key}", users.RevokeAPIKey)
+		})
+		r.Route("/me/sessions", func(r chi.Router) {
+			r.Get("/", users.GetSessions)
+			r.Delete("/{session}", users.RevokeSession)
+		})
+		r.Route("/me/mfa", func(r chi.Router) {
+			r.Post("/", users.EnrollMFA)
+			r.Post("/confirm", users.ConfirmMFA)
+			r.Delete("/", users.DisableMFA)
+		})
+		r.Route("/projects", func(r chi.Router) {
+			r.With(can(rbac.SelfProjectRead)).Get("/", users.GetProjects)
+			r.With(can(rbac.SelfProjectWrite)).Post("/", users.CreateProject)
+			r.Route("/{project}", func(r chi.Router) {
+				r.With(can(rbac.SelfProjectRead)).Get("/", users.GetProject)
+				r.With(can(rbac.SelfProjectWrite)).Patch("/", users.UpdateProject)
+				r.With(can(rbac.SelfProjectWrite)).Delete("/", users.DeleteProject)
+				r.With(can(rbac.SelfProjectRead)).Get("/members", users.GetProjectMembers)
+				r.With(can(rbac.SelfProjectWrite)).Put("/members/{user}", users.SetProjectMember)
+				r.With(can(rbac.SelfProjectWrite)).Delete("/members/{user}", users.RemoveProjectMember)
+			})
+		})
+		r.With(can(rbac.SelfVMRead)).Get("/flavors", users.GetFlavors)
+		r.With(can(rbac.SelfVMRead)).Get("/images", users.GetImages)
 		r.Route("/virtual_machines", func(r chi.Router) {
-			r.Get("/", users.GetVMs)
+			r.With(can(rbac.SelfVMRead)).Get("/", users.GetVMs)
 			r.Route("/{virtual_machine}", func(r chi.Router) {
-				r.Get("/", users.GetVM)
-				r.Get("/console", users.GetVMConsole)
+				r.With(can(rbac.SelfVMRead)).Get("/", users.GetVM)
+				r.With(can(rbac.SelfVMConsole)).Get("/console", users.GetVMConsole)
 				r.Route("/state", func(r chi.Router) {
-					r.Get("/", users.GetVMState)
-					r.Patch("/", users.SetVMState)
+					r.With(can(rbac.SelfVMRead)).Get("/", users.GetVMState)
+					r.With(can(rbac.SelfVMState)).Patch("/", users.SetVMState)
+				})
+				r.With(can(rbac.SelfVMWrite)).Patch("/", users.UpdateVM)
+				r.With(can(rbac.SelfVMWrite)).Post("/transfer", users.TransferVM)
+				r.Route("/disks", func(r chi.Router) {
+					r.With(can(rbac.SelfVMRead)).Get("/", users.VMStorage.GetVMDisks)
+					r.With(can(rbac.SelfVMWrite)).Post("/", users.VMStorage.AttachVMDisk)
+					r.With(can(rbac.SelfVMWrite)).Patch("/{disk}", users.VMStorage.ResizeVMDisk)
+					r.With(can(rbac.SelfVMWrite)).Delete("/{disk}", users.VMStorage.DetachVMDisk)
+				})
+				r.Route("/snapshots", func(r chi.Router) {
+					r.With(can(rbac.SelfVMRead)).Get("/", users.VMStorage.GetVMSnapshots)
+					r.With(can(rbac.SelfVMWrite)).Post("/", users.VMStorage.CreateVMSnapshot)
+					r.Route("/{snapshot}", func(r chi.Router) {
+						r.With(can(rbac.SelfVMRead)).Get("/", users.VMStorage.GetVMSnapshot)
+						r.With(can(rbac.SelfVMWrite)).Post("/revert", users.VMStorage.RevertVMSnapshot)
+						r.With(can(rbac.SelfVMWrite)).Delete("/", users.VMStorage.DeleteVMSnapshot)
+					})
+				})
+				r.Route("/backup_policy", func(r chi.Router) {
+					r.With(can(rbac.SelfVMRead)).Get("/", users.VMStorage.GetVMBackupPolicy)
+					r.With(can(rbac.SelfVMWrite)).Put("/", users.VMStorage.SetVMBackupPolicy)
+					r.With(can(rbac.SelfVMWrite)).Delete("/", users.VMStorage.DeleteVMBackupPolicy)
+				})
+				r.Route("/backups", func(r chi.Router) {
+					r.With(can(rbac.SelfVMRead)).Get("/", users.VMStorage.GetVMBackups)
+					r.Route("/{backup}", func(r chi.Router) {
+						r.With(can(rbac.SelfVMRead)).Get("/", users.VMStorage.GetVMBackup)
+						r.With(can(rbac.SelfVMWrite)).Post("/restore", users.VMStorage.RestoreVMBackup)
+						r.With(can(rbac.SelfVMWrite)).Delete("/", users.VMStorage.DeleteVMBackup)
+					})
 				})
-				//	r.Patch("/", users.UpdateVM)
 			})
 		})
+		r.Route("/tasks", func(r chi.Router) {
+			r.Use(can(rbac.SelfTaskRead))
+			r.Get("/", users.GetTasks)
+			r.Get("/{task}", users.GetTask)
+		})
 
 		r.Post("/logout", routes.Logout)
 	})
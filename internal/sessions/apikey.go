@@ -0,0 +1,168 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/tokens"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// API keys use the same token scheme as sessions, but a different version so
+// we know which table to look them up in
+const apiKeyVersion = "k1"
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// A long-lived credential for automation
+type APIKey struct {
+	ID       uuid.UUID  `json:"id" db:"id"`
+	Owner    uuid.UUID  `json:"owner" db:"owner"`
+	Name     string     `json:"name" db:"name"`
+	Version  string     `json:"-" db:"token_version"`
+	Public   string     `json:"public" db:"token_public"`
+	Secret   string     `json:"-" db:"token_secret"`
+	Salt     string     `json:"-" db:"token_salt"`
+	Created  time.Time  `json:"created" db:"created"`
+	Expires  *time.Time `json:"expires" db:"expires"`
+	LastUsed *time.Time `json:"last_used" db:"last_used"`
+}
+
+func (k APIKey) isExpired() bool {
+	return k.Expires != nil && time.Now().After(*k.Expires)
+}
+
+// NewAPIKey creates an API key for a profile, the returned token is the only
+// time the secret is available in the clear
+func NewAPIKey(ctx context.Context, owner uuid.UUID, name string, expires *time.Time) (APIKey, tokens.Token, error) {
+	public, secret, salt, err := generateStrings([]int{64, 64, 32})
+
+	if err != nil {
+		return APIKey{}, tokens.Token{}, err
+	}
+
+	key := APIKey{
+		ID:      uuid.New(),
+		Owner:   owner,
+		Name:    name,
+		Version: apiKeyVersion,
+		Public:  public,
+		Secret:  hashSecret(secret, salt),
+		Salt:    salt,
+		Expires: expires,
+	}
+
+	if err := db.Pool.QueryRow(
+		ctx,
+		"INSERT INTO api_keys (id, owner, name, token_version, token_public, token_secret, token_salt, expires) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING created",
+		key.ID,      // id
+		key.Owner,   // owner
+		key.Name,    // name
+		key.Version, // token_version
+		key.Public,  // token_public
+		key.Secret,  // token_secret
+		key.Salt,    // token_salt
+		key.Expires, // expires
+	).Scan(&key.Created); err != nil {
+		return APIKey{}, tokens.Token{}, err
+	}
+
+	return key, tokens.Token{
+		Version: apiKeyVersion,
+		Public:  public,
+		Secret:  base64.URLEncoding.EncodeToString([]byte(secret)),
+		Salt:    salt,
+	}, nil
+}
+
+// ListAPIKeys lists the API keys of a profile
+func ListAPIKeys(ctx context.Context, owner uuid.UUID) ([]APIKey, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM api_keys WHERE owner = $1 ORDER BY created", owner)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading api keys: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[APIKey])
+}
+
+// RevokeAPIKey deletes an API key of a profile
+func RevokeAPIKey(ctx context.Context, owner uuid.UUID, id uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx, "DELETE FROM api_keys WHERE id = $1 AND owner = $2", id, owner)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// ValidateAPIKey takes a token and finds its API key. Returns the owner if the
+// key is valid and records that it was used.
+func ValidateAPIKey(ctx context.Context, incomingToken tokens.Token) (uuid.UUID, bool) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM api_keys WHERE token_public = $1", incomingToken.Public)
+	if err != nil {
+		return uuid.Nil, false
+	}
+
+	key, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[APIKey])
+	if err != nil {
+		return uuid.Nil, false // No such key, unauthenticated
+	}
+
+	if !checkSecret(key.Secret, incomingToken) {
+		return uuid.Nil, false // Invalid key, unauthenticated
+	}
+
+	if key.isExpired() {
+		return uuid.Nil, false // Expired key, unauthenticated
+	}
+
+	// Not being able to record the usage shouldn't lock anyone out
+	db.Pool.Exec(ctx, "UPDATE api_keys SET last_used = now() WHERE id = $1", key.ID)
+
+	return key.Owner, true
+}
+
+// Authenticate validates a session token or an API key, and returns the
+// owner of it
+func Authenticate(ctx context.Context, incomingToken tokens.Token) (uuid.UUID, bool) {
+	if incomingToken.Version == apiKeyVersion {
+		return ValidateAPIKey(ctx, incomingToken)
+	}
+
+	session, ok := validateSession(ctx, incomingToken)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	// Same as API keys, a failed write here isn't worth a 401
+	db.Pool.Exec(ctx, "UPDATE sessions SET last_used = now() WHERE token_public = $1", session.Public)
+
+	return session.Owner, true
+}
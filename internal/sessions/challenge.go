@@ -0,0 +1,124 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/internal/tokens"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/google/uuid"
+)
+
+// MFA challenges are handed out by /login when the password is correct but a
+// second factor is still needed
+const challengeVersion = "m1"
+
+const (
+	challengeExpirey     = 5 * time.Minute
+	challengeMaxAttempts = 5
+)
+
+var ErrInvalidChallenge = errors.New("invalid or expired challenge")
+
+type Challenge struct {
+	Owner    uuid.UUID  `db:"owner"`
+	Version  string     `db:"token_version"`
+	Public   string     `db:"token_public"`
+	Secret   string     `db:"token_secret"`
+	Salt     string     `db:"token_salt"`
+	Attempts int        `db:"attempts"`
+	Claimed  *time.Time `db:"claimed"`
+	Created  time.Time  `db:"created"`
+	Expires  time.Time  `db:"expires"`
+}
+
+// NewChallenge creates a short-lived MFA challenge for a profile
+func NewChallenge(ctx context.Context, owner uuid.UUID) (tokens.Token, error) {
+	public, secret, salt, err := generateStrings([]int{64, 64, 32})
+
+	if err != nil {
+		return tokens.Token{}, err
+	}
+
+	if _, err := db.Pool.Exec(
+		ctx,
+		"INSERT INTO mfa_challenges (owner, token_version, token_public, token_secret, token_salt, expires) VALUES ($1, $2, $3, $4, $5, $6)",
+		owner,                            // owner
+		challengeVersion,                 // token_version
+		public,                           // token_public
+		hashSecret(secret, salt),         // token_secret
+		salt,                             // token_salt
+		time.Now().Add(challengeExpirey), // expires
+	); err != nil {
+		return tokens.Token{}, err
+	}
+
+	return tokens.Token{
+		Version: challengeVersion,
+		Public:  public,
+		Secret:  base64.URLEncoding.EncodeToString([]byte(secret)),
+		Salt:    salt,
+	}, nil
+}
+
+// ClaimChallenge returns the owner of a valid challenge and holds it until it
+// is deleted or released, so it can't be exchanged for two sessions at once.
+// Every call counts as an attempt, so a challenge can't be used to brute force
+// codes.
+func ClaimChallenge(ctx context.Context, incomingToken tokens.Token) (uuid.UUID, error) {
+	var challenge Challenge
+
+	rows, _ := db.Pool.Query(ctx,
+		"UPDATE mfa_challenges SET attempts = attempts + 1, claimed = now() WHERE token_public = $1 AND claimed IS NULL RETURNING *",
+		incomingToken.Public,
+	)
+
+	if err := pgxscan.ScanOne(&challenge, rows); err != nil {
+		return uuid.Nil, ErrInvalidChallenge
+	}
+
+	if incomingToken.Version != challengeVersion ||
+		!checkSecret(challenge.Secret, incomingToken) ||
+		time.Now().After(challenge.Expires) ||
+		challenge.Attempts > challengeMaxAttempts {
+		ReleaseChallenge(ctx, incomingToken)
+		return uuid.Nil, ErrInvalidChallenge
+	}
+
+	return challenge.Owner, nil
+}
+
+// ReleaseChallenge lets a claimed challenge be tried again
+func ReleaseChallenge(ctx context.Context, incomingToken tokens.Token) error {
+	_, err := db.Pool.Exec(ctx, "UPDATE mfa_challenges SET claimed = NULL WHERE token_public = $1", incomingToken.Public)
+
+	return err
+}
+
+// DeleteChallenge removes a challenge once it has been exchanged for a session
+func DeleteChallenge(ctx context.Context, incomingToken tokens.Token) error {
+	_, err := db.Pool.Exec(ctx, "DELETE FROM mfa_challenges WHERE token_public = $1", incomingToken.Public)
+
+	return err
+}
@@ -20,14 +20,64 @@ package sessions
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/BasedDevelopment/eve/internal/db"
 	"github.com/BasedDevelopment/eve/internal/tokens"
+	"github.com/google/uuid"
 )
 
+var ErrSessionNotFound = errors.New("session not found")
+
 // Delete removes a session from the database (logout)
 func Delete(ctx context.Context, token tokens.Token) error {
 	_, err := db.Pool.Exec(ctx, "DELETE FROM sessions WHERE token_public = $1", token.Public)
 
 	return err
 }
+
+// Revoke removes a session of a profile by its public token
+func Revoke(ctx context.Context, owner uuid.UUID, public string) error {
+	tag, err := db.Pool.Exec(ctx, "DELETE FROM sessions WHERE token_public = $1 AND owner = $2", public, owner)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll removes every session, API key and pending MFA challenge of a
+// profile, and returns how many sessions and API keys were removed
+func RevokeAll(ctx context.Context, owner uuid.UUID) (int64, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "DELETE FROM sessions WHERE owner = $1", owner)
+	if err != nil {
+		return 0, fmt.Errorf("Error deleting sessions: %w", err)
+	}
+
+	// A key would keep working for whoever the sessions were revoked from
+	keys, err := tx.Exec(ctx, "DELETE FROM api_keys WHERE owner = $1", owner)
+	if err != nil {
+		return 0, fmt.Errorf("Error deleting api keys: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM mfa_challenges WHERE owner = $1", owner); err != nil {
+		return 0, fmt.Errorf("Error deleting mfa challenges: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected() + keys.RowsAffected(), nil
+}
@@ -23,7 +23,9 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/BasedDevelopment/eve/internal/profile"
 	"github.com/BasedDevelopment/eve/internal/tokens"
@@ -35,6 +37,9 @@ const version = "v1"
 
 var expirey = 24 * time.Hour
 
+// Longest user agent we keep, anything past it is cut off
+const maxUserAgent = 512
+
 func prngString(size int) string {
 	b := make([]byte, size/2)
 	_, err := rand.Read(b)
@@ -46,6 +51,31 @@ func prngString(size int) string {
 	return fmt.Sprintf("%x", b)
 }
 
+// Salt and hash a secret, and return it in hex as it is stored in the database
+func hashSecret(secret string, salt string) string {
+	buf := []byte(secret + salt) // Append the salt to the secret
+	saltedSecret := make([]byte, 64)
+	sha3.ShakeSum256(saltedSecret, buf) // Hash the string with the combined secret and salt
+
+	return fmt.Sprintf("%x", saltedSecret)
+}
+
+// Cut s down to at most max bytes of valid UTF-8 without splitting a rune,
+// the database refuses anything else
+func truncate(s string, max int) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.ReplaceAll(s, "\x00", "")
+	if len(s) <= max {
+		return s
+	}
+
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+
+	return s[:max]
+}
+
 func generateStrings(bits []int) (a, b, c string, err error) {
 	a = prngString(bits[0])
 	b = prngString(bits[1])
@@ -54,8 +84,9 @@ func generateStrings(bits []int) (a, b, c string, err error) {
 	return a, b, c, err
 }
 
-// New creates a new authentication session in the database (login)
-func NewSession(ctx context.Context, user profile.Profile) (tokens.Token, error) {
+// New creates a new authentication session in the database (login), the client
+// IP and user agent are recorded so the user can tell their sessions apart
+func NewSession(ctx context.Context, user profile.Profile, clientIP string, userAgent string) (tokens.Token, error) {
 	// Generate three pseudo-random numbers (in a string)
 	public, secret, salt, err := generateStrings([]int{64, 64, 32})
 
@@ -63,20 +94,18 @@ func NewSession(ctx context.Context, user profile.Profile) (tokens.Token, error)
 		return tokens.Token{}, err
 	}
 
-	// Salt the token
-	buf := []byte(secret + salt) // Append the salt to the secret
-	saltedSecret := make([]byte, 64)
-	sha3.ShakeSum256(saltedSecret, buf) // Hash the string with the combined secret and salt
-
 	// This is what we store in the database
 	session := Session{
 		Owner:   user.ID,
 		Version: version,
 		Public:  public,
-		Secret:  fmt.Sprintf("%x", saltedSecret),
+		Secret:  hashSecret(secret, salt),
 		Salt:    salt,
 		Created: time.Now(),
 		Expires: time.Now().Add(expirey),
+
+		ClientIP:  clientIP,
+		UserAgent: truncate(userAgent, maxUserAgent),
 	}
 
 	// Push the session to the database
@@ -4,8 +4,10 @@
 package sessions
 
 import (
+	"encoding/base64"
 	"testing"
 
+	"github.com/BasedDevelopment/eve/internal/tokens"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,3 +25,38 @@ func TestPrngString(t *testing.T) {
 	assert.Len(t, str3, 64)
 	assert.Len(t, str4, 64)
 }
+
+func TestCheckSecret(t *testing.T) {
+	secret := prngString(64)
+	salt := prngString(32)
+	stored := hashSecret(secret, salt)
+
+	tok := tokens.Token{
+		Version: apiKeyVersion,
+		Public:  prngString(64),
+		Secret:  base64.URLEncoding.EncodeToString([]byte(secret)),
+		Salt:    salt,
+	}
+	assert.True(t, checkSecret(stored, tok))
+
+	tok.Salt = prngString(32)
+	assert.False(t, checkSecret(stored, tok))
+
+	tok.Salt = salt
+	tok.Secret = "not base64!"
+	assert.False(t, checkSecret(stored, tok))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "agent", truncate("agent", 10))
+	assert.Equal(t, "age", truncate("agent", 3))
+
+	// A rune that doesn't fit is dropped whole
+	assert.Equal(t, "ab", truncate("abé", 3))
+	assert.Equal(t, "abé", truncate("abé", 4))
+	assert.Equal(t, "", truncate("日本", 2))
+
+	// Invalid bytes and NULs never reach the database
+	assert.Equal(t, "ab", truncate("a\xffb", 10))
+	assert.Equal(t, "ab", truncate("a\x00b", 10))
+}
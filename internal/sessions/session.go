@@ -20,22 +20,27 @@ package sessions
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/BasedDevelopment/eve/internal/db"
 	"github.com/BasedDevelopment/eve/internal/tokens"
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type Session struct {
-	Owner   uuid.UUID `db:"owner"`
-	Version string    `db:"token_version"`
-	Public  string    `db:"token_public"`
-	Secret  string    `db:"token_secret"`
-	Salt    string    `db:"token_salt"`
-	Created time.Time `db:"created"`
-	Expires time.Time `db:"expires"`
+	Owner     uuid.UUID  `json:"owner" db:"owner"`
+	Version   string     `json:"-" db:"token_version"`
+	Public    string     `json:"public" db:"token_public"`
+	Secret    string     `json:"-" db:"token_secret"`
+	Salt      string     `json:"-" db:"token_salt"`
+	Created   time.Time  `json:"created" db:"created"`
+	Expires   time.Time  `json:"expires" db:"expires"`
+	ClientIP  string     `json:"client_ip" db:"client_ip"`
+	UserAgent string     `json:"user_agent" db:"user_agent"`
+	LastUsed  *time.Time `json:"last_used" db:"last_used"`
 }
 
 // push pushes a Session to the database
@@ -45,14 +50,16 @@ func (s Session) push(ctx context.Context) error {
 
 	_, err := db.Pool.Exec(
 		ctx,
-		"INSERT INTO sessions (owner, token_version, token_public, token_secret, token_salt, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-		s.Owner,   // owner
-		s.Version, // token_version
-		s.Public,  // token_public
-		s.Secret,  // token_secret
-		s.Salt,    // token_salt
-		s.Created, // created_at
-		s.Expires, // expires
+		"INSERT INTO sessions (owner, token_version, token_public, token_secret, token_salt, created, expires, client_ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		s.Owner,     // owner
+		s.Version,   // token_version
+		s.Public,    // token_public
+		s.Secret,    // token_secret
+		s.Salt,      // token_salt
+		s.Created,   // created_at
+		s.Expires,   // expires
+		s.ClientIP,  // client_ip
+		s.UserAgent, // user_agent
 	)
 
 	return err
@@ -72,6 +79,17 @@ func GetSession(ctx context.Context, token tokens.Token) (Session, error) {
 	return session, nil
 }
 
+// ListSessions lists the unexpired sessions of a profile, most recently used
+// first
+func ListSessions(ctx context.Context, owner uuid.UUID) ([]Session, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM sessions WHERE owner = $1 AND expires > now() ORDER BY COALESCE(last_used, created) DESC", owner)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading sessions: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Session])
+}
+
 func (s Session) isExpired() bool {
 	return time.Now().After(s.Expires)
 }
@@ -0,0 +1,174 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// A long-running operation, persisted so clients can poll for it
+type Task struct {
+	ID       uuid.UUID  `json:"id" db:"id"`
+	Owner    uuid.UUID  `json:"owner" db:"profile_id"`
+	Type     string     `json:"type" db:"type"`
+	Status   Status     `json:"status" db:"status"`
+	Resource *uuid.UUID `json:"resource" db:"resource_id"`
+	Error    string     `json:"error" db:"error"`
+	Progress int        `json:"progress" db:"progress"` // percent done, for tasks that report it
+	Created  time.Time  `json:"created" db:"created"`
+	Started  *time.Time `json:"started" db:"started"`
+	Finished *time.Time `json:"finished" db:"finished"`
+	Updated  time.Time  `json:"updated" db:"updated"`
+}
+
+// Where tasks are kept, the database unless a test swaps it
+var store taskStore = dbStore{}
+
+type taskStore interface {
+	insert(ctx context.Context, t *Task) error
+	get(ctx context.Context, id uuid.UUID) (*Task, error)
+	start(ctx context.Context, t *Task) error
+	finish(ctx context.Context, t *Task, status Status, errStr string, resource *uuid.UUID) error
+}
+
+type dbStore struct{}
+
+func (t *Task) push(ctx context.Context) error {
+	return store.insert(ctx, t)
+}
+
+func (dbStore) insert(ctx context.Context, t *Task) error {
+	_, err := db.Pool.Exec(
+		ctx,
+		"INSERT INTO tasks (id, profile_id, type, status, resource_id) VALUES ($1, $2, $3, $4, $5)",
+		t.ID,       // id
+		t.Owner,    // profile_id
+		t.Type,     // type
+		t.Status,   // status
+		t.Resource, // resource_id
+	)
+
+	return err
+}
+
+// Mark the task as running
+func (t *Task) start(ctx context.Context) error {
+	return store.start(ctx, t)
+}
+
+func (dbStore) start(ctx context.Context, t *Task) error {
+	return db.Pool.QueryRow(ctx,
+		"UPDATE tasks SET status = $2, started = now(), updated = now() WHERE id = $1 RETURNING status, started, updated",
+		t.ID,
+		StatusRunning,
+	).Scan(&t.Status, &t.Started, &t.Updated)
+}
+
+// Mark the task as succeeded or failed, depending on err
+func (t *Task) finish(ctx context.Context, resource uuid.UUID, taskErr error) error {
+	status := StatusSucceeded
+	errStr := ""
+	if taskErr != nil {
+		status = StatusFailed
+		errStr = taskErr.Error()
+	}
+
+	// Keep the resource the task was created with if it didn't return one
+	res := t.Resource
+	if resource != uuid.Nil {
+		res = &resource
+	}
+
+	return store.finish(ctx, t, status, errStr, res)
+}
+
+func (dbStore) finish(ctx context.Context, t *Task, status Status, errStr string, res *uuid.UUID) error {
+	return db.Pool.QueryRow(ctx,
+		"UPDATE tasks SET status = $2, error = $3, resource_id = $4, progress = CASE WHEN $5 THEN 100 ELSE progress END, finished = now(), updated = now() WHERE id = $1 RETURNING status, error, resource_id, progress, finished, updated",
+		t.ID,
+		status,
+		errStr,
+		res,
+		status == StatusSucceeded,
+	).Scan(&t.Status, &t.Error, &t.Resource, &t.Progress, &t.Finished, &t.Updated)
+}
+
+// Report how far along a running task is, in percent
+func (t *Task) SetProgress(ctx context.Context, percent int) error {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	return db.Pool.QueryRow(ctx,
+		"UPDATE tasks SET progress = $2, updated = now() WHERE id = $1 RETURNING progress, updated",
+		t.ID,
+		percent,
+	).Scan(&t.Progress, &t.Updated)
+}
+
+// Get a task by its ID
+func Get(ctx context.Context, id uuid.UUID) (*Task, error) {
+	return store.get(ctx, id)
+}
+
+func (dbStore) get(ctx context.Context, id uuid.UUID) (*Task, error) {
+	rows, err := db.Pool.Query(ctx, "SELECT * FROM tasks WHERE id = $1", id)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading task: %w", err)
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Task])
+}
+
+// List the tasks of a profile, or of everyone if owner is uuid.Nil
+func List(ctx context.Context, owner uuid.UUID) ([]Task, error) {
+	var (
+		rows pgx.Rows
+		err  error
+	)
+
+	if owner == uuid.Nil {
+		rows, err = db.Pool.Query(ctx, "SELECT * FROM tasks ORDER BY created DESC LIMIT 500")
+	} else {
+		rows, err = db.Pool.Query(ctx, "SELECT * FROM tasks WHERE profile_id = $1 ORDER BY created DESC LIMIT 500", owner)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error reading tasks: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Task])
+}
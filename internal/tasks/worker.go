@@ -0,0 +1,188 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// The work a task does. It returns the ID of the resource it created or acted
+// on, if any.
+type Func func(ctx context.Context, t *Task) (uuid.UUID, error)
+
+type job struct {
+	task *Task
+	fn   Func
+}
+
+const queueSize = 256
+
+var (
+	ErrQueueFull = errors.New("task queue is full")
+	ErrStopped   = errors.New("task workers are stopped")
+)
+
+var (
+	queue   chan job
+	mutex   sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+)
+
+// Start the task workers. Tasks that were still queued or running when eve
+// last stopped can't be resumed, so they are failed first.
+func Init(workers int) {
+	if _, err := db.Pool.Exec(
+		context.Background(),
+		"UPDATE tasks SET status = $1, error = 'interrupted by eve restart', finished = now(), updated = now() WHERE status IN ($2, $3)",
+		StatusFailed,
+		StatusQueued,
+		StatusRunning,
+	); err != nil {
+		log.Error().Err(err).Msg("Failed to fail interrupted tasks")
+	}
+
+	queue = make(chan job, queueSize)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+
+	log.Info().Int("workers", workers).Msg("Task workers started")
+}
+
+// Stop accepting new tasks and wait for the queued ones to finish
+func Stop(ctx context.Context) error {
+	mutex.Lock()
+	if !stopped {
+		stopped = true
+		close(queue)
+	}
+	mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Persist a new task and queue it for the workers. The resource may be
+// uuid.Nil if the task creates it.
+func Submit(ctx context.Context, owner uuid.UUID, kind string, resource uuid.UUID, fn Func) (*Task, error) {
+	t := &Task{
+		ID:     uuid.New(),
+		Owner:  owner,
+		Type:   kind,
+		Status: StatusQueued,
+	}
+	if resource != uuid.Nil {
+		t.Resource = &resource
+	}
+
+	if err := t.push(ctx); err != nil {
+		return nil, err
+	}
+
+	// The task is returned to the client right away, so read it back to
+	// get the timestamps from the database
+	t, err := Get(ctx, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var submitErr error
+	if stopped {
+		submitErr = ErrStopped
+	} else {
+		// The worker updates its task as it runs while the caller is still
+		// writing this one out, so each gets its own
+		ret := *t
+		select {
+		case queue <- job{task: t, fn: fn}:
+			return &ret, nil
+		default:
+			submitErr = ErrQueueFull
+		}
+	}
+
+	if err := t.finish(ctx, uuid.Nil, submitErr); err != nil {
+		log.Error().Err(err).Str("task", t.ID.String()).Msg("Failed to update task")
+	}
+	return nil, submitErr
+}
+
+func worker() {
+	defer wg.Done()
+
+	for j := range queue {
+		run(j)
+	}
+}
+
+func run(j job) {
+	ctx := context.Background()
+	t := j.task
+
+	logger := log.With().
+		Str("task", t.ID.String()).
+		Str("type", t.Type).
+		Logger()
+
+	if err := t.start(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to start task")
+	}
+
+	resource, err := func() (resource uuid.UUID, err error) {
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				err = fmt.Errorf("panic: %v", rvr)
+			}
+		}()
+		return j.fn(ctx, t)
+	}()
+
+	if err != nil {
+		logger.Warn().Err(err).Msg("Task failed")
+	} else {
+		logger.Info().Msg("Task succeeded")
+	}
+
+	if err := t.finish(ctx, resource, err); err != nil {
+		logger.Error().Err(err).Msg("Failed to finish task")
+	}
+}
@@ -0,0 +1,204 @@
+//go:build !integration
+// +build !integration
+
+package tasks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// Keeps tasks in memory and remembers every status they went through
+type memStore struct {
+	mutex    sync.Mutex
+	tasks    map[uuid.UUID]Task
+	statuses map[uuid.UUID][]Status
+}
+
+func useMemStore(t *testing.T) *memStore {
+	m := &memStore{
+		tasks:    make(map[uuid.UUID]Task),
+		statuses: make(map[uuid.UUID][]Status),
+	}
+
+	old := store
+	store = m
+	t.Cleanup(func() { store = old })
+
+	return m
+}
+
+func (m *memStore) insert(ctx context.Context, t *Task) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t.Created = time.Now()
+	t.Updated = t.Created
+	m.tasks[t.ID] = *t
+	m.statuses[t.ID] = append(m.statuses[t.ID], t.Status)
+	return nil
+}
+
+func (m *memStore) get(ctx context.Context, id uuid.UUID) (*Task, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t, ok := m.tasks[id]
+	if !ok {
+		return nil, errors.New("no such task")
+	}
+	return &t, nil
+}
+
+func (m *memStore) start(ctx context.Context, t *Task) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	t.Status, t.Started, t.Updated = StatusRunning, &now, now
+	m.tasks[t.ID] = *t
+	m.statuses[t.ID] = append(m.statuses[t.ID], t.Status)
+	return nil
+}
+
+func (m *memStore) finish(ctx context.Context, t *Task, status Status, errStr string, resource *uuid.UUID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	t.Status, t.Error, t.Resource, t.Finished, t.Updated = status, errStr, resource, &now, now
+	if status == StatusSucceeded {
+		t.Progress = 100
+	}
+	m.tasks[t.ID] = *t
+	m.statuses[t.ID] = append(m.statuses[t.ID], t.Status)
+	return nil
+}
+
+func (m *memStore) history(id uuid.UUID) []Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.statuses[id]
+}
+
+// Queue without workers, jobs stay put until the test takes them
+func useQueue(t *testing.T, size int) {
+	oldQueue, oldStopped := queue, stopped
+	queue, stopped = make(chan job, size), false
+	t.Cleanup(func() { queue, stopped = oldQueue, oldStopped })
+}
+
+func noop(ctx context.Context, t *Task) (uuid.UUID, error) {
+	return uuid.Nil, nil
+}
+
+func TestSubmitQueued(t *testing.T) {
+	m := useMemStore(t)
+	useQueue(t, 1)
+
+	resource := uuid.New()
+	task, err := Submit(context.Background(), uuid.New(), "vm.start", resource, noop)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusQueued, task.Status)
+	assert.Equal(t, &resource, task.Resource)
+	assert.False(t, task.Created.IsZero(), "timestamps are read back")
+
+	// The worker runs its own copy, the returned task doesn't change under
+	// the caller
+	j := <-queue
+	assert.NotSame(t, task, j.task)
+	run(j)
+	assert.Equal(t, StatusQueued, task.Status)
+	assert.Nil(t, task.Started)
+	assert.Equal(t, []Status{StatusQueued, StatusRunning, StatusSucceeded}, m.history(task.ID))
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	m := useMemStore(t)
+	useQueue(t, 1)
+
+	_, err := Submit(context.Background(), uuid.New(), "vm.start", uuid.Nil, noop)
+	assert.NoError(t, err)
+
+	task, err := Submit(context.Background(), uuid.New(), "vm.stop", uuid.Nil, noop)
+	assert.ErrorIs(t, err, ErrQueueFull)
+	assert.Nil(t, task)
+	assert.Len(t, queue, 1)
+
+	// It was recorded before it could be queued, so it is failed for good
+	var failed []Task
+	for _, stored := range m.tasks {
+		if stored.Type == "vm.stop" {
+			failed = append(failed, stored)
+		}
+	}
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, StatusFailed, failed[0].Status)
+		assert.Equal(t, ErrQueueFull.Error(), failed[0].Error)
+		assert.Equal(t, []Status{StatusQueued, StatusFailed}, m.history(failed[0].ID))
+	}
+}
+
+func TestSubmitStopped(t *testing.T) {
+	m := useMemStore(t)
+	useQueue(t, 1)
+	stopped = true
+
+	task, err := Submit(context.Background(), uuid.New(), "vm.start", uuid.Nil, noop)
+	assert.ErrorIs(t, err, ErrStopped)
+	assert.Nil(t, task)
+	assert.Len(t, queue, 0)
+
+	assert.Len(t, m.tasks, 1)
+	for _, stored := range m.tasks {
+		assert.Equal(t, StatusFailed, stored.Status)
+		assert.Equal(t, ErrStopped.Error(), stored.Error)
+	}
+}
+
+func TestRun(t *testing.T) {
+	m := useMemStore(t)
+
+	push := func(resource *uuid.UUID) *Task {
+		task := &Task{ID: uuid.New(), Owner: uuid.New(), Type: "vm.create", Status: StatusQueued, Resource: resource}
+		assert.NoError(t, task.push(context.Background()))
+		return task
+	}
+
+	created := uuid.New()
+	task := push(nil)
+	run(job{task: task, fn: func(ctx context.Context, t *Task) (uuid.UUID, error) {
+		return created, nil
+	}})
+	assert.Equal(t, StatusSucceeded, task.Status)
+	assert.Equal(t, &created, task.Resource)
+	assert.Equal(t, 100, task.Progress)
+	assert.NotNil(t, task.Started)
+	assert.NotNil(t, task.Finished)
+	assert.Equal(t, []Status{StatusQueued, StatusRunning, StatusSucceeded}, m.history(task.ID))
+
+	// A failed task keeps the resource it was submitted for
+	acted := uuid.New()
+	task = push(&acted)
+	run(job{task: task, fn: func(ctx context.Context, t *Task) (uuid.UUID, error) {
+		return uuid.Nil, errors.New("auto unreachable")
+	}})
+	assert.Equal(t, StatusFailed, task.Status)
+	assert.Equal(t, "auto unreachable", task.Error)
+	assert.Equal(t, &acted, task.Resource)
+	assert.Equal(t, 0, task.Progress)
+	assert.Equal(t, []Status{StatusQueued, StatusRunning, StatusFailed}, m.history(task.ID))
+
+	task = push(nil)
+	run(job{task: task, fn: func(ctx context.Context, t *Task) (uuid.UUID, error) {
+		panic("boom")
+	}})
+	assert.Equal(t, StatusFailed, task.Status)
+	assert.Equal(t, "panic: boom", task.Error)
+}
@@ -20,11 +20,19 @@ package util
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/netip"
+	"regexp"
+	"time"
 
+	"github.com/BasedDevelopment/eve/internal/backup"
+	"github.com/BasedDevelopment/eve/internal/cloudinit"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
 )
 
 type Validatable[T any] interface {
@@ -34,9 +42,45 @@ type Validatable[T any] interface {
 
 type Request interface {
 	UserCreateRequest |
+		UserUpdateRequest |
 		LoginRequest |
 		SetStateRequest |
-		VMCreateRequest
+		VMCreateRequest |
+		HVCreateRequest |
+		HVUpdateRequest |
+		VMUpdateRequest |
+		AdoptDomainRequest |
+		ResolveGhostRequest |
+		APIKeyCreateRequest |
+		LoginMFARequest |
+		MFACodeRequest |
+		RoleCreateRequest |
+		RoleUpdateRequest |
+		UserRolesRequest |
+		IPPoolCreateRequest |
+		IPPoolUpdateRequest |
+		IPAssignRequest |
+		IPReserveRequest |
+		NetworkCreateRequest |
+		NetworkUpdateRequest |
+		StorageCreateRequest |
+		StorageUpdateRequest |
+		DiskCreateRequest |
+		DiskResizeRequest |
+		SnapshotCreateRequest |
+		BackupPolicyRequest |
+		VMMigrateRequest |
+		EvacuateRequest |
+		VMPlaceRequest |
+		QuotaRequest |
+		ProjectCreateRequest |
+		ProjectUpdateRequest |
+		ProjectMemberRequest |
+		VMTransferRequest |
+		FlavorCreateRequest |
+		FlavorUpdateRequest |
+		ImageCreateRequest |
+		ImageUpdateRequest
 }
 
 type UserCreateRequest struct {
@@ -56,6 +100,23 @@ func (s UserCreateRequest) Validate() error {
 	)
 }
 
+type UserUpdateRequest struct {
+	Name     *string `json:"name"`
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+	Disabled *bool   `json:"disabled"`
+	IsAdmin  *bool   `json:"is_admin"`
+	Remarks  *string `json:"remarks"`
+}
+
+func (s UserUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.NilOrNotEmpty, validation.Length(2, 20)),
+		validation.Field(&s.Email, validation.NilOrNotEmpty, is.Email),
+		validation.Field(&s.Password, validation.NilOrNotEmpty, validation.Length(8, 0), is.PrintableASCII),
+	)
+}
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -68,6 +129,28 @@ func (s LoginRequest) Validate() error {
 	)
 }
 
+type LoginMFARequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+func (s LoginMFARequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Challenge, validation.Required),
+		validation.Field(&s.Code, validation.Required, validation.Length(6, 16)),
+	)
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (s MFACodeRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Code, validation.Required, validation.Length(6, 16)),
+	)
+}
+
 type SetStateRequest struct {
 	State string `json:"state"`
 }
@@ -79,33 +162,264 @@ func (s SetStateRequest) Validate() error {
 }
 
 type VMCreateRequest struct {
-	User       uuid.UUID `json:"user"`
-	Id         uuid.UUID `json:"id"`
-	Hostname   string    `json:"hostname"`
-	CPU        int       `json:"cpu"`
-	Memory     int       `json:"memory"`
-	Image      string    `json:"image"`
-	Cloud      bool      `json:"cloud"`
-	CloudImage string    `json:"cloud_image"`
-	OSVariant  string    `json:"os_variant"`
-	UserData   string    `json:"user_data"`
-	MetaData   string    `json:"meta_data"`
-	Disk       []struct {
-		Id   int    `json:"id"`
-		Size int    `json:"size"`
-		Path string `json:"path"`
-	} `json:"disk"`
-	Iface []struct {
+	User          uuid.UUID         `json:"user"`
+	Project       *uuid.UUID        `json:"project"` // the user's default project if unset
+	Id            uuid.UUID         `json:"id"`
+	Hostname      string            `json:"hostname"`
+	Flavor        *uuid.UUID        `json:"flavor"` // instead of cpu, memory and the root disk size
+	CPU           int               `json:"cpu"`
+	Memory        int               `json:"memory"`
+	ImageID       *uuid.UUID        `json:"image_id"` // from the catalog, instead of image, cloud_image and os_variant
+	Image         string            `json:"image"`
+	Cloud         bool              `json:"cloud"`
+	CloudImage    string            `json:"cloud_image"`
+	OSVariant     string            `json:"os_variant"`
+	UserData      string            `json:"user_data"`
+	MetaData      string            `json:"meta_data"`
+	NetworkConfig string            `json:"network_config"`
+	CloudInit     *CloudInitRequest `json:"cloud_init"` // user_data, meta_data and network_config are generated from it
+	Disk          []VMDisk          `json:"disk"`       // the first is the root disk
+	Iface         []struct {
 		Bridge string `json:"bridge"`
 		MAC    string `json:"mac"`
 	} `json:"iface"`
 }
 
+type VMDisk struct {
+	Id   int    `json:"id"`
+	Size int    `json:"size"` // GiB
+	Path string `json:"path"` // of the storage pool, any if unset
+}
+
 func (s VMCreateRequest) Validate() error {
 	return validation.ValidateStruct(&s,
 		validation.Field(&s.Hostname, validation.Required, is.Domain),
-		validation.Field(&s.CPU, validation.Required, validation.Min(1)),
-		validation.Field(&s.Memory, validation.Required, validation.Min(1)),
+		validation.Field(&s.User, notNilUUID),
+		validation.Field(&s.Project, notNilUUID),
+		validation.Field(&s.Flavor, notNilUUID),
+		validation.Field(&s.CPU, validation.When(s.Flavor == nil, validation.Required, validation.Min(1)).
+			Else(validation.Empty.Error("can't be set with a flavor"))),
+		validation.Field(&s.Memory, validation.When(s.Flavor == nil, validation.Required, validation.Min(1)).
+			Else(validation.Empty.Error("can't be set with a flavor"))),
+		validation.Field(&s.ImageID, notNilUUID),
+		validation.Field(&s.Image, validation.When(s.ImageID != nil, validation.Empty.Error("can't be set with an image_id"))),
+		validation.Field(&s.CloudImage, validation.When(s.ImageID != nil, validation.Empty.Error("can't be set with an image_id"))),
+		validation.Field(&s.OSVariant, validation.When(s.ImageID != nil, validation.Empty.Error("can't be set with an image_id"))),
+		validation.Field(&s.CloudInit, validation.When(!s.Cloud && s.ImageID == nil, validation.Nil.Error("only applies to cloud images"))),
+		validation.Field(&s.UserData, validation.When(s.CloudInit != nil, validation.Empty.Error("can't be set with cloud_init")).
+			Else(validation.By(validUserData))),
+		validation.Field(&s.MetaData, validation.When(s.CloudInit != nil, validation.Empty.Error("can't be set with cloud_init"))),
+		validation.Field(&s.NetworkConfig, validation.When(s.CloudInit != nil, validation.Empty.Error("can't be set with cloud_init"))),
+		validation.Field(&s.Disk, validation.By(func(interface{}) error {
+			for i, disk := range s.Disk {
+				// The flavor sizes the root disk
+				if i == 0 && s.Flavor != nil {
+					if disk.Size != 0 {
+						return errors.New("disk 0: size can't be set with a flavor")
+					}
+					continue
+				}
+				if disk.Size < 1 {
+					return fmt.Errorf("disk %d: size must be at least 1 GiB", i)
+				}
+			}
+			return nil
+		})),
+		validation.Field(&s.Iface, validation.By(func(interface{}) error {
+			for _, iface := range s.Iface {
+				if err := validation.Validate(iface.MAC, is.MAC); err != nil {
+					return fmt.Errorf("mac %q: %w", iface.MAC, err)
+				}
+			}
+			return nil
+		})),
+	)
+}
+
+type VMUpdateRequest struct {
+	Hostname *string    `json:"hostname"`
+	Flavor   *uuid.UUID `json:"flavor"` // resize to, instead of cpu and memory
+	CPU      *int       `json:"cpu"`
+	Memory   *int       `json:"memory"`
+	Remarks  *string    `json:"remarks"`
+}
+
+func (s VMUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Hostname, validation.NilOrNotEmpty, is.Domain),
+		validation.Field(&s.Flavor, notNilUUID),
+		validation.Field(&s.CPU, validation.NilOrNotEmpty, validation.Min(1),
+			validation.When(s.Flavor != nil, validation.Nil.Error("can't be set with a flavor"))),
+		validation.Field(&s.Memory, validation.NilOrNotEmpty, validation.Min(1),
+			validation.When(s.Flavor != nil, validation.Nil.Error("can't be set with a flavor"))),
+	)
+}
+
+// Names of the fields that are set in the request
+func (s VMUpdateRequest) Fields() (fields []string) {
+	if s.Hostname != nil {
+		fields = append(fields, "hostname")
+	}
+	if s.Flavor != nil {
+		fields = append(fields, "flavor")
+	}
+	if s.CPU != nil {
+		fields = append(fields, "cpu")
+	}
+	if s.Memory != nil {
+		fields = append(fields, "memory")
+	}
+	if s.Remarks != nil {
+		fields = append(fields, "remarks")
+	}
+	return
+}
+
+var (
+	unixUserRegex     = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	passwordHashRegex = regexp.MustCompile(`^\$(1|2[aby]|5|6|y|gy)\$[./A-Za-z0-9$=,]+$`)
+	packageRegex      = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_:=~-]*$`)
+)
+
+// Hand written user-data is checked the same way generated one is
+func validUserData(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+	return cloudinit.Validate(s)
+}
+
+// What eve generates the cloud-init user-data, meta-data and network config
+// of a VM from
+type CloudInitRequest struct {
+	User         string   `json:"user"` // the image's default user if unset
+	SSHKeys      []string `json:"ssh_keys"`
+	PasswordHash string   `json:"password_hash"` // crypt(3), e.g. from mkpasswd
+	Packages     []string `json:"packages"`
+	Snippets     []string `json:"snippets"` // cloud-config or scripts, merged in after ours
+}
+
+func (s CloudInitRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.User, validation.Match(unixUserRegex)),
+		validation.Field(&s.SSHKeys, validation.By(func(interface{}) error {
+			for i, key := range s.SSHKeys {
+				if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+					return fmt.Errorf("key %d: %w", i, err)
+				}
+			}
+			return nil
+		})),
+		validation.Field(&s.PasswordHash, validation.Match(passwordHashRegex).Error("must be a crypt(3) hash")),
+		validation.Field(&s.Packages, validation.Each(validation.Match(packageRegex))),
+		validation.Field(&s.Snippets, validation.By(func(interface{}) error {
+			for i, snippet := range s.Snippets {
+				if err := cloudinit.ValidateSnippet(snippet); err != nil {
+					return fmt.Errorf("snippet %d: %w", i, err)
+				}
+			}
+			return nil
+		})),
+	)
+}
+
+type AdoptDomainRequest struct {
+	User     uuid.UUID `json:"user"`
+	Hostname string    `json:"hostname"`
+}
+
+func (s AdoptDomainRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.User, notNilUUID),
+		validation.Field(&s.Hostname, validation.Required, is.Domain),
+	)
+}
+
+// notNilUUID rejects the nil UUID, the built-in rules see a uuid.UUID as the
+// string its driver.Valuer gives and never find it empty
+var notNilUUID = validation.By(func(value interface{}) error {
+	var id uuid.UUID
+	switch v := value.(type) {
+	case uuid.UUID:
+		id = v
+	case *uuid.UUID:
+		if v == nil {
+			return nil
+		}
+		id = *v
+	default:
+		return nil
+	}
+
+	if id == uuid.Nil {
+		return errors.New("must not be the nil UUID")
+	}
+	return nil
+})
+
+type ResolveGhostRequest struct {
+	State string `json:"state"`
+}
+
+func (s ResolveGhostRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.State, validation.Required, validation.In("lost", "deleted")),
+	)
+}
+
+type APIKeyCreateRequest struct {
+	Name    string     `json:"name"`
+	Expires *time.Time `json:"expires"`
+}
+
+func (s APIKeyCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.Expires, validation.NilOrNotEmpty, validation.Min(time.Now()).Error("must be in the future")),
+	)
+}
+
+type HVCreateRequest struct {
+	Hostname   string   `json:"hostname"`
+	AutoUrl    string   `json:"auto_url"`
+	AutoSerial string   `json:"auto_serial"`
+	Site       string   `json:"site"`
+	Remarks    string   `json:"remarks"`
+	Tags       []string `json:"tags"`
+}
+
+func (s HVCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Hostname, validation.Required, is.Domain),
+		validation.Field(&s.AutoUrl, validation.Required, is.DialString),
+		validation.Field(&s.AutoSerial, validation.Required, is.Digit),
+		validation.Field(&s.Site, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.Tags, validation.Each(validation.Required, validation.Length(1, 64))),
+	)
+}
+
+type HVUpdateRequest struct {
+	Hostname   *string   `json:"hostname"`
+	AutoUrl    *string   `json:"auto_url"`
+	AutoSerial *string   `json:"auto_serial"`
+	Site       *string   `json:"site"`
+	Remarks    *string   `json:"remarks"`
+	Tags       *[]string `json:"tags"` // replaces all the tags
+}
+
+func (s HVUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Hostname, validation.NilOrNotEmpty, is.Domain),
+		validation.Field(&s.AutoUrl, validation.NilOrNotEmpty, is.DialString),
+		validation.Field(&s.AutoSerial, validation.NilOrNotEmpty, is.Digit),
+		validation.Field(&s.Site, validation.NilOrNotEmpty, validation.Length(1, 255)),
+		validation.Field(&s.Tags, validation.By(func(interface{}) error {
+			if s.Tags == nil {
+				return nil
+			}
+			return validation.Validate(*s.Tags, validation.Each(validation.Required, validation.Length(1, 64)))
+		})),
 	)
 }
 
@@ -119,3 +433,448 @@ func ParseRequest[R Request, T Validatable[R]](r *http.Request, rq T) error {
 
 	return rq.Validate()
 }
+
+var roleName = regexp.MustCompile("^[a-z0-9_-]+$")
+
+type RoleCreateRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (s RoleCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(2, 64), validation.Match(roleName)),
+		validation.Field(&s.Description, validation.Length(0, 255)),
+		validation.Field(&s.Permissions, validation.Required),
+	)
+}
+
+type RoleUpdateRequest struct {
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (s RoleUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Description, validation.NilOrNotEmpty, validation.Length(0, 255)),
+	)
+}
+
+type UserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+func (s UserRolesRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Roles, validation.NotNil),
+	)
+}
+
+func isPrefix(value interface{}) error {
+	s, _ := value.(string)
+	if _, err := netip.ParsePrefix(s); err != nil {
+		return errors.New("must be a valid prefix")
+	}
+	return nil
+}
+
+type IPPoolCreateRequest struct {
+	Name    string     `json:"name"`
+	Prefix  string     `json:"prefix"`
+	Gateway *string    `json:"gateway"`
+	DNS     []string   `json:"dns"`
+	Site    *string    `json:"site"`
+	Network *uuid.UUID `json:"network"`
+	Remarks string     `json:"remarks"`
+}
+
+func (s IPPoolCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.Prefix, validation.Required, validation.By(isPrefix)),
+		validation.Field(&s.Gateway, validation.NilOrNotEmpty, is.IP),
+		validation.Field(&s.DNS, validation.Each(is.IP)),
+		validation.Field(&s.Site, validation.NilOrNotEmpty),
+	)
+}
+
+type IPPoolUpdateRequest struct {
+	Gateway *string  `json:"gateway"`
+	DNS     []string `json:"dns"`
+	Remarks *string  `json:"remarks"`
+}
+
+func (s IPPoolUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Gateway, is.IP),
+		validation.Field(&s.DNS, validation.Each(is.IP)),
+	)
+}
+
+type IPAssignRequest struct {
+	Address string     `json:"address"`
+	State   string     `json:"state"`
+	VM      *uuid.UUID `json:"vm"`
+	NIC     *int       `json:"nic"`
+	Remarks string     `json:"remarks"`
+}
+
+func (s IPAssignRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Address, is.IP),
+		validation.Field(&s.State, validation.Required, validation.In("allocated", "reserved")),
+		validation.Field(&s.VM, validation.When(s.NIC != nil, validation.NotNil), notNilUUID),
+		validation.Field(&s.NIC, validation.When(s.VM != nil, validation.NotNil), validation.Min(0)),
+	)
+}
+
+type IPReserveRequest struct {
+	First   string `json:"first"`
+	Last    string `json:"last"`
+	Remarks string `json:"remarks"`
+}
+
+func (s IPReserveRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.First, validation.Required, is.IP),
+		validation.Field(&s.Last, validation.Required, is.IP),
+	)
+}
+
+type NetworkCreateRequest struct {
+	Name    string `json:"name"`
+	Bridge  string `json:"bridge"`
+	Enabled *bool  `json:"enabled"`
+	VLAN    *int   `json:"vlan_id"`
+	MTU     *int   `json:"mtu"`
+	Remarks string `json:"remarks"`
+}
+
+func (s NetworkCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.Bridge, validation.Required, validation.Length(1, 15)),
+		validation.Field(&s.VLAN, validation.NilOrNotEmpty, validation.Min(1), validation.Max(4094)),
+		validation.Field(&s.MTU, validation.NilOrNotEmpty, validation.Min(576), validation.Max(9216)),
+	)
+}
+
+type NetworkUpdateRequest struct {
+	Name    *string `json:"name"`
+	Enabled *bool   `json:"enabled"`
+	VLAN    *int    `json:"vlan_id"` // 0 removes the VLAN
+	MTU     *int    `json:"mtu"`
+	Remarks *string `json:"remarks"`
+}
+
+func (s NetworkUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.NilOrNotEmpty, validation.Length(1, 255)),
+		validation.Field(&s.VLAN, validation.Min(0), validation.Max(4094)),
+		validation.Field(&s.MTU, validation.NilOrNotEmpty, validation.Min(576), validation.Max(9216)),
+	)
+}
+
+var absPath = regexp.MustCompile("^/")
+
+type StorageCreateRequest struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Path       string `json:"path"`
+	Enabled    *bool  `json:"enabled"`
+	Iso        bool   `json:"iso"`
+	Disk       *bool  `json:"disk"`
+	CloudImage bool   `json:"cloud_image"`
+	Backup     bool   `json:"backup"`
+	Capacity   int64  `json:"capacity"` // GiB
+	Remarks    string `json:"remarks"`
+}
+
+func (s StorageCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.Type, validation.In("dir")),
+		validation.Field(&s.Path, validation.Required, validation.Length(1, 255), validation.Match(absPath).Error("must be an absolute path")),
+		validation.Field(&s.Capacity, validation.Required, validation.Min(int64(1))),
+	)
+}
+
+type StorageUpdateRequest struct {
+	Name       *string `json:"name"`
+	Enabled    *bool   `json:"enabled"`
+	Iso        *bool   `json:"iso"`
+	Disk       *bool   `json:"disk"`
+	CloudImage *bool   `json:"cloud_image"`
+	Backup     *bool   `json:"backup"`
+	Capacity   *int64  `json:"capacity"` // GiB
+	Remarks    *string `json:"remarks"`
+}
+
+func (s StorageUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.NilOrNotEmpty, validation.Length(1, 255)),
+		validation.Field(&s.Capacity, validation.NilOrNotEmpty, validation.Min(int64(1))),
+	)
+}
+
+type DiskCreateRequest struct {
+	Size    int64      `json:"size"`    // GiB
+	Storage *uuid.UUID `json:"storage"` // pool to put the disk on, picked by free space when empty
+	Remarks string     `json:"remarks"`
+}
+
+func (s DiskCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Size, validation.Required, validation.Min(int64(1))),
+		validation.Field(&s.Storage, notNilUUID),
+	)
+}
+
+type DiskResizeRequest struct {
+	Size int64 `json:"size"` // GiB
+}
+
+func (s DiskResizeRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Size, validation.Required, validation.Min(int64(1))),
+	)
+}
+
+var snapshotName = regexp.MustCompile("^[A-Za-z0-9_.-]+$")
+
+type SnapshotCreateRequest struct {
+	Name    string `json:"name"`
+	Remarks string `json:"remarks"`
+}
+
+func (s SnapshotCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 64), validation.Match(snapshotName)),
+	)
+}
+
+type BackupPolicyRequest struct {
+	Storage     uuid.UUID `json:"storage"`  // backup pool on the VM's hypervisor
+	Schedule    string    `json:"schedule"` // cron expression, in UTC
+	KeepLast    int       `json:"keep_last"`
+	KeepDaily   int       `json:"keep_daily"`
+	KeepWeekly  int       `json:"keep_weekly"`
+	KeepMonthly int       `json:"keep_monthly"`
+	Enabled     *bool     `json:"enabled"`
+}
+
+func (s BackupPolicyRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Storage, notNilUUID),
+		validation.Field(&s.Schedule, validation.Required, validation.By(func(interface{}) error {
+			_, err := backup.Parse(s.Schedule)
+			return err
+		})),
+		validation.Field(&s.KeepLast, validation.Min(0)),
+		validation.Field(&s.KeepDaily, validation.Min(0)),
+		validation.Field(&s.KeepWeekly, validation.Min(0)),
+		validation.Field(&s.KeepMonthly, validation.Min(0)),
+	)
+}
+
+type VMMigrateRequest struct {
+	Target uuid.UUID `json:"target"` // hypervisor to move to
+	Live   *bool     `json:"live"`   // defaults to live for running VMs
+}
+
+func (s VMMigrateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Target, notNilUUID),
+	)
+}
+
+type EvacuateRequest struct {
+	Mode   string     `json:"mode"`   // migrate or shutdown
+	Target *uuid.UUID `json:"target"` // only migrate there, any hypervisor that fits if unset
+}
+
+func (s EvacuateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Mode, validation.Required, validation.In("migrate", "shutdown")),
+		validation.Field(&s.Target,
+			validation.When(s.Mode == "shutdown", validation.Nil.Error("only applies to migrate")),
+			notNilUUID,
+		),
+	)
+}
+
+// A VM for eve to find a hypervisor for
+type VMPlaceRequest struct {
+	VMCreateRequest
+	Site string   `json:"site"` // any site if empty
+	Tags []string `json:"tags"` // the hypervisor needs all of them
+}
+
+func (s VMPlaceRequest) Validate() error {
+	if err := s.VMCreateRequest.Validate(); err != nil {
+		return err
+	}
+
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Site, validation.Length(1, 255)),
+		validation.Field(&s.Tags, validation.Each(validation.Required, validation.Length(1, 64))),
+	)
+}
+
+// Limits of a profile, null for no limit
+type QuotaRequest struct {
+	CPU       *int   `json:"cpu"`
+	Memory    *int   `json:"memory"` // MB
+	Disk      *int64 `json:"disk"`   // GiB
+	VMs       *int   `json:"vms"`
+	IPs       *int   `json:"ips"`
+	Snapshots *int   `json:"snapshots"`
+}
+
+func (s QuotaRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.CPU, validation.Min(0)),
+		validation.Field(&s.Memory, validation.Min(0)),
+		validation.Field(&s.Disk, validation.Min(int64(0))),
+		validation.Field(&s.VMs, validation.Min(0)),
+		validation.Field(&s.IPs, validation.Min(0)),
+		validation.Field(&s.Snapshots, validation.Min(0)),
+	)
+}
+
+type ProjectCreateRequest struct {
+	Name    string `json:"name"`
+	Remarks string `json:"remarks"`
+}
+
+func (s ProjectCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+	)
+}
+
+type ProjectUpdateRequest struct {
+	Name    *string `json:"name"`
+	Remarks *string `json:"remarks"`
+}
+
+func (s ProjectUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.NilOrNotEmpty, validation.Length(1, 255)),
+	)
+}
+
+type ProjectMemberRequest struct {
+	Role string `json:"role"` // owner, operator or viewer
+}
+
+func (s ProjectMemberRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Role, validation.Required, validation.In("owner", "operator", "viewer")),
+	)
+}
+
+type VMTransferRequest struct {
+	Project uuid.UUID `json:"project"`
+}
+
+func (s VMTransferRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Project, notNilUUID),
+	)
+}
+
+type FlavorCreateRequest struct {
+	Name      string `json:"name"`
+	CPU       int    `json:"cpu"`
+	Memory    int    `json:"memory"`    // MB
+	Disk      int    `json:"disk"`      // GiB
+	Bandwidth int    `json:"bandwidth"` // Mbit/s, 0 is unmetered
+	Price     *int64 `json:"price"`
+	Active    *bool  `json:"active"` // defaults to true
+	Remarks   string `json:"remarks"`
+}
+
+func (s FlavorCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.CPU, validation.Required, validation.Min(1)),
+		validation.Field(&s.Memory, validation.Required, validation.Min(1)),
+		validation.Field(&s.Disk, validation.Required, validation.Min(1)),
+		validation.Field(&s.Bandwidth, validation.Min(0)),
+		validation.Field(&s.Price, validation.Min(int64(0))),
+	)
+}
+
+// The resources of a flavor can't change, only how it is offered
+type FlavorUpdateRequest struct {
+	Name      *string `json:"name"`
+	Bandwidth *int    `json:"bandwidth"`
+	Price     *int64  `json:"price"` // negative removes it
+	Active    *bool   `json:"active"`
+	Remarks   *string `json:"remarks"`
+}
+
+func (s FlavorUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.NilOrNotEmpty, validation.Length(1, 255)),
+		validation.Field(&s.Bandwidth, validation.Min(0)),
+	)
+}
+
+var (
+	fileNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+	sha256Regex   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+type ImageCreateRequest struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"` // cloud or iso
+	OSFamily    string `json:"os_family"`
+	Version     string `json:"version"`
+	OSVariant   string `json:"os_variant"`
+	DefaultUser string `json:"default_user"`
+	MinDisk     int    `json:"min_disk"`   // GiB
+	MinMemory   int    `json:"min_memory"` // MB
+	FileName    string `json:"file_name"`
+	Source      string `json:"source"` // the file is expected in the pools already if unset
+	Checksum    string `json:"checksum"`
+	Remarks     string `json:"remarks"`
+}
+
+func (s ImageCreateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.Kind, validation.Required, validation.In("cloud", "iso")),
+		validation.Field(&s.OSFamily, validation.Required, validation.Length(1, 64)),
+		validation.Field(&s.MinDisk, validation.Min(0)),
+		validation.Field(&s.MinMemory, validation.Min(0)),
+		validation.Field(&s.FileName, validation.Required, validation.Length(1, 255), validation.Match(fileNameRegex)),
+		validation.Field(&s.Source, is.URL),
+		validation.Field(&s.Checksum, validation.Required, validation.Match(sha256Regex).Error("must be a lowercase hex sha256")),
+	)
+}
+
+// The file of an image can't change, only what describes it
+type ImageUpdateRequest struct {
+	Name        *string `json:"name"`
+	OSFamily    *string `json:"os_family"`
+	Version     *string `json:"version"`
+	OSVariant   *string `json:"os_variant"`
+	DefaultUser *string `json:"default_user"`
+	MinDisk     *int    `json:"min_disk"`   // GiB
+	MinMemory   *int    `json:"min_memory"` // MB
+	Source      *string `json:"source"`
+	Remarks     *string `json:"remarks"`
+}
+
+func (s ImageUpdateRequest) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.NilOrNotEmpty, validation.Length(1, 255)),
+		validation.Field(&s.OSFamily, validation.NilOrNotEmpty, validation.Length(1, 64)),
+		validation.Field(&s.MinDisk, validation.Min(0)),
+		validation.Field(&s.MinMemory, validation.Min(0)),
+		validation.Field(&s.Source, is.URL),
+	)
+}
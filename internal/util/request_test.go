@@ -0,0 +1,157 @@
+//go:build !integration
+// +build !integration
+
+package util
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMCreateRequestFlavor(t *testing.T) {
+	id := uuid.New()
+
+	raw := VMCreateRequest{User: uuid.New(), Hostname: "vm.example.com", CPU: 1, Memory: 512, Disk: []VMDisk{{Size: 10}}}
+	assert.NoError(t, raw.Validate())
+
+	raw.CPU = 0
+	assert.Error(t, raw.Validate(), "cpu is needed without a flavor")
+
+	flavored := VMCreateRequest{User: uuid.New(), Hostname: "vm.example.com", Flavor: &id}
+	assert.NoError(t, flavored.Validate())
+
+	flavored.Disk = []VMDisk{{}, {Size: 20}}
+	assert.NoError(t, flavored.Validate(), "the flavor sizes the root disk")
+
+	flavored.Disk[0].Size = 10
+	assert.Error(t, flavored.Validate(), "root disk size with a flavor")
+
+	flavored.Disk = nil
+	flavored.CPU = 2
+	assert.Error(t, flavored.Validate(), "cpu with a flavor")
+
+	nilID := uuid.Nil
+	assert.Error(t, VMCreateRequest{User: uuid.New(), Hostname: "vm.example.com", Flavor: &nilID}.Validate())
+}
+
+func TestVMCreateRequestOwner(t *testing.T) {
+	project := uuid.New()
+	req := VMCreateRequest{User: uuid.New(), Project: &project, Hostname: "vm.example.com", CPU: 1, Memory: 512}
+	assert.NoError(t, req.Validate())
+
+	nilID := uuid.Nil
+	req.Project = &nilID
+	assert.Error(t, req.Validate(), "nil project")
+
+	req.Project = nil
+	req.User = uuid.Nil
+	assert.Error(t, req.Validate(), "nil user")
+}
+
+func TestVMUpdateRequestFlavor(t *testing.T) {
+	id := uuid.New()
+	cpu := 2
+
+	assert.NoError(t, VMUpdateRequest{Flavor: &id}.Validate())
+	assert.NoError(t, VMUpdateRequest{CPU: &cpu}.Validate())
+	assert.Error(t, VMUpdateRequest{Flavor: &id, CPU: &cpu}.Validate())
+
+	nilID := uuid.Nil
+	assert.Error(t, VMUpdateRequest{Flavor: &nilID}.Validate())
+}
+
+func TestVMCreateRequestImage(t *testing.T) {
+	id := uuid.New()
+
+	req := VMCreateRequest{User: uuid.New(), Hostname: "vm.example.com", CPU: 1, Memory: 512, ImageID: &id}
+	assert.NoError(t, req.Validate())
+
+	req.CloudImage = "/var/lib/images/debian-12.qcow2"
+	assert.Error(t, req.Validate(), "cloud_image with an image_id")
+
+	req.CloudImage = ""
+	req.OSVariant = "debian12"
+	assert.Error(t, req.Validate(), "os_variant with an image_id")
+}
+
+func TestImageCreateRequest(t *testing.T) {
+	req := ImageCreateRequest{
+		Name:     "Debian 12",
+		Kind:     "cloud",
+		OSFamily: "debian",
+		FileName: "debian-12.qcow2",
+		Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	assert.NoError(t, req.Validate())
+
+	req.FileName = "../debian-12.qcow2"
+	assert.Error(t, req.Validate(), "file name leaving the pool")
+
+	req.FileName = "debian-12.qcow2"
+	req.Checksum = "E3B0"
+	assert.Error(t, req.Validate(), "bad checksum")
+}
+
+func TestVMCreateRequestCloudInit(t *testing.T) {
+	req := VMCreateRequest{
+		User:       uuid.New(),
+		Hostname:   "vm.example.com",
+		CPU:        1,
+		Memory:     512,
+		Cloud:      true,
+		CloudImage: "/var/lib/images/debian-12.qcow2",
+		CloudInit: &CloudInitRequest{
+			User:         "debian",
+			SSHKeys:      []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGtQUPGdqwJWkH4UGB0vsjyqmqyC2ZexDJnmRL3gKXQA user@example.com"},
+			PasswordHash: "$6$rounds=4096$salt$hash",
+			Packages:     []string{"htop", "nginx=1.22.1-9"},
+			Snippets:     []string{"#!/bin/sh\ntrue\n"},
+		},
+	}
+	assert.NoError(t, req.Validate())
+
+	req.UserData = "#cloud-config\n"
+	assert.Error(t, req.Validate(), "user_data with cloud_init")
+	req.UserData = ""
+
+	req.CloudInit.SSHKeys = []string{"not a key"}
+	assert.Error(t, req.Validate(), "bad ssh key")
+	req.CloudInit.SSHKeys = nil
+
+	req.CloudInit.PasswordHash = "hunter22"
+	assert.Error(t, req.Validate(), "plain password")
+	req.CloudInit.PasswordHash = ""
+
+	req.CloudInit.Snippets = []string{"echo hello"}
+	assert.Error(t, req.Validate(), "snippet of unknown format")
+	req.CloudInit.Snippets = nil
+
+	req.Cloud = false
+	assert.Error(t, req.Validate(), "cloud_init without a cloud image")
+}
+
+func TestVMCreateRequestUserData(t *testing.T) {
+	req := VMCreateRequest{User: uuid.New(), Hostname: "vm.example.com", CPU: 1, Memory: 512, Cloud: true}
+
+	req.UserData = "#cloud-config\npackages: [htop]\n"
+	assert.NoError(t, req.Validate())
+
+	req.UserData = "packages: [htop]\n"
+	assert.Error(t, req.Validate(), "user_data without a header")
+}
+
+func TestIPAssignRequest(t *testing.T) {
+	id := uuid.New()
+	nic := 0
+
+	assert.NoError(t, IPAssignRequest{State: "reserved"}.Validate())
+	assert.NoError(t, IPAssignRequest{State: "allocated", VM: &id, NIC: &nic}.Validate())
+
+	assert.Error(t, IPAssignRequest{State: "allocated", VM: &id}.Validate(), "vm without a nic")
+	assert.Error(t, IPAssignRequest{State: "allocated", NIC: &nic}.Validate(), "nic without a vm")
+
+	nilID := uuid.Nil
+	assert.Error(t, IPAssignRequest{State: "allocated", VM: &nilID, NIC: &nic}.Validate())
+}
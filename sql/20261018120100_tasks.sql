@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.tasks (
+    id uuid NOT NULL PRIMARY KEY,
+    profile_id uuid NOT NULL REFERENCES profile (id),
+    type character varying(255) NOT NULL,
+    status character varying(32) NOT NULL,
+    resource_id uuid,
+    error text NOT NULL DEFAULT '',
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    started timestamp with time zone,
+    finished timestamp with time zone,
+    updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX tasks_profile_id_idx ON public.tasks (profile_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.tasks;
+-- +goose StatementEnd
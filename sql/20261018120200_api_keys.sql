@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.api_keys (
+    id uuid NOT NULL PRIMARY KEY,
+    owner uuid NOT NULL REFERENCES public.profile(id),
+    name character varying(255) NOT NULL,
+    token_public character varying(255) NOT NULL UNIQUE,
+    token_secret character varying(255) NOT NULL,
+    token_salt character varying(255) NOT NULL,
+    token_version character varying(4) NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    expires timestamp with time zone,
+    last_used timestamp with time zone
+);
+
+CREATE INDEX api_keys_owner_idx ON public.api_keys (owner);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.api_keys;
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.profile ADD COLUMN totp_secret character varying(255) NOT NULL DEFAULT '';
+ALTER TABLE public.profile ADD COLUMN totp_enabled boolean NOT NULL DEFAULT FALSE;
+-- time step of the last code used, a code is only good once
+ALTER TABLE public.profile ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE public.recovery_codes (
+    id uuid NOT NULL PRIMARY KEY,
+    owner uuid NOT NULL REFERENCES public.profile(id),
+    code_hash character varying(255) NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    used timestamp with time zone
+);
+
+CREATE INDEX recovery_codes_owner_idx ON public.recovery_codes (owner);
+
+CREATE TABLE public.mfa_challenges (
+    owner uuid NOT NULL REFERENCES public.profile(id),
+    token_public character varying(255) NOT NULL PRIMARY KEY,
+    token_secret character varying(255) NOT NULL,
+    token_salt character varying(255) NOT NULL,
+    token_version character varying(4) NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    claimed timestamp with time zone,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    expires timestamp with time zone NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.mfa_challenges;
+DROP TABLE public.recovery_codes;
+ALTER TABLE public.profile DROP COLUMN totp_last_step;
+ALTER TABLE public.profile DROP COLUMN totp_enabled;
+ALTER TABLE public.profile DROP COLUMN totp_secret;
+-- +goose StatementEnd
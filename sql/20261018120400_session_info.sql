@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.sessions ADD COLUMN client_ip character varying(45) NOT NULL DEFAULT '';
+ALTER TABLE public.sessions ADD COLUMN user_agent character varying(512) NOT NULL DEFAULT '';
+ALTER TABLE public.sessions ADD COLUMN last_used timestamp with time zone;
+
+CREATE INDEX sessions_owner_idx ON public.sessions (owner);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX public.sessions_owner_idx;
+ALTER TABLE public.sessions DROP COLUMN last_used;
+ALTER TABLE public.sessions DROP COLUMN user_agent;
+ALTER TABLE public.sessions DROP COLUMN client_ip;
+-- +goose StatementEnd
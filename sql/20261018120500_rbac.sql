@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.roles (
+    name character varying(64) NOT NULL PRIMARY KEY,
+    description character varying(255) NOT NULL DEFAULT '',
+    builtin boolean NOT NULL DEFAULT false,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE public.role_permissions (
+    role character varying(64) NOT NULL REFERENCES public.roles(name) ON DELETE CASCADE,
+    permission character varying(64) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE public.profile_roles (
+    profile uuid NOT NULL REFERENCES public.profile(id) ON DELETE CASCADE,
+    role character varying(64) NOT NULL REFERENCES public.roles(name) ON DELETE CASCADE,
+    PRIMARY KEY (profile, role)
+);
+
+INSERT INTO public.roles (name, description, builtin) VALUES
+    ('admin', 'Full access, granted to every profile with is_admin set', true),
+    ('user', 'Self-service access to owned resources, granted to every profile', true),
+    ('auditor', 'Read-only access to everything', true),
+    ('support', 'Read access, power control and consoles of every virtual machine', true),
+    ('billing', 'Read access to users and management of billing', true);
+
+INSERT INTO public.role_permissions (role, permission) VALUES
+    ('admin', '*'),
+    ('user', 'self.*'),
+    ('auditor', 'hv.read'),
+    ('auditor', 'vm.read'),
+    ('auditor', 'task.read'),
+    ('auditor', 'user.read'),
+    ('auditor', 'role.read'),
+    ('auditor', 'billing.read'),
+    ('support', 'hv.read'),
+    ('support', 'vm.read'),
+    ('support', 'vm.state'),
+    ('support', 'vm.console'),
+    ('support', 'task.read'),
+    ('support', 'user.read'),
+    ('billing', 'user.read'),
+    ('billing', 'billing.*');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.profile_roles;
+DROP TABLE public.role_permissions;
+DROP TABLE public.roles;
+-- +goose StatementEnd
@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.ip_pools (
+    id uuid NOT NULL PRIMARY KEY,
+    name character varying(255) NOT NULL UNIQUE,
+    prefix cidr NOT NULL,
+    gateway inet,
+    dns inet[] NOT NULL DEFAULT '{}',
+    site character varying(255),
+    network_id uuid REFERENCES public.hv_network(id) ON DELETE RESTRICT,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE public.ip_reservations (
+    id uuid NOT NULL PRIMARY KEY,
+    pool_id uuid NOT NULL REFERENCES public.ip_pools(id) ON DELETE CASCADE,
+    first inet NOT NULL,
+    last inet NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT ''
+);
+
+-- vm_id has no foreign key, addresses are allocated before the vm row exists
+CREATE TABLE public.ip_addresses (
+    id uuid NOT NULL PRIMARY KEY,
+    pool_id uuid NOT NULL REFERENCES public.ip_pools(id) ON DELETE CASCADE,
+    address inet NOT NULL,
+    state character varying(16) NOT NULL,
+    vm_id uuid,
+    nic integer,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT '',
+    UNIQUE (pool_id, address)
+);
+
+CREATE INDEX ip_addresses_vm_id_idx ON public.ip_addresses (vm_id);
+
+INSERT INTO public.role_permissions (role, permission) VALUES
+    ('auditor', 'ipam.read'),
+    ('support', 'ipam.read');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM public.role_permissions WHERE permission = 'ipam.read';
+DROP TABLE public.ip_addresses;
+DROP TABLE public.ip_reservations;
+DROP TABLE public.ip_pools;
+-- +goose StatementEnd
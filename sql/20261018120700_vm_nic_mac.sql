@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.vm_nic ADD COLUMN bridge character varying(255) NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX vm_nic_mac_key ON public.vm_nic (mac);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX public.vm_nic_mac_key;
+ALTER TABLE public.vm_nic DROP COLUMN bridge;
+-- +goose StatementEnd
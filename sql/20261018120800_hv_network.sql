@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.hv_network ADD COLUMN enabled boolean NOT NULL DEFAULT true;
+ALTER TABLE public.hv_network ADD COLUMN vlan_id integer;
+ALTER TABLE public.hv_network ADD COLUMN mtu integer NOT NULL DEFAULT 1500;
+ALTER TABLE public.hv_network ADD COLUMN created timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE public.hv_network ADD COLUMN updated timestamp with time zone NOT NULL DEFAULT now();
+
+CREATE UNIQUE INDEX hv_network_hv_bridge_key ON public.hv_network (hv_id, bridge);
+
+ALTER TABLE public.vm_nic ADD COLUMN network_id uuid REFERENCES public.hv_network(id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE public.vm_nic DROP COLUMN network_id;
+DROP INDEX public.hv_network_hv_bridge_key;
+ALTER TABLE public.hv_network DROP COLUMN updated;
+ALTER TABLE public.hv_network DROP COLUMN created;
+ALTER TABLE public.hv_network DROP COLUMN mtu;
+ALTER TABLE public.hv_network DROP COLUMN vlan_id;
+ALTER TABLE public.hv_network DROP COLUMN enabled;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.hv_storage ADD COLUMN enabled boolean NOT NULL DEFAULT true;
+ALTER TABLE public.hv_storage ADD COLUMN type character varying(32) NOT NULL DEFAULT 'dir';
+ALTER TABLE public.hv_storage ADD COLUMN iso boolean NOT NULL DEFAULT false;
+ALTER TABLE public.hv_storage ADD COLUMN disk boolean NOT NULL DEFAULT true;
+ALTER TABLE public.hv_storage ADD COLUMN cloud_image boolean NOT NULL DEFAULT false;
+ALTER TABLE public.hv_storage ADD COLUMN capacity bigint NOT NULL DEFAULT 0;
+ALTER TABLE public.hv_storage ADD COLUMN created timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE public.hv_storage ADD COLUMN updated timestamp with time zone NOT NULL DEFAULT now();
+
+CREATE UNIQUE INDEX hv_storage_hv_path_key ON public.hv_storage (hv_id, path);
+
+ALTER TABLE public.vm_storage ADD COLUMN storage_id uuid REFERENCES public.hv_storage(id);
+
+CREATE INDEX vm_storage_storage_id_idx ON public.vm_storage (storage_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX public.vm_storage_storage_id_idx;
+ALTER TABLE public.vm_storage DROP COLUMN storage_id;
+DROP INDEX public.hv_storage_hv_path_key;
+ALTER TABLE public.hv_storage DROP COLUMN updated;
+ALTER TABLE public.hv_storage DROP COLUMN created;
+ALTER TABLE public.hv_storage DROP COLUMN capacity;
+ALTER TABLE public.hv_storage DROP COLUMN cloud_image;
+ALTER TABLE public.hv_storage DROP COLUMN disk;
+ALTER TABLE public.hv_storage DROP COLUMN iso;
+ALTER TABLE public.hv_storage DROP COLUMN type;
+ALTER TABLE public.hv_storage DROP COLUMN enabled;
+-- +goose StatementEnd
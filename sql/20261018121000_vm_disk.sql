@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.vm_storage ADD COLUMN name character varying(16) NOT NULL DEFAULT '';
+ALTER TABLE public.vm_storage ADD COLUMN root boolean NOT NULL DEFAULT false;
+
+-- Existing disks are named in the order they were made, the first one boots
+UPDATE public.vm_storage s SET name = 'vd' || chr(96 + o.n::integer), root = o.n = 1
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY vm_id ORDER BY created, id) AS n
+    FROM public.vm_storage
+) o
+WHERE s.id = o.id AND o.n <= 26;
+
+CREATE UNIQUE INDEX vm_storage_vm_name_key ON public.vm_storage (vm_id, name) WHERE name <> '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX public.vm_storage_vm_name_key;
+ALTER TABLE public.vm_storage DROP COLUMN root;
+ALTER TABLE public.vm_storage DROP COLUMN name;
+-- +goose StatementEnd
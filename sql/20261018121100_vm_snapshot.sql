@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.vm_snapshot (
+    id uuid NOT NULL PRIMARY KEY,
+    vm_id uuid NOT NULL REFERENCES public.vm(id) ON DELETE CASCADE,
+    name character varying(64) NOT NULL,
+    size bigint NOT NULL DEFAULT 0,
+    state character varying(16) NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT '',
+    UNIQUE (vm_id, name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.vm_snapshot;
+-- +goose StatementEnd
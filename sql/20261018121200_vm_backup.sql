@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.hv_storage ADD COLUMN backup boolean NOT NULL DEFAULT false;
+
+CREATE TABLE public.backup_policy (
+    id uuid NOT NULL PRIMARY KEY,
+    vm_id uuid NOT NULL UNIQUE REFERENCES public.vm(id) ON DELETE CASCADE,
+    storage_id uuid NOT NULL REFERENCES public.hv_storage(id),
+    schedule character varying(64) NOT NULL,
+    keep_last integer NOT NULL DEFAULT 0,
+    keep_daily integer NOT NULL DEFAULT 0,
+    keep_weekly integer NOT NULL DEFAULT 0,
+    keep_monthly integer NOT NULL DEFAULT 0,
+    enabled boolean NOT NULL DEFAULT true,
+    next_run timestamp with time zone,
+    last_run timestamp with time zone,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX backup_policy_next_run_idx ON public.backup_policy (next_run) WHERE enabled;
+
+CREATE TABLE public.vm_backup (
+    id uuid NOT NULL PRIMARY KEY,
+    vm_id uuid NOT NULL REFERENCES public.vm(id) ON DELETE CASCADE,
+    storage_id uuid NOT NULL REFERENCES public.hv_storage(id),
+    size bigint NOT NULL DEFAULT 0,
+    checksum character varying(128) NOT NULL DEFAULT '',
+    state character varying(16) NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX vm_backup_vm_id_idx ON public.vm_backup (vm_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.vm_backup;
+DROP TABLE public.backup_policy;
+ALTER TABLE public.hv_storage DROP COLUMN backup;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.hv ADD COLUMN maintenance boolean NOT NULL DEFAULT false;
+ALTER TABLE public.hv ADD COLUMN maintenance_since timestamp with time zone;
+
+CREATE TABLE public.hv_evacuation (
+    id uuid NOT NULL PRIMARY KEY,
+    hv_id uuid NOT NULL REFERENCES public.hv(id) ON DELETE CASCADE,
+    task_id uuid REFERENCES public.tasks(id),
+    mode character varying(16) NOT NULL,
+    target_hv_id uuid,
+    state character varying(16) NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    finished timestamp with time zone
+);
+
+-- No foreign keys on the VM and target, the summary outlives them
+CREATE TABLE public.hv_evacuation_vm (
+    id uuid NOT NULL PRIMARY KEY,
+    evacuation_id uuid NOT NULL REFERENCES public.hv_evacuation(id) ON DELETE CASCADE,
+    vm_id uuid NOT NULL,
+    hostname character varying(255) NOT NULL,
+    target_hv_id uuid,
+    target_hostname character varying(255) NOT NULL DEFAULT '',
+    state character varying(16) NOT NULL,
+    progress integer NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT '',
+    updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX hv_evacuation_vm_evacuation_id_idx ON public.hv_evacuation_vm (evacuation_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.hv_evacuation_vm;
+DROP TABLE public.hv_evacuation;
+ALTER TABLE public.hv DROP COLUMN maintenance_since;
+ALTER TABLE public.hv DROP COLUMN maintenance;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A NULL limit is no limit, profiles without a row have none at all
+CREATE TABLE public.quota (
+    profile_id uuid NOT NULL PRIMARY KEY REFERENCES public.profile(id) ON DELETE CASCADE,
+    cpu integer,
+    memory bigint,
+    disk bigint,
+    vms integer,
+    ips integer,
+    snapshots integer,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.quota;
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.project (
+    id uuid NOT NULL PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE public.project_member (
+    project_id uuid NOT NULL REFERENCES public.project(id) ON DELETE CASCADE,
+    profile_id uuid NOT NULL REFERENCES public.profile(id) ON DELETE CASCADE,
+    role character varying(16) NOT NULL,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (project_id, profile_id)
+);
+
+CREATE INDEX project_member_profile_id_idx ON public.project_member (profile_id);
+
+ALTER TABLE public.vm ADD COLUMN project_id uuid REFERENCES public.project(id);
+
+-- Every profile that has VMs gets a default project it owns, holding them
+CREATE TEMPORARY TABLE default_project AS
+    SELECT DISTINCT profile_id, gen_random_uuid() AS id FROM public.vm;
+INSERT INTO public.project (id, name) SELECT id, 'default' FROM default_project;
+INSERT INTO public.project_member (project_id, profile_id, role)
+    SELECT id, profile_id, 'owner' FROM default_project;
+UPDATE public.vm SET project_id = d.id FROM default_project d WHERE vm.profile_id = d.profile_id;
+DROP TABLE default_project;
+
+ALTER TABLE public.vm ALTER COLUMN project_id SET NOT NULL;
+CREATE INDEX vm_project_id_idx ON public.vm (project_id);
+
+INSERT INTO public.role_permissions (role, permission) VALUES
+    ('auditor', 'project.read'),
+    ('support', 'project.read');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM public.role_permissions WHERE permission = 'project.read';
+ALTER TABLE public.vm DROP COLUMN project_id;
+DROP TABLE public.project_member;
+DROP TABLE public.project;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Memory is in bytes, disk in GiB, bandwidth in Mbit/s and the price is
+-- monthly, in the smallest unit of the currency
+CREATE TABLE public.flavor (
+    id uuid NOT NULL PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    cpu integer NOT NULL,
+    memory bigint NOT NULL,
+    disk integer NOT NULL,
+    bandwidth integer NOT NULL DEFAULT 0,
+    price bigint,
+    active boolean NOT NULL DEFAULT true,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT ''
+);
+
+-- A VM changed by hand no longer matches a plan and has none
+ALTER TABLE public.vm ADD COLUMN flavor_id uuid REFERENCES public.flavor(id);
+CREATE INDEX vm_flavor_id_idx ON public.vm (flavor_id);
+
+INSERT INTO public.role_permissions (role, permission) VALUES
+    ('auditor', 'flavor.read'),
+    ('support', 'flavor.read'),
+    ('billing', 'flavor.read');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM public.role_permissions WHERE permission = 'flavor.read';
+ALTER TABLE public.vm DROP COLUMN flavor_id;
+DROP TABLE public.flavor;
+-- +goose StatementEnd
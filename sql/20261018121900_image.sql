@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Cloud images go to cloud_image pools, isos to iso pools, under the same
+-- file name everywhere. Min disk is in GiB, min memory in bytes.
+CREATE TABLE public.image (
+    id uuid NOT NULL PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    kind character varying(16) NOT NULL,
+    os_family text NOT NULL,
+    version text NOT NULL DEFAULT '',
+    os_variant text NOT NULL DEFAULT '',
+    default_user text NOT NULL DEFAULT '',
+    min_disk integer NOT NULL DEFAULT 0,
+    min_memory bigint NOT NULL DEFAULT 0,
+    file_name text NOT NULL,
+    source text NOT NULL DEFAULT '',
+    checksum text NOT NULL,
+    deprecated timestamp with time zone,
+    created timestamp with time zone NOT NULL DEFAULT now(),
+    updated timestamp with time zone NOT NULL DEFAULT now(),
+    remarks text NOT NULL DEFAULT ''
+);
+
+-- Pools the image was synced to, or failed to be
+CREATE TABLE public.image_location (
+    image_id uuid NOT NULL REFERENCES public.image(id) ON DELETE CASCADE,
+    storage_id uuid NOT NULL REFERENCES public.hv_storage(id) ON DELETE CASCADE,
+    state character varying(16) NOT NULL,
+    error text NOT NULL DEFAULT '',
+    updated timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (image_id, storage_id)
+);
+CREATE INDEX image_location_storage_id_idx ON public.image_location (storage_id);
+
+ALTER TABLE public.vm ADD COLUMN image_id uuid REFERENCES public.image(id) ON DELETE SET NULL;
+
+INSERT INTO public.role_permissions (role, permission) VALUES
+    ('auditor', 'image.read'),
+    ('support', 'image.read');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM public.role_permissions WHERE permission = 'image.read';
+ALTER TABLE public.vm DROP COLUMN image_id;
+DROP TABLE public.image_location;
+DROP TABLE public.image;
+-- +goose StatementEnd
@@ -0,0 +1,208 @@
+//go:build integration
+// +build integration
+
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/BasedDevelopment/eve/internal/auto"
+	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/db"
+	"github.com/BasedDevelopment/eve/pkg/pki"
+	"github.com/BasedDevelopment/eve/pkg/status"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeAutoHostname = "localhost"
+
+var (
+	pkiOnce    sync.Once
+	fakeCA     *x509.CertPool
+	fakeCrt    tls.Certificate
+	fakeSerial string
+)
+
+// Give eve's auto client a PKI of its own, and a certificate for the fake
+// auto signed by the same CA
+func initFakePKI(ts *TestSuite) {
+	pkiOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "eve-test-pki")
+		assert.NoError(ts.T(), err)
+		// Read once by auto.Init
+		defer os.RemoveAll(dir)
+
+		caKey := pki.ReadKey(pki.GenKey())
+		caPem := pki.GenCA(caKey)
+		caCrt := pki.ReadCrt(caPem)
+
+		sign := func(hostname string) ([]byte, []byte) {
+			keyPem := pki.GenKey()
+			csr := pki.ReadCSR(pki.GenCSR(pki.ReadKey(keyPem), hostname))
+			return pki.SignCrt(caCrt, caKey, csr), keyPem
+		}
+
+		eveCrt, eveKey := sign("eve.test")
+		assert.NoError(ts.T(), os.WriteFile(filepath.Join(dir, "ca.crt"), caPem, 0600))
+		assert.NoError(ts.T(), os.WriteFile(filepath.Join(dir, "eve.test.crt"), eveCrt, 0600))
+		assert.NoError(ts.T(), os.WriteFile(filepath.Join(dir, "eve.test.key"), eveKey, 0600))
+
+		config.Config.TLSPath = dir
+		config.Config.Hostname = "eve.test"
+		auto.Init()
+
+		autoCrt, autoKey := sign(fakeAutoHostname)
+		fakeCrt, err = tls.X509KeyPair(autoCrt, autoKey)
+		assert.NoError(ts.T(), err)
+		fakeSerial = pki.ReadCrt(autoCrt).SerialNumber.String()
+
+		fakeCA = x509.NewCertPool()
+		fakeCA.AddCert(caCrt)
+	})
+}
+
+// fakeAuto answers eve the way auto on a hypervisor would, and remembers the
+// state a snapshot was in while auto worked on it
+type fakeAuto struct {
+	server *httptest.Server
+	vm     uuid.UUID
+
+	mutex   sync.Mutex
+	fail    bool
+	running bool
+	seen    []string
+}
+
+func newFakeAuto(ts *TestSuite, vm uuid.UUID) *fakeAuto {
+	initFakePKI(ts)
+
+	f := &fakeAuto{vm: vm}
+	f.server = httptest.NewUnstartedServer(http.HandlerFunc(f.serve))
+	f.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{fakeCrt},
+		ClientCAs:    fakeCA,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	f.server.StartTLS()
+	ts.T().Cleanup(f.server.Close)
+
+	return f
+}
+
+// The client checks the certificate against the hostname, not the address
+func (f *fakeAuto) auto() *auto.Auto {
+	return &auto.Auto{
+		Url:    strings.Replace(f.server.URL, "127.0.0.1", fakeAutoHostname, 1),
+		Serial: fakeSerial,
+	}
+}
+
+func (f *fakeAuto) set(fail bool, running bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fail, f.running = fail, running
+}
+
+// States of the snapshots auto was asked about, in order, forgetting them
+func (f *fakeAuto) states() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	seen := f.seen
+	f.seen = nil
+	return seen
+}
+
+func (f *fakeAuto) serve(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	fail, running := f.fail, f.running
+	f.mutex.Unlock()
+
+	prefix := "/libvirt/domains/" + f.vm.String()
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	if path == "/state" && r.Method == "GET" {
+		state := models.VMState{State: status.StatusShutoff, StateStr: "shutoff"}
+		if running {
+			state = models.VMState{State: status.StatusRunning, StateStr: "running"}
+		}
+		json.NewEncoder(w).Encode(state)
+		return
+	}
+
+	if !strings.HasPrefix(path, "/snapshots/") {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(path, "/snapshots/"), "/revert")
+
+	var state string
+	if err := db.Pool.QueryRow(context.Background(),
+		"SELECT state FROM vm_snapshot WHERE vm_id = $1 AND name = $2", f.vm, name,
+	).Scan(&state); err != nil {
+		state = err.Error()
+	}
+	f.mutex.Lock()
+	f.seen = append(f.seen, state)
+	f.mutex.Unlock()
+
+	if fail {
+		http.Error(w, "libvirt said no", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(auto.Snapshot{Name: name, Size: 1 << 20})
+	case "POST", "DELETE":
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+	}
+}
+
+// A VM of its own profile and project on a hypervisor eve doesn't run, for
+// tests that drive the controllers directly against the database
+func newControllerVM(ts *TestSuite) (*controllers.HV, *controllers.VM) {
+	ctx := context.Background()
+	db.Pool = pool
+
+	profile, project, hv, vm := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	_, err := pool.Exec(ctx,
+		"INSERT INTO profile (id, name, email, password) VALUES ($1, 'Controller Test', $2, '')",
+		profile, profile.String()+"@testing.com")
+	assert.NoError(ts.T(), err)
+	_, err = pool.Exec(ctx, "INSERT INTO project (id, name) VALUES ($1, 'controller test')", project)
+	assert.NoError(ts.T(), err)
+	_, err = pool.Exec(ctx,
+		"INSERT INTO hv (id, hostname, auto_url, auto_serial, site) VALUES ($1, 'hv.testing.com', '', '', 'test')", hv)
+	assert.NoError(ts.T(), err)
+	_, err = pool.Exec(ctx,
+		"INSERT INTO vm (id, hv_id, hostname, profile_id, project_id, cpu, memory) VALUES ($1, $2, 'vm.testing.com', $3, $4, 1, 1073741824)",
+		vm, hv, profile, project)
+	assert.NoError(ts.T(), err)
+
+	ts.T().Cleanup(func() {
+		pool.Exec(ctx, "DELETE FROM vm_snapshot WHERE vm_id = $1", vm)
+		pool.Exec(ctx, "DELETE FROM vm WHERE id = $1", vm)
+		pool.Exec(ctx, "DELETE FROM hv WHERE id = $1", hv)
+		pool.Exec(ctx, "DELETE FROM project WHERE id = $1", project)
+		pool.Exec(ctx, "DELETE FROM profile WHERE id = $1", profile)
+	})
+
+	return &controllers.HV{ID: hv, VMs: make(map[uuid.UUID]*controllers.VM)},
+		&controllers.VM{ID: vm, UserID: profile, Project: project}
+}
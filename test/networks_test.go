@@ -0,0 +1,104 @@
+//go:build integration
+// +build integration
+
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A hypervisor eve has loaded, networks hang off of one
+func testHypervisor(ts *TestSuite) string {
+	adminLogin(ts)
+
+	status, body := request(ts, "GET", "/admin/hypervisors", adminToken, nil)
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	var hvs []map[string]interface{}
+	assert.Nil(ts.T(), json.Unmarshal(body, &hvs))
+	if len(hvs) == 0 {
+		ts.T().Skip("eve has no hypervisor to add networks to")
+	}
+
+	return hvs[0]["id"].(string)
+}
+
+func (ts *TestSuite) TestNetworkCRUD() {
+	networks := "/admin/hypervisors/" + testHypervisor(ts) + "/networks"
+
+	status, body := request(ts, "POST", networks, adminToken, map[string]interface{}{
+		"name":    "crud test",
+		"bridge":  "evetest0",
+		"vlan_id": 42,
+	})
+	assert.Equal(ts.T(), http.StatusCreated, status, string(body))
+
+	var network map[string]interface{}
+	assert.Nil(ts.T(), json.Unmarshal(body, &network))
+	id := network["id"].(string)
+	assert.Equal(ts.T(), "crud test", network["name"])
+	assert.Equal(ts.T(), "evetest0", network["bridge"])
+	assert.Equal(ts.T(), true, network["enabled"])
+	assert.Equal(ts.T(), float64(42), network["vlan_id"])
+	assert.Equal(ts.T(), float64(1500), network["mtu"])
+
+	// One network per bridge on a hypervisor
+	status, _ = request(ts, "POST", networks, adminToken, map[string]interface{}{
+		"name":   "crud test again",
+		"bridge": "evetest0",
+	})
+	assert.Equal(ts.T(), http.StatusConflict, status)
+
+	status, _ = request(ts, "POST", networks, adminToken, map[string]interface{}{
+		"name":    "bad vlan",
+		"bridge":  "evetest1",
+		"vlan_id": 4095,
+	})
+	assert.Equal(ts.T(), http.StatusBadRequest, status)
+
+	status, body = request(ts, "GET", networks, adminToken, nil)
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	var list []map[string]interface{}
+	assert.Nil(ts.T(), json.Unmarshal(body, &list))
+	listed := false
+	for _, n := range list {
+		listed = listed || n["id"] == id
+	}
+	assert.True(ts.T(), listed)
+
+	// A VLAN of 0 takes the network off its VLAN
+	status, body = request(ts, "PATCH", networks+"/"+id, adminToken, map[string]interface{}{
+		"name":    "crud test renamed",
+		"enabled": false,
+		"vlan_id": 0,
+		"mtu":     9000,
+	})
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	status, body = request(ts, "GET", networks+"/"+id, adminToken, nil)
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	network = nil
+	assert.Nil(ts.T(), json.Unmarshal(body, &network))
+	assert.Equal(ts.T(), "crud test renamed", network["name"])
+	assert.Equal(ts.T(), false, network["enabled"])
+	assert.Nil(ts.T(), network["vlan_id"])
+	assert.Equal(ts.T(), float64(9000), network["mtu"])
+	assert.Equal(ts.T(), "evetest0", network["bridge"])
+
+	status, body = request(ts, "DELETE", networks+"/"+id, adminToken, nil)
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	status, _ = request(ts, "GET", networks+"/"+id, adminToken, nil)
+	assert.Equal(ts.T(), http.StatusNotFound, status)
+
+	status, _ = request(ts, "DELETE", networks+"/"+id, adminToken, nil)
+	assert.Equal(ts.T(), http.StatusNotFound, status)
+
+	status, _ = request(ts, "GET", networks+"/not-a-uuid", adminToken, nil)
+	assert.Equal(ts.T(), http.StatusBadRequest, status)
+}
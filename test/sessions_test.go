@@ -0,0 +1,135 @@
+//go:build integration
+// +build integration
+
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/BasedDevelopment/eve/internal/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+// Send a request as the holder of token and return the status and body
+func request(ts *TestSuite, method string, path string, token string, body interface{}) (int, []byte) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		assert.Nil(ts.T(), err)
+	}
+
+	req, err := http.NewRequest(method, host+path, bytes.NewBuffer(reqBody))
+	assert.Nil(ts.T(), err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "eve-test")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(ts.T(), err)
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(ts.T(), err)
+
+	return resp.StatusCode, respBody
+}
+
+// Log in as the test user in a new session of its own
+func newUserSession(ts *TestSuite) string {
+	status, body := request(ts, "POST", "/login", "", map[string]string{
+		"email":    userEmail,
+		"password": userPassword,
+	})
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	var response map[string]string
+	assert.Nil(ts.T(), json.Unmarshal(body, &response))
+	assert.NotEmpty(ts.T(), response["token"])
+
+	return response["token"]
+}
+
+func listSessions(ts *TestSuite, token string) []map[string]interface{} {
+	status, body := request(ts, "GET", "/me/sessions", token, nil)
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	var list []map[string]interface{}
+	assert.Nil(ts.T(), json.Unmarshal(body, &list))
+
+	return list
+}
+
+func (ts *TestSuite) TestSessionsListAndRevoke() {
+	first := newUserSession(ts)
+	second := newUserSession(ts)
+
+	list := listSessions(ts, first)
+	assert.GreaterOrEqual(ts.T(), len(list), 2)
+
+	secondTok, err := tokens.Parse(second)
+	assert.Nil(ts.T(), err)
+	secondPublic := secondTok.Public
+
+	listed := false
+	for _, s := range list {
+		listed = listed || s["public"] == secondPublic
+		assert.Equal(ts.T(), userId.String(), s["owner"])
+		assert.Equal(ts.T(), "eve-test", s["user_agent"])
+		assert.NotEmpty(ts.T(), s["client_ip"])
+		assert.NotContains(ts.T(), s, "secret")
+	}
+	assert.True(ts.T(), listed)
+
+	// Revoke the second session from the first one
+	status, body := request(ts, "DELETE", "/me/sessions/"+secondPublic, first, nil)
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	status, _ = request(ts, "GET", "/me", second, nil)
+	assert.Equal(ts.T(), http.StatusUnauthorized, status)
+
+	status, _ = request(ts, "GET", "/me", first, nil)
+	assert.Equal(ts.T(), http.StatusOK, status)
+
+	// It is gone from the list, and can't be revoked twice
+	for _, s := range listSessions(ts, first) {
+		assert.NotEqual(ts.T(), secondPublic, s["public"])
+	}
+
+	status, _ = request(ts, "DELETE", "/me/sessions/"+secondPublic, first, nil)
+	assert.Equal(ts.T(), http.StatusNotFound, status)
+}
+
+func (ts *TestSuite) TestSessionsRevokeAll() {
+	adminLogin(ts)
+	session := newUserSession(ts)
+
+	status, body := request(ts, "POST", "/me/api_keys", session, map[string]string{"name": "revoke all test"})
+	assert.Equal(ts.T(), http.StatusCreated, status, string(body))
+
+	var key map[string]interface{}
+	assert.Nil(ts.T(), json.Unmarshal(body, &key))
+	apiKey := key["token"].(string)
+
+	status, _ = request(ts, "GET", "/me", apiKey, nil)
+	assert.Equal(ts.T(), http.StatusOK, status)
+
+	status, body = request(ts, "DELETE", "/admin/users/"+userId.String()+"/sessions", adminToken, nil)
+	assert.Equal(ts.T(), http.StatusOK, status, string(body))
+
+	var response map[string]interface{}
+	assert.Nil(ts.T(), json.Unmarshal(body, &response))
+	assert.GreaterOrEqual(ts.T(), response["revoked"], float64(2))
+
+	// Neither the session nor the API key works anymore
+	status, _ = request(ts, "GET", "/me", session, nil)
+	assert.Equal(ts.T(), http.StatusUnauthorized, status)
+
+	status, _ = request(ts, "GET", "/me", apiKey, nil)
+	assert.Equal(ts.T(), http.StatusUnauthorized, status)
+
+	userToken = ""
+}
@@ -0,0 +1,129 @@
+//go:build integration
+// +build integration
+
+package test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BasedDevelopment/eve/internal/config"
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func snapshotRequest(name string) *util.SnapshotCreateRequest {
+	return &util.SnapshotCreateRequest{Name: name}
+}
+
+func (ts *TestSuite) TestSnapshotLimits() {
+	ctx := context.Background()
+	_, vm := newControllerVM(ts)
+
+	limit := config.Config.VM.MaxSnapshots
+	defer func() { config.Config.VM.MaxSnapshots = limit }()
+	config.Config.VM.MaxSnapshots = 2
+
+	s, err := controllers.RecordSnapshot(ctx, vm, snapshotRequest("one"))
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), controllers.SnapshotCreating, s.State)
+	assert.Equal(ts.T(), vm.ID, s.VM)
+
+	_, err = controllers.RecordSnapshot(ctx, vm, snapshotRequest("one"))
+	assert.True(ts.T(), errors.Is(err, controllers.ErrSnapshotExists), err)
+
+	_, err = controllers.RecordSnapshot(ctx, vm, snapshotRequest("two"))
+	assert.NoError(ts.T(), err)
+
+	// Snapshots still being created count against the limit
+	_, err = controllers.RecordSnapshot(ctx, vm, snapshotRequest("three"))
+	assert.True(ts.T(), errors.Is(err, controllers.ErrSnapshotLimit), err)
+
+	config.Config.VM.MaxSnapshots = 0
+	_, err = controllers.RecordSnapshot(ctx, vm, snapshotRequest("three"))
+	assert.NoError(ts.T(), err)
+
+	// The owner's quota holds without a per VM limit
+	_, err = pool.Exec(ctx, "INSERT INTO quota (profile_id, snapshots) VALUES ($1, 3)", vm.UserID)
+	assert.NoError(ts.T(), err)
+
+	_, err = controllers.RecordSnapshot(ctx, vm, snapshotRequest("four"))
+	assert.True(ts.T(), errors.Is(err, controllers.ErrQuotaExceeded), err)
+
+	list, err := controllers.ListSnapshots(ctx, vm.ID)
+	assert.NoError(ts.T(), err)
+	assert.Len(ts.T(), list, 3)
+}
+
+func (ts *TestSuite) TestSnapshotStates() {
+	ctx := context.Background()
+	hv, vm := newControllerVM(ts)
+	fake := newFakeAuto(ts, vm.ID)
+	hv.Auto = fake.auto()
+
+	state := func(name string) string {
+		var state string
+		assert.NoError(ts.T(), pool.QueryRow(ctx,
+			"SELECT state FROM vm_snapshot WHERE vm_id = $1 AND name = $2", vm.ID, name,
+		).Scan(&state))
+		return state
+	}
+
+	s, err := controllers.RecordSnapshot(ctx, vm, snapshotRequest("base"))
+	assert.NoError(ts.T(), err)
+
+	// Nothing else may be done with it while it is created
+	_, err = controllers.CheckSnapshotDelete(ctx, vm, s.ID)
+	assert.True(ts.T(), errors.Is(err, controllers.ErrSnapshotBusy), err)
+	_, err = hv.CheckSnapshotRevert(ctx, vm, s.ID)
+	assert.True(ts.T(), errors.Is(err, controllers.ErrSnapshotBusy), err)
+
+	assert.NoError(ts.T(), hv.CreateSnapshot(ctx, vm, s))
+	assert.Equal(ts.T(), []string{controllers.SnapshotCreating}, fake.states())
+
+	s, err = controllers.GetSnapshot(ctx, vm.ID, s.ID)
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), controllers.SnapshotReady, s.State)
+	assert.Equal(ts.T(), int64(1<<20), s.Size)
+
+	// It is reverting while auto reverts, and ready again after, whether
+	// that worked or not
+	assert.NoError(ts.T(), hv.RevertSnapshot(ctx, vm, s.ID))
+	assert.Equal(ts.T(), []string{controllers.SnapshotReverting}, fake.states())
+	assert.Equal(ts.T(), controllers.SnapshotReady, state("base"))
+
+	fake.set(true, false)
+	assert.Error(ts.T(), hv.RevertSnapshot(ctx, vm, s.ID))
+	assert.Equal(ts.T(), []string{controllers.SnapshotReverting}, fake.states())
+	assert.Equal(ts.T(), controllers.SnapshotReady, state("base"))
+
+	// Only a shut off VM is reverted
+	fake.set(false, true)
+	err = hv.RevertSnapshot(ctx, vm, s.ID)
+	assert.True(ts.T(), errors.Is(err, controllers.ErrVMNotShutoff), err)
+	assert.Empty(ts.T(), fake.states())
+	assert.Equal(ts.T(), controllers.SnapshotReady, state("base"))
+
+	// A failed delete leaves it ready, a good one drops it
+	fake.set(true, false)
+	assert.Error(ts.T(), hv.DeleteSnapshot(ctx, vm, s.ID))
+	assert.Equal(ts.T(), []string{controllers.SnapshotDeleting}, fake.states())
+	assert.Equal(ts.T(), controllers.SnapshotReady, state("base"))
+
+	fake.set(false, false)
+	assert.NoError(ts.T(), hv.DeleteSnapshot(ctx, vm, s.ID))
+	assert.Equal(ts.T(), []string{controllers.SnapshotDeleting}, fake.states())
+	_, err = controllers.GetSnapshot(ctx, vm.ID, s.ID)
+	assert.True(ts.T(), errors.Is(err, controllers.ErrSnapshotNotFound), err)
+
+	// A snapshot auto fails to take doesn't stay behind
+	s, err = controllers.RecordSnapshot(ctx, vm, snapshotRequest("broken"))
+	assert.NoError(ts.T(), err)
+
+	fake.set(true, false)
+	assert.Error(ts.T(), hv.CreateSnapshot(ctx, vm, s))
+	assert.Equal(ts.T(), []string{controllers.SnapshotCreating}, fake.states())
+	_, err = controllers.GetSnapshot(ctx, vm.ID, s.ID)
+	assert.True(ts.T(), errors.Is(err, controllers.ErrSnapshotNotFound), err)
+}
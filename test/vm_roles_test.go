@@ -0,0 +1,152 @@
+//go:build integration
+// +build integration
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/BasedDevelopment/eve/internal/auto"
+	"github.com/BasedDevelopment/eve/internal/controllers"
+	"github.com/BasedDevelopment/eve/internal/project"
+	"github.com/BasedDevelopment/eve/internal/server/routes/users"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// The user VM routes, with the caller taken from a header instead of a
+// session
+func userVMRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			owner := uuid.MustParse(r.Header.Get("X-Owner"))
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "owner", owner)))
+		})
+	})
+	r.Route("/virtual_machines/{virtual_machine}", func(r chi.Router) {
+		r.Get("/", users.GetVM)
+		r.Patch("/", users.UpdateVM)
+		r.Get("/console", users.GetVMConsole)
+		r.Get("/state", users.GetVMState)
+		r.Patch("/state", users.SetVMState)
+		r.Post("/transfer", users.TransferVM)
+		r.Get("/disks", users.VMStorage.GetVMDisks)
+		r.Post("/disks", users.VMStorage.AttachVMDisk)
+	})
+	return r
+}
+
+// A profile with the given role in the project, none if role is empty
+func newMember(ts *TestSuite, projectID uuid.UUID, role string) uuid.UUID {
+	ctx := context.Background()
+	id := uuid.New()
+
+	_, err := pool.Exec(ctx,
+		"INSERT INTO profile (id, name, email, password) VALUES ($1, 'Member Test', $2, '')",
+		id, id.String()+"@testing.com")
+	assert.NoError(ts.T(), err)
+	ts.T().Cleanup(func() { pool.Exec(ctx, "DELETE FROM profile WHERE id = $1", id) })
+
+	if role != "" {
+		_, err = pool.Exec(ctx,
+			"INSERT INTO project_member (project_id, profile_id, role) VALUES ($1, $2, $3)",
+			projectID, id, role)
+		assert.NoError(ts.T(), err)
+	}
+
+	return id
+}
+
+func (ts *TestSuite) TestUserVMRoles() {
+	hv, vm := newControllerVM(ts)
+	hv.Auto = newFakeAuto(ts, vm.ID).auto()
+	hv.VMs[vm.ID] = vm
+
+	old := controllers.Cloud
+	controllers.Cloud = &controllers.HVList{HVs: map[uuid.UUID]*controllers.HV{hv.ID: hv}}
+	defer func() { controllers.Cloud = old }()
+
+	router := userVMRouter()
+	call := func(profile uuid.UUID, method string, path string, body string) (int, interface{}) {
+		req := httptest.NewRequest(method, "/virtual_machines/"+vm.ID.String()+path, bytes.NewBufferString(body))
+		req.Header.Set("X-Owner", profile.String())
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		// Handlers write exactly one response
+		var response interface{}
+		assert.Nil(ts.T(), json.Unmarshal(rec.Body.Bytes(), &response), rec.Body.String())
+		return rec.Code, response
+	}
+
+	viewer := newMember(ts, vm.Project, project.RoleViewer)
+	operator := newMember(ts, vm.Project, project.RoleOperator)
+	owner := newMember(ts, vm.Project, project.RoleOwner)
+	stranger := newMember(ts, vm.Project, "")
+
+	// Requests that get past the role check fail on their bodies, so
+	// nothing is changed
+	const badBody = `"not a request"`
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		passed int // status once the role check passes
+		wanted string
+	}{
+		{"GET", "", "", http.StatusOK, project.RoleViewer},
+		{"GET", "/state", "", http.StatusOK, project.RoleViewer},
+		{"GET", "/disks", "", http.StatusOK, project.RoleViewer},
+		{"PATCH", "", badBody, http.StatusBadRequest, project.RoleOperator},
+		{"PATCH", "/state", badBody, http.StatusBadRequest, project.RoleOperator},
+		{"POST", "/disks", badBody, http.StatusBadRequest, project.RoleOperator},
+		{"POST", "/transfer", badBody, http.StatusBadRequest, project.RoleOwner},
+	}
+
+	for _, c := range cases {
+		for role, profile := range map[string]uuid.UUID{
+			project.RoleViewer:   viewer,
+			project.RoleOperator: operator,
+			project.RoleOwner:    owner,
+		} {
+			status, response := call(profile, c.method, c.path, c.body)
+			if project.Allows(role, c.wanted) {
+				assert.Equal(ts.T(), c.passed, status, "%s %s as %s: %v", c.method, c.path, role, response)
+			} else {
+				assert.Equal(ts.T(), http.StatusForbidden, status, "%s %s as %s: %v", c.method, c.path, role, response)
+			}
+		}
+
+		status, _ := call(stranger, c.method, c.path, c.body)
+		assert.Equal(ts.T(), http.StatusForbidden, status, "%s %s as a stranger", c.method, c.path)
+	}
+
+	// The console takes an operator, and is refused once
+	status, _ := call(viewer, "GET", "/console", "")
+	assert.Equal(ts.T(), http.StatusForbidden, status)
+
+	// A state auto can't give is an error, and only that
+	hv.Auto = &auto.Auto{Url: "https://" + fakeAutoHostname + ":1", Serial: fakeSerial}
+	status, _ = call(viewer, "GET", "/state", "")
+	assert.Equal(ts.T(), http.StatusInternalServerError, status)
+
+	req := httptest.NewRequest("GET", "/virtual_machines/not-a-uuid/", nil)
+	req.Header.Set("X-Owner", owner.String())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(ts.T(), http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest("GET", "/virtual_machines/"+uuid.New().String()+"/", nil)
+	req.Header.Set("X-Owner", owner.String())
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(ts.T(), http.StatusNotFound, rec.Code)
+}